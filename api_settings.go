@@ -6,4 +6,14 @@ type APISettings struct {
 	APIKey      string
 	APIEndpoint string
 	APIType     APIType
+
+	// APIKeyRef is the reference the API key was loaded from in a
+	// configuration file, either ${NAME} for an environment variable or the
+	// path of a file. It is written back out instead of the API key when the
+	// configuration is exported.
+	APIKeyRef string
+
+	// Credentials provides the API key for every request. If it is set,
+	// APIKey is not used.
+	Credentials CredentialProvider
 }
@@ -10,3 +10,17 @@ const (
 	Mistral
 	Anthropic
 )
+
+// String returns the lowercase name of the API type.
+func (t APIType) String() string {
+	switch t {
+	case OpenAI:
+		return "openai"
+	case Mistral:
+		return "mistral"
+	case Anthropic:
+		return "anthropic"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,233 @@
+package multi_ai_client
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// BudgetScope is an enum representing what a budget applies to.
+type BudgetScope int
+
+const (
+	// BudgetTotal applies to all responses of the client.
+	BudgetTotal BudgetScope = iota
+	// BudgetPerUser applies to the responses for each end user, as set by the
+	// user setting for OpenAI, or the metadata user_id setting for Anthropic.
+	BudgetPerUser
+	// BudgetPerModel applies to the responses of each model definition.
+	BudgetPerModel
+)
+
+func (s BudgetScope) String() string {
+	switch s {
+	case BudgetTotal:
+		return "total"
+	case BudgetPerUser:
+		return "user"
+	case BudgetPerModel:
+		return "model"
+	default:
+		return "invalid"
+	}
+}
+
+// Budget is a struct representing a daily limit on spending.
+// Days are counted in UTC.
+// Hard budgets are checked before the requests are sent, against the spending
+// recorded so far. The spending of a response is recorded when it is done, so
+// responses that are created concurrently are not counted against each other,
+// and can together exceed a hard budget by the spending of all but one of
+// them.
+// The output of a response is estimated as the reserved output tokens of its
+// model definition. OpenAI and Mistral models without max_tokens or
+// ReservedOutputTokens reserve none, so their estimate counts the input only.
+type Budget struct {
+	Scope BudgetScope
+
+	// Key restricts a per user or per model budget to the user or model
+	// definition with this name. If it is empty, the budget applies to every
+	// user or model separately.
+	Key string
+
+	// MaxCost is the maximum amount of dollars spent per day. 0 means no limit.
+	MaxCost float64
+
+	// MaxTokens is the maximum amount of input and output tokens used per day.
+	// 0 means no limit.
+	MaxTokens int
+
+	// Soft budgets never refuse requests. Instead, the OnSoftLimit callback
+	// of the client is called when spending crosses the limit.
+	Soft bool
+}
+
+// Spend is a struct representing an amount spent against a budget.
+type Spend struct {
+	Cost   float64 `json:"cost"`
+	Tokens int     `json:"tokens"`
+}
+
+// exceeds returns whether the spend is over the limits of the budget.
+func (b Budget) exceeds(spend Spend) bool {
+	return (b.MaxCost > 0 && spend.Cost > b.MaxCost) || (b.MaxTokens > 0 && spend.Tokens > b.MaxTokens)
+}
+
+// keyFor returns the key the budget tracks spending under for a response of
+// the given model and user, or an empty string if it does not apply to it.
+func (b Budget) keyFor(day string, model string, user string) string {
+	switch b.Scope {
+	case BudgetTotal:
+		return day + "/total"
+	case BudgetPerUser:
+		if user == "" || (b.Key != "" && b.Key != user) {
+			return ""
+		}
+		return day + "/user/" + user
+	case BudgetPerModel:
+		if b.Key != "" && b.Key != model {
+			return ""
+		}
+		return day + "/model/" + model
+	default:
+		return ""
+	}
+}
+
+// BudgetExceededError is returned by CreateResponse when sending the request
+// could exceed a hard budget.
+type BudgetExceededError struct {
+	Budget Budget
+	// Key is the key the budget tracks spending under, for example
+	// "2024-05-01/user/alice".
+	Key string
+	// Spent is the amount already spent today.
+	Spent Spend
+	// Estimated is the estimated maximum amount the request would add.
+	Estimated Spend
+}
+
+func (e *BudgetExceededError) Error() string {
+	limit := ""
+	if e.Budget.MaxCost > 0 {
+		limit = fmt.Sprintf("$%.4f", e.Budget.MaxCost)
+	}
+	if e.Budget.MaxTokens > 0 {
+		if limit != "" {
+			limit += " or "
+		}
+		limit += strconv.Itoa(e.Budget.MaxTokens) + " tokens"
+	}
+	return fmt.Sprintf("budget %s exceeded: spent $%.4f and %d tokens, request may add $%.4f and %d tokens, limit is %s",
+		e.Key, e.Spent.Cost, e.Spent.Tokens, e.Estimated.Cost, e.Estimated.Tokens, limit)
+}
+
+// getBudgetStore returns the budget store of the client, creating a
+// MemoryBudgetStore if none was set.
+func (c *Client) getBudgetStore() BudgetStore {
+	clientInit.Lock()
+	defer clientInit.Unlock()
+	if c.BudgetStore == nil {
+		c.BudgetStore = NewMemoryBudgetStore()
+	}
+	return c.BudgetStore
+}
+
+// budgetDay returns the day spending is currently counted for.
+func budgetDay() string {
+	return time.Now().UTC().Format("2006-01-02")
+}
+
+// checkBudgets estimates the maximum spend of sending the chat to each model
+// definition, and returns a *BudgetExceededError if that could exceed a hard
+// budget. Nothing is reserved, so the spending of responses that are still
+// streaming is not counted. The budgets are checked in order, so the error is
+// about the first budget that could be exceeded.
+func (c *Client) checkBudgets(chat Chat, modelDefinitions []ModelDefinition) error {
+	if len(c.Budgets) == 0 {
+		return nil
+	}
+
+	type budgetKey struct {
+		budget int
+		key    string
+	}
+
+	day := budgetDay()
+	estimates := make(map[budgetKey]Spend)
+	var order []budgetKey
+	pricing := c.GetPricing()
+	for _, m := range modelDefinitions {
+		usage := Usage{
+			InputTokens:  m.CountTokens(m.FitChat(chat)),
+			OutputTokens: m.GetReservedOutputTokens(),
+		}
+		estimate := Spend{Tokens: usage.InputTokens + usage.OutputTokens}
+		if price, ok := m.GetPricing(pricing); ok {
+			estimate.Cost = price.Cost(usage)
+		}
+		for i, b := range c.Budgets {
+			key := b.keyFor(day, m.Name, userOf(m.ModelSettings))
+			if b.Soft || key == "" {
+				continue
+			}
+			k := budgetKey{i, key}
+			sum, ok := estimates[k]
+			if !ok {
+				order = append(order, k)
+			}
+			sum.Cost += estimate.Cost
+			sum.Tokens += estimate.Tokens
+			estimates[k] = sum
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return order[i].budget < order[j].budget
+	})
+	store := c.getBudgetStore()
+	for _, k := range order {
+		estimate := estimates[k]
+		spent, err := store.Get(k.key)
+		if err != nil {
+			return err
+		}
+		b := c.Budgets[k.budget]
+		if b.exceeds(Spend{Cost: spent.Cost + estimate.Cost, Tokens: spent.Tokens + estimate.Tokens}) {
+			return &BudgetExceededError{Budget: b, Key: k.key, Spent: spent, Estimated: estimate}
+		}
+	}
+	return nil
+}
+
+// recordSpend adds the actual spend of a response to every budget it applies
+// to, and calls OnSoftLimit for soft budgets it pushes over their limit.
+func recordSpend(store BudgetStore, budgets []Budget, onSoftLimit func(Budget, string, Spend), model string, user string, usage Usage, cost float64) {
+	if len(budgets) == 0 {
+		return
+	}
+
+	day := budgetDay()
+	spend := Spend{Cost: cost, Tokens: usage.InputTokens + usage.OutputTokens}
+	recorded := make(map[string]Spend)
+	for _, b := range budgets {
+		key := b.keyFor(day, model, user)
+		if key == "" {
+			continue
+		}
+		total, ok := recorded[key]
+		if !ok {
+			var err error
+			total, err = store.Add(key, spend)
+			if err != nil {
+				continue
+			}
+			recorded[key] = total
+		}
+		before := Spend{Cost: total.Cost - spend.Cost, Tokens: total.Tokens - spend.Tokens}
+		if b.Soft && onSoftLimit != nil && !b.exceeds(before) && b.exceeds(total) {
+			onSoftLimit(b, key, total)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package multi_ai_client
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BudgetStore is an interface representing the storage of spending against
+// budgets. Implementations that are shared between processes allow them to
+// share budgets.
+// Keys start with the day the spending is counted for, as in
+// "2024-05-01/total". Only the spending of the current day is ever read, so
+// the stores of this package drop the spending of earlier days when spending
+// of a later day is added.
+type BudgetStore interface {
+	// Get returns the amount spent under the given key.
+	Get(key string) (Spend, error)
+
+	// Add adds to the amount spent under the given key, and returns the new
+	// total. It must be atomic with respect to other calls to Add.
+	Add(key string, spend Spend) (Spend, error)
+}
+
+// MemoryBudgetStore is a BudgetStore that keeps spending in memory.
+// It is safe for concurrent use.
+type MemoryBudgetStore struct {
+	mu    sync.Mutex
+	spent map[string]Spend
+}
+
+// NewMemoryBudgetStore creates a new, empty MemoryBudgetStore.
+func NewMemoryBudgetStore() *MemoryBudgetStore {
+	return &MemoryBudgetStore{spent: make(map[string]Spend)}
+}
+
+func (s *MemoryBudgetStore) Get(key string) (Spend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.spent[key], nil
+}
+
+func (s *MemoryBudgetStore) Add(key string, spend Spend) (Spend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pruneBudgetDays(s.spent, key)
+	total := s.spent[key]
+	total.Cost += spend.Cost
+	total.Tokens += spend.Tokens
+	s.spent[key] = total
+	return total, nil
+}
+
+// budgetDayOf returns the day a budget key counts spending for, or an empty
+// string if it does not start with a day.
+func budgetDayOf(key string) string {
+	day, _, found := strings.Cut(key, "/")
+	if !found {
+		return ""
+	}
+	if _, err := time.Parse("2006-01-02", day); err != nil {
+		return ""
+	}
+	return day
+}
+
+// pruneBudgetDays removes the spending of the days before the day of key.
+func pruneBudgetDays(spent map[string]Spend, key string) {
+	day := budgetDayOf(key)
+	if day == "" {
+		return
+	}
+	for k := range spent {
+		// Days in ISO format sort in order.
+		if d := budgetDayOf(k); d != "" && d < day {
+			delete(spent, k)
+		}
+	}
+}
+
+// FileBudgetStore is a BudgetStore that keeps spending in a JSON file.
+// Updates are guarded by a lock file next to it, so several processes can
+// share the same file.
+type FileBudgetStore struct {
+	// Path is the path of the JSON file. It is created if it does not exist.
+	Path string
+
+	// StaleLockAge is the age after which a lock file is assumed to be left
+	// behind by a crashed process, and removed. If it is 0, 10 seconds is used.
+	// It must be longer than any process holds the lock.
+	StaleLockAge time.Duration
+
+	mu sync.Mutex
+}
+
+// NewFileBudgetStore creates a new FileBudgetStore using the file at path.
+func NewFileBudgetStore(path string) *FileBudgetStore {
+	return &FileBudgetStore{Path: path}
+}
+
+func (s *FileBudgetStore) Get(key string) (Spend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	spent, err := s.read()
+	if err != nil {
+		return Spend{}, err
+	}
+	return spent[key], nil
+}
+
+func (s *FileBudgetStore) Add(key string, spend Spend) (Spend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, err := s.lock()
+	if err != nil {
+		return Spend{}, err
+	}
+	defer l.unlock()
+
+	spent, err := s.read()
+	if err != nil {
+		return Spend{}, err
+	}
+	pruneBudgetDays(spent, key)
+	total := spent[key]
+	total.Cost += spend.Cost
+	total.Tokens += spend.Tokens
+	spent[key] = total
+
+	data, err := json.MarshalIndent(spent, "", "  ")
+	if err != nil {
+		return Spend{}, err
+	}
+	tmp := s.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return Spend{}, err
+	}
+	if !l.held() {
+		// The lock was taken by another process, which may have read the
+		// spending before this update.
+		_ = os.Remove(tmp)
+		return Spend{}, errors.New("lost budget lock file " + l.path + " to another process")
+	}
+	if err := os.Rename(tmp, s.Path); err != nil {
+		return Spend{}, err
+	}
+	return total, nil
+}
+
+func (s *FileBudgetStore) read() (map[string]Spend, error) {
+	spent := make(map[string]Spend)
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return spent, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return spent, nil
+	}
+	if err := json.Unmarshal(data, &spent); err != nil {
+		return nil, err
+	}
+	return spent, nil
+}
+
+// fileLock is a lock file holding a token unique to the lock.
+type fileLock struct {
+	path  string
+	token string
+}
+
+// held returns whether the lock file still holds the token of this lock.
+func (l *fileLock) held() bool {
+	owner, err := os.ReadFile(l.path)
+	return err == nil && string(owner) == l.token
+}
+
+func (l *fileLock) unlock() {
+	removeLock(l.path, l.token)
+}
+
+func (s *FileBudgetStore) lock() (*fileLock, error) {
+	path := s.Path + ".lock"
+	staleAge := s.StaleLockAge
+	if staleAge == 0 {
+		staleAge = 10 * time.Second
+	}
+	// The lock file holds a token unique to this lock, so it is only ever
+	// removed by its owner, or as the stale lock it was seen to be.
+	token := strconv.Itoa(os.Getpid()) + "." + strconv.FormatInt(time.Now().UnixNano(), 36)
+	deadline := time.Now().Add(2 * staleAge)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			_, err = f.WriteString(token)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return nil, err
+			}
+			return &fileLock{path: path, token: token}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		// The token is read before the age, so a lock file replaced in
+		// between is never taken for stale.
+		if owner, err := os.ReadFile(path); err == nil {
+			if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > staleAge {
+				removeLock(path, string(owner))
+				continue
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("timed out waiting for budget lock file " + path)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// removeLock removes the lock file at path if it still holds the given token.
+// Another process may have replaced it, when it removed the same stale lock
+// file first, and its lock must be kept. If yet another process locks while
+// the lock is briefly taken away, the lock can not be put back; its owner
+// then finds the lock lost before it writes, and fails.
+func removeLock(path string, token string) {
+	// Renaming is atomic, so of all processes removing the same lock file,
+	// only one takes it, and it can check what it took before removing it.
+	taken := path + "." + strconv.Itoa(os.Getpid()) + "." + strconv.FormatInt(time.Now().UnixNano(), 36)
+	if err := os.Rename(path, taken); err != nil {
+		return
+	}
+	if owner, err := os.ReadFile(taken); err == nil && string(owner) != token {
+		// This is the lock of another process: put it back, unless yet another
+		// process has locked in the meantime. Then the lock holds the token of
+		// that process, so fileLock.held fails for the owner.
+		_ = os.Link(taken, path)
+	}
+	_ = os.Remove(taken)
+}
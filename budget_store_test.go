@@ -0,0 +1,134 @@
+package multi_ai_client
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileBudgetStoreConcurrentAdd(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		// Separate stores share the file as separate processes would.
+		store := NewFileBudgetStore(path)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				if _, err := store.Add("2024-05-01/total", Spend{Cost: 0.5, Tokens: 10}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	spent, err := NewFileBudgetStore(path).Get("2024-05-01/total")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if spent != (Spend{Cost: 50, Tokens: 1000}) {
+		t.Errorf("spent %+v, want all additions", spent)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Errorf("lock file left behind: %v", err)
+	}
+}
+
+func TestFileBudgetStoreStaleLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	lock := path + ".lock"
+	if err := os.WriteFile(lock, []byte("1.crashed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	store := &FileBudgetStore{Path: path, StaleLockAge: time.Second}
+	if _, err := store.Add("2024-05-01/total", Spend{Tokens: 1}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("files %v, want only the budget file", entries)
+	}
+}
+
+func TestRemoveLockKeepsNewLock(t *testing.T) {
+	lock := filepath.Join(t.TempDir(), "budget.json.lock")
+	if err := os.WriteFile(lock, []byte("1.stale"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	removeLock(lock, "1.stale")
+
+	// Another process removed the stale lock first and locked again.
+	if err := os.WriteFile(lock, []byte("2.new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	removeLock(lock, "1.stale")
+	if _, err := os.Stat(lock); err != nil {
+		t.Errorf("the lock of another process was removed: %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(lock))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("files %v, want only the lock file", entries)
+	}
+}
+
+func TestFileBudgetStoreLostLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	store := NewFileBudgetStore(path)
+	l, err := store.lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !l.held() {
+		t.Fatal("the new lock is not held")
+	}
+
+	// A process removed the lock as stale, and a third one locked while it
+	// was taken away, so it could not be put back.
+	if err := os.WriteFile(l.path, []byte("3.thief"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if l.held() {
+		t.Error("the lock is held after it was taken")
+	}
+	l.unlock()
+	if owner, err := os.ReadFile(l.path); err != nil || string(owner) != "3.thief" {
+		t.Errorf("lock file %q and error %v, want the lock of the other process kept", owner, err)
+	}
+}
+
+func TestBudgetStorePrunesDays(t *testing.T) {
+	stores := map[string]BudgetStore{
+		"memory": NewMemoryBudgetStore(),
+		"file":   NewFileBudgetStore(filepath.Join(t.TempDir(), "budget.json")),
+	}
+	for name, store := range stores {
+		for _, key := range []string{"2024-04-30/total", "2024-04-30/user/alice", "2024-05-01/total", "custom"} {
+			if _, err := store.Add(key, Spend{Tokens: 1}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for key, want := range map[string]int{"2024-04-30/total": 0, "2024-04-30/user/alice": 0, "2024-05-01/total": 1, "custom": 1} {
+			spent, err := store.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spent.Tokens != want {
+				t.Errorf("%s: %s has %d tokens, want %d", name, key, spent.Tokens, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package multi_ai_client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/guregu/null/v5"
+)
+
+func TestCheckBudgetsOrder(t *testing.T) {
+	client := &Client{Budgets: []Budget{
+		{Scope: BudgetPerModel, MaxTokens: 1},
+		{Scope: BudgetTotal, MaxTokens: 1},
+		{Scope: BudgetPerModel, Key: "B", MaxTokens: 1},
+	}}
+	definitions := []ModelDefinition{
+		NewModelDefinition("A", OpenAI, "", "gpt-4o"),
+		NewModelDefinition("B", OpenAI, "", "gpt-4o"),
+	}
+	chat := Chat{}
+	chat.AddUserMessage("Hi!")
+
+	// Every budget is exceeded, and the first budget for the first model
+	// definition is reported every time.
+	for i := 0; i < 20; i++ {
+		err := client.checkBudgets(chat, definitions)
+		var budgetError *BudgetExceededError
+		if !errors.As(err, &budgetError) {
+			t.Fatalf("error %v, want a BudgetExceededError", err)
+		}
+		if budgetError.Budget != client.Budgets[0] || !strings.HasSuffix(budgetError.Key, "/model/A") {
+			t.Fatalf("exceeded %+v under %s, want the first budget for A", budgetError.Budget, budgetError.Key)
+		}
+	}
+}
+
+func TestCheckBudgetsEstimate(t *testing.T) {
+	chat := Chat{}
+	chat.AddUserMessage("Hi!")
+	m := NewModelDefinition("A", OpenAI, "", "gpt-4o")
+	input := m.CountTokens(chat)
+	client := &Client{Budgets: []Budget{{Scope: BudgetTotal, MaxTokens: input}}}
+
+	// Without max_tokens, no output tokens are reserved.
+	if err := client.checkBudgets(chat, []ModelDefinition{m}); err != nil {
+		t.Errorf("without max_tokens: %v", err)
+	}
+	m.ModelSettings.(*ModelSettingsOpenAI).MaxTokens = null.IntFrom(1)
+	var budgetError *BudgetExceededError
+	if err := client.checkBudgets(chat, []ModelDefinition{m}); !errors.As(err, &budgetError) || budgetError.Estimated.Tokens != input+1 {
+		t.Errorf("with max_tokens: error %v, want an estimate of %d tokens", err, input+1)
+	}
+	m.ReservedOutputTokens = 10
+	if err := client.checkBudgets(chat, []ModelDefinition{m}); !errors.As(err, &budgetError) || budgetError.Estimated.Tokens != input+10 {
+		t.Errorf("with ReservedOutputTokens: error %v, want an estimate of %d tokens", err, input+10)
+	}
+}
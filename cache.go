@@ -0,0 +1,246 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ResponseCache is an interface representing a store of raw response streams,
+// keyed by a hash of the request they answer.
+type ResponseCache interface {
+	// Get returns the cached response stream for the key, and whether it was
+	// found. Expired entries are never returned.
+	Get(key string) ([]byte, bool)
+
+	// Set stores a response stream under the key. A ttl of 0 means the entry
+	// never expires.
+	Set(key string, stream []byte, ttl time.Duration) error
+}
+
+// CachePolicy is an enum representing which requests are answered from the
+// cache.
+type CachePolicy int
+
+const (
+	// CacheAll caches every request.
+	CacheAll CachePolicy = iota
+	// CacheDeterministic only caches requests with a temperature of 0 or a
+	// fixed seed, whose responses are expected to be repeatable.
+	CacheDeterministic
+)
+
+type bypassCacheKey struct{}
+
+// BypassCache returns a context that makes CreateResponseContext skip the
+// cache, both for reading and for storing responses.
+func BypassCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassCacheKey{}, true)
+}
+
+func cacheBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassCacheKey{}).(bool)
+	return bypass
+}
+
+// ResponseCacheKey returns the key a request of the model definition is cached
+// under: a hash of the API type, the endpoint and the body of the request.
+// The body is normalized first, so the order of its keys does not matter.
+func ResponseCacheKey(m *ModelDefinition, req *http.Request) (string, error) {
+	if req.GetBody == nil {
+		return "", errors.New("request body can not be read more than once")
+	}
+	r, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return "", err
+	}
+	normalized, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	h.Write([]byte(strconv.Itoa(int(m.APISettings.APIType))))
+	h.Write([]byte{0})
+	h.Write([]byte(req.URL.String()))
+	h.Write([]byte{0})
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// isDeterministic returns whether the settings ask for a repeatable response,
+// by setting the temperature to 0 or fixing the seed.
+func isDeterministic(settings ModelSettings) bool {
+	switch s := settings.(type) {
+	case *ModelSettingsOpenAI:
+		return (s.Temperature.Valid && s.Temperature.Float64 == 0) || s.Seed.Valid
+	case *ModelSettingsMistral:
+		return (s.Temperature.Valid && s.Temperature.Float64 == 0) || s.RandomSeed.Valid
+	case *ModelSettingsAnthropic:
+		return s.Temperature.Valid && s.Temperature.Float64 == 0
+	default:
+		return false
+	}
+}
+
+// MemoryCache is a ResponseCache that keeps the most recently used responses
+// in memory. It is safe for concurrent use.
+type MemoryCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type memoryCacheEntry struct {
+	key     string
+	stream  []byte
+	expires time.Time
+}
+
+// NewMemoryCache creates a new MemoryCache holding at most capacity responses.
+// When it is full, the least recently used response is evicted.
+func NewMemoryCache(capacity int) *MemoryCache {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &MemoryCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*memoryCacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(e)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return entry.stream, true
+}
+
+func (c *MemoryCache) Set(key string, stream []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &memoryCacheEntry{key: key, stream: stream}
+	if ttl > 0 {
+		entry.expires = time.Now().Add(ttl)
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+// Len returns the amount of responses in the cache, including expired ones
+// that were not evicted yet.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// DiskCache is a ResponseCache that keeps responses as files in a directory.
+type DiskCache struct {
+	// Dir is the directory the responses are stored in. It is created if it
+	// does not exist.
+	Dir string
+}
+
+// NewDiskCache creates a new DiskCache storing responses in dir.
+func NewDiskCache(dir string) *DiskCache {
+	return &DiskCache{Dir: dir}
+}
+
+type diskCacheEntry struct {
+	Expires time.Time `json:"expires,omitempty"`
+	Stream  []byte    `json:"stream"`
+}
+
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false
+	}
+	if !entry.Expires.IsZero() && time.Now().After(entry.Expires) {
+		_ = os.Remove(c.path(key))
+		return nil, false
+	}
+	return entry.Stream, true
+}
+
+func (c *DiskCache) Set(key string, stream []byte, ttl time.Duration) error {
+	if err := os.MkdirAll(c.Dir, 0o755); err != nil {
+		return err
+	}
+	entry := diskCacheEntry{Stream: stream}
+	if ttl > 0 {
+		entry.Expires = time.Now().Add(ttl)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp := c.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(key))
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// cachingReader passes a response stream through while keeping a copy of it.
+type cachingReader struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (c *cachingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.buf.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,235 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := NewMemoryCache(2)
+	_ = cache.Set("a", []byte("A"), 0)
+	_ = cache.Set("b", []byte("B"), 0)
+	// Reading a makes b the least recently used response.
+	if stream, ok := cache.Get("a"); !ok || string(stream) != "A" {
+		t.Fatalf("Get(a) = %q, %v", stream, ok)
+	}
+	_ = cache.Set("c", []byte("C"), 0)
+	if _, ok := cache.Get("b"); ok {
+		t.Error("the least recently used response was not evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("%s was evicted", key)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", cache.Len())
+	}
+
+	// Replacing a response does not evict another one.
+	_ = cache.Set("a", []byte("A2"), 0)
+	if stream, _ := cache.Get("a"); string(stream) != "A2" || cache.Len() != 2 {
+		t.Errorf("Get(a) = %q with %d responses, want A2 with 2", stream, cache.Len())
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	caches := map[string]ResponseCache{
+		"memory": NewMemoryCache(10),
+		"disk":   NewDiskCache(t.TempDir()),
+	}
+	for name, cache := range caches {
+		if err := cache.Set("expiring", []byte("stream"), 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		if err := cache.Set("forever", []byte("stream"), 0); err != nil {
+			t.Fatal(err)
+		}
+		if stream, ok := cache.Get("expiring"); !ok || string(stream) != "stream" {
+			t.Errorf("%s: Get before the TTL = %q, %v", name, stream, ok)
+		}
+		time.Sleep(20 * time.Millisecond)
+		if _, ok := cache.Get("expiring"); ok {
+			t.Errorf("%s: an expired response was returned", name)
+		}
+		if _, ok := cache.Get("forever"); !ok {
+			t.Errorf("%s: a response without TTL expired", name)
+		}
+		if _, ok := cache.Get("missing"); ok {
+			t.Errorf("%s: a missing response was found", name)
+		}
+	}
+}
+
+func TestDiskCachePersists(t *testing.T) {
+	dir := t.TempDir()
+	stream := []byte("data: {\"choices\": []}\n\ndata: [DONE]\n\n")
+	if err := NewDiskCache(dir).Set("key", stream, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if got, ok := NewDiskCache(dir).Get("key"); !ok || !bytes.Equal(got, stream) {
+		t.Errorf("Get = %q, %v, want the stored stream", got, ok)
+	}
+}
+
+func TestResponseCacheKey(t *testing.T) {
+	m := NewModelDefinition("GPT", OpenAI, "key", "gpt-4o")
+	key := func(m ModelDefinition, url string, body string) string {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := ResponseCacheKey(&m, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+	const url = "https://api.openai.com/v1/chat/completions"
+	base := key(m, url, `{"model": "gpt-4o", "temperature": 0}`)
+	if got := key(m, url, `{"temperature":0,"model":"gpt-4o"}`); got != base {
+		t.Error("the order of the keys of the body changed the key")
+	}
+	if got := key(m, url, `{"model": "gpt-4o", "temperature": 1}`); got == base {
+		t.Error("a different body has the same key")
+	}
+	if got := key(m, "https://example.com/v1/chat/completions", `{"model": "gpt-4o", "temperature": 0}`); got == base {
+		t.Error("a different endpoint has the same key")
+	}
+	mistral := NewModelDefinition("Mistral", Mistral, "key", "gpt-4o")
+	if got := key(mistral, url, `{"model": "gpt-4o", "temperature": 0}`); got == base {
+		t.Error("a different API type has the same key")
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, url, io.NopCloser(strings.NewReader(`{}`)))
+	if _, err := ResponseCacheKey(&m, req); err == nil {
+		t.Error("a body that can only be read once has a key")
+	}
+}
+
+// cachingServer answers requests with the given stream, and counts them.
+type cachingServer struct {
+	mu       sync.Mutex
+	stream   string
+	requests int
+}
+
+func (s *cachingServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(s.stream)),
+		Request:    req,
+	}, nil
+}
+
+// respondTwice sends the same chat to the model definition twice, and returns
+// the last chunks of both responses.
+func respondTwice(t *testing.T, client *Client, ctx context.Context, m ModelDefinition) [2]MessageChunk {
+	t.Helper()
+	var last [2]MessageChunk
+	for i := range last {
+		chat := Chat{}
+		chat.AddUserMessage("Say hello.")
+		_, ch, err := client.CreateResponseForChat(ctx, &chat, []ModelDefinition{m})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for chunk := range ch {
+			last[i] = chunk
+		}
+	}
+	return last
+}
+
+func TestClientCache(t *testing.T) {
+	const complete = `data: {"choices": [{"delta": {"content": "Hello."}, "finish_reason": "stop"}]}` + "\n\ndata: [DONE]\n\n"
+	deterministic := NewModelDefinition("GPT", OpenAI, "key", "gpt-4o")
+	_ = deterministic.ModelSettings.Set("temperature", 0.0)
+	random := NewModelDefinition("GPT", OpenAI, "key", "gpt-4o")
+
+	tests := []struct {
+		name     string
+		stream   string
+		policy   CachePolicy
+		model    ModelDefinition
+		bypass   bool
+		requests int
+		err      error
+	}{
+		{"cached", complete, CacheAll, random, false, 1, nil},
+		{"deterministic", complete, CacheDeterministic, deterministic, false, 1, nil},
+		{"not deterministic", complete, CacheDeterministic, random, false, 2, nil},
+		{"bypassed", complete, CacheAll, random, true, 2, nil},
+		{"cut off", `data: {"choices": [{"delta": {"content": "Hel"}}]}` + "\n\n", CacheAll, random, false, 2, io.ErrUnexpectedEOF},
+		{"error event", `data: {"choices": [{"delta": {"content": "Hel"}}]}` + "\n\n" + `data: {"error": {"message": "failed"}}` + "\n\n", CacheAll, random, false, 2, &StreamError{}},
+	}
+	for _, test := range tests {
+		server := &cachingServer{stream: test.stream}
+		client := &Client{HTTPClient: &http.Client{Transport: server}, Cache: NewMemoryCache(10), CachePolicy: test.policy}
+		ctx := context.Background()
+		if test.bypass {
+			ctx = BypassCache(ctx)
+		}
+		last := respondTwice(t, client, ctx, test.model)
+		if server.requests != test.requests {
+			t.Errorf("%s: sent %d requests, want %d", test.name, server.requests, test.requests)
+		}
+		for i, chunk := range last {
+			var streamError *StreamError
+			switch {
+			case test.err == nil && chunk.Err != nil:
+				t.Errorf("%s: response %d: %v", test.name, i, chunk.Err)
+			case errors.As(test.err, &streamError) && !errors.As(chunk.Err, &streamError):
+				t.Errorf("%s: response %d: error %v, want a *StreamError", test.name, i, chunk.Err)
+			case test.err == io.ErrUnexpectedEOF && !errors.Is(chunk.Err, io.ErrUnexpectedEOF):
+				t.Errorf("%s: response %d: error %v, want io.ErrUnexpectedEOF", test.name, i, chunk.Err)
+			}
+		}
+		if test.requests == 1 && last[1].Cost != 0 {
+			t.Errorf("%s: the cached response cost %v", test.name, last[1].Cost)
+		}
+	}
+}
+
+func TestAnthropicStreamTerminators(t *testing.T) {
+	const start = "event: message_start\n" +
+		`data: {"type": "message_start", "message": {"usage": {"input_tokens": 10, "output_tokens": 1}}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hello."}}` + "\n\n"
+	const stop = "event: message_delta\n" +
+		`data: {"type": "message_delta", "delta": {"stop_reason": "end_turn"}, "usage": {"output_tokens": 3}}` + "\n\n"
+	tests := []struct {
+		name   string
+		stream string
+		cached bool
+	}{
+		{"message_stop", start + stop + "event: message_stop\n" + `data: {"type": "message_stop"}` + "\n\n", true},
+		{"stop reason", start + stop, true},
+		{"cut off", start, false},
+	}
+	for _, test := range tests {
+		server := &cachingServer{stream: test.stream}
+		client := &Client{HTTPClient: &http.Client{Transport: server}, Cache: NewMemoryCache(10)}
+		last := respondTwice(t, client, context.Background(), NewModelDefinition("Claude", Anthropic, "key", "claude-3-5-haiku-20241022"))
+		if got := server.requests == 1; got != test.cached {
+			t.Errorf("%s: cached %v, want %v", test.name, got, test.cached)
+		}
+		if test.cached && (last[0].Err != nil || last[1].Err != nil) {
+			t.Errorf("%s: errors %v and %v", test.name, last[0].Err, last[1].Err)
+		}
+		if !test.cached && !errors.Is(last[0].Err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: error %v, want io.ErrUnexpectedEOF", test.name, last[0].Err)
+		}
+	}
+}
@@ -10,10 +10,15 @@ import (
 type Chat struct {
 	systemMessage *Message
 	messages      []Message
+	compaction    *Compaction
+	summary       string
+	archive       []Message
+	undo          []chatState
 }
 
 // SetSystemMessage Adds a system message to the chat.
 func (c *Chat) SetSystemMessage(s string) {
+	c.pushUndo()
 	if s == "" {
 		c.systemMessage = nil
 		return
@@ -29,6 +34,7 @@ func (c *Chat) SetSystemMessage(s string) {
 
 // ClearSystemMessage Removes the system message from the chat.
 func (c *Chat) ClearSystemMessage() {
+	c.pushUndo()
 	c.systemMessage = nil
 }
 
@@ -42,6 +48,7 @@ func (c *Chat) GetSystemMessage() string {
 
 // AddUserMessage Adds a user message to the chat.
 func (c *Chat) AddUserMessage(s string) {
+	c.pushUndo()
 	if c.messages == nil {
 		c.messages = make([]Message, 0)
 	}
@@ -50,6 +57,7 @@ func (c *Chat) AddUserMessage(s string) {
 
 // AddAssistantMessage Adds an assistant message to the chat.
 func (c *Chat) AddAssistantMessage(s string) {
+	c.pushUndo()
 	if c.messages == nil {
 		c.messages = make([]Message, 0)
 	}
@@ -64,13 +72,18 @@ func (c *Chat) ReplaceLastAssistantMessage(s string) {
 	}
 
 	if c.messages[len(c.messages)-1].Type == AssistantMessage {
+		c.pushUndo()
 		c.messages[len(c.messages)-1].Text = s
 	}
 }
 
-// ClearMessages Removes all non-system messages from the chat.
+// ClearMessages Removes all non-system messages from the chat, including the
+// summary and archive of compacted messages.
 func (c *Chat) ClearMessages() {
+	c.pushUndo()
 	c.messages = nil
+	c.summary = ""
+	c.archive = nil
 }
 
 // GetMessages returns all messages in the chat.
@@ -90,6 +103,28 @@ func (c *Chat) GetMessagesWithoutSystemMessage() []Message {
 	return c.messages
 }
 
+// requestMessages returns the messages as they are sent to the models.
+// This includes the summary of compacted messages, either in the system
+// message or as a synthetic exchange before the other messages.
+func (c *Chat) requestMessages(includeSystem bool) []Message {
+	messages := make([]Message, 0, len(c.messages)+3)
+	if system := c.effectiveSystemMessage(); includeSystem && system != "" {
+		messages = append(messages, *NewSystemMessage(system))
+	}
+	messages = append(messages, c.summaryMessages()...)
+	return append(messages, c.messages...)
+}
+
+// normalizedMessages returns the messages as they are sent to the models,
+// normalized so they are accepted by the given API.
+func (c *Chat) normalizedMessages(apiType APIType, includeSystem bool) []Message {
+	messages := c.requestMessages(includeSystem)
+	if len(messages) > 0 && messages[0].Type == SystemMessage {
+		return append(messages[:1], normalizeMessages(apiType, messages[1:])...)
+	}
+	return normalizeMessages(apiType, messages)
+}
+
 // NewChatFromMessages creates a new Chat from a list of messages.
 // The messages are added to the chat in the order they are provided.
 // There may only be one system message in the list of messages, and it must be the first message.
@@ -115,6 +150,7 @@ func NewChatFromMessages(messages []Message) (*Chat, error) {
 		}
 	}
 
+	chat.ClearUndo()
 	return chat, nil
 }
 
@@ -126,6 +162,10 @@ func (c *Chat) String() string {
 		str += c.systemMessage.Text + "\n\n"
 		i += 1
 	}
+	if c.summary != "" {
+		str += "# Summary of " + strconv.Itoa(len(c.archive)) + " compacted messages\n"
+		str += c.summary + "\n\n"
+	}
 	if c.messages == nil {
 		return strings.TrimSpace(str)
 	}
@@ -0,0 +1,131 @@
+package multi_ai_client
+
+import "errors"
+
+// maxUndoDepth is the maximum amount of mutations a chat remembers for Undo.
+const maxUndoDepth = 100
+
+// ErrIndexOutOfRange is returned when a message index does not refer to a
+// message in the chat.
+var ErrIndexOutOfRange = errors.New("message index out of range")
+
+// chatState is a snapshot of the mutable state of a chat, used to undo
+// mutations.
+type chatState struct {
+	systemMessage *Message
+	messages      []Message
+	summary       string
+	archive       []Message
+}
+
+// EditMessage replaces the text of the message at index i.
+// Indices refer to the messages returned by GetMessagesWithoutSystemMessage.
+func (c *Chat) EditMessage(i int, s string) error {
+	if i < 0 || i >= len(c.messages) {
+		return ErrIndexOutOfRange
+	}
+	c.pushUndo()
+	c.messages[i].Text = s
+	return nil
+}
+
+// DeleteMessage removes the message at index i.
+// Indices refer to the messages returned by GetMessagesWithoutSystemMessage.
+func (c *Chat) DeleteMessage(i int) error {
+	if i < 0 || i >= len(c.messages) {
+		return ErrIndexOutOfRange
+	}
+	c.pushUndo()
+	c.messages = append(c.messages[:i:i], c.messages[i+1:]...)
+	return nil
+}
+
+// InsertMessage inserts a message before index i. If i is equal to the amount
+// of messages, the message is appended.
+// Indices refer to the messages returned by GetMessagesWithoutSystemMessage.
+// System messages can not be inserted, use SetSystemMessage instead.
+func (c *Chat) InsertMessage(i int, message Message) error {
+	if i < 0 || i > len(c.messages) {
+		return ErrIndexOutOfRange
+	}
+	if message.Type != UserMessage && message.Type != AssistantMessage {
+		return errors.New("only user and assistant messages can be inserted")
+	}
+	c.pushUndo()
+	messages := make([]Message, 0, len(c.messages)+1)
+	messages = append(messages, c.messages[:i]...)
+	messages = append(messages, message)
+	c.messages = append(messages, c.messages[i:]...)
+	return nil
+}
+
+// TruncateAfter removes all messages after index i. Passing -1 removes all
+// messages, leaving the system message.
+// Indices refer to the messages returned by GetMessagesWithoutSystemMessage.
+func (c *Chat) TruncateAfter(i int) error {
+	if i < -1 || i >= len(c.messages) {
+		return ErrIndexOutOfRange
+	}
+	c.pushUndo()
+	c.messages = c.messages[: i+1 : i+1]
+	return nil
+}
+
+// PopLast removes the last message from the chat and returns it.
+// If the chat has no messages, an error is returned.
+func (c *Chat) PopLast() (Message, error) {
+	if len(c.messages) == 0 {
+		return Message{}, errors.New("chat has no messages")
+	}
+	c.pushUndo()
+	last := c.messages[len(c.messages)-1]
+	c.messages = c.messages[: len(c.messages)-1 : len(c.messages)-1]
+	return last, nil
+}
+
+// CanUndo returns whether there is a mutation that can be undone.
+func (c *Chat) CanUndo() bool {
+	return len(c.undo) > 0
+}
+
+// Undo reverts the last mutation of the chat.
+// It returns false if there was nothing to undo.
+func (c *Chat) Undo() bool {
+	if len(c.undo) == 0 {
+		return false
+	}
+	state := c.undo[len(c.undo)-1]
+	c.undo = c.undo[:len(c.undo)-1]
+	c.systemMessage = state.systemMessage
+	c.messages = state.messages
+	c.summary = state.summary
+	c.archive = state.archive
+	return true
+}
+
+// ClearUndo forgets all mutations, so they can no longer be undone.
+func (c *Chat) ClearUndo() {
+	c.undo = nil
+}
+
+// pushUndo remembers the current state of the chat so the next mutation can
+// be undone.
+func (c *Chat) pushUndo() {
+	state := chatState{
+		summary: c.summary,
+	}
+	if c.systemMessage != nil {
+		system := *c.systemMessage
+		state.systemMessage = &system
+	}
+	if c.messages != nil {
+		state.messages = append(make([]Message, 0, len(c.messages)), c.messages...)
+	}
+	if c.archive != nil {
+		state.archive = append(make([]Message, 0, len(c.archive)), c.archive...)
+	}
+	if len(c.undo) >= maxUndoDepth {
+		c.undo = c.undo[1:]
+	}
+	c.undo = append(c.undo, state)
+}
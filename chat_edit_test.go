@@ -0,0 +1,214 @@
+package multi_ai_client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// editChat returns a chat with a system message and four messages.
+func editChat() Chat {
+	chat := Chat{}
+	chat.SetSystemMessage("Be brief.")
+	chat.AddUserMessage("one")
+	chat.AddAssistantMessage("two")
+	chat.AddUserMessage("three")
+	chat.AddAssistantMessage("four")
+	chat.ClearUndo()
+	return chat
+}
+
+// texts returns the texts of the messages of the chat, without the system
+// message.
+func texts(chat *Chat) []string {
+	texts := make([]string, 0)
+	for _, m := range chat.GetMessagesWithoutSystemMessage() {
+		texts = append(texts, m.Text)
+	}
+	return texts
+}
+
+func TestChatEditing(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Chat) error
+		want   []string
+		err    error
+	}{
+		{"edit", func(c *Chat) error { return c.EditMessage(1, "TWO") }, []string{"one", "TWO", "three", "four"}, nil},
+		{"edit first", func(c *Chat) error { return c.EditMessage(0, "ONE") }, []string{"ONE", "two", "three", "four"}, nil},
+		{"edit negative", func(c *Chat) error { return c.EditMessage(-1, "x") }, nil, ErrIndexOutOfRange},
+		{"edit past end", func(c *Chat) error { return c.EditMessage(4, "x") }, nil, ErrIndexOutOfRange},
+		{"delete", func(c *Chat) error { return c.DeleteMessage(2) }, []string{"one", "two", "four"}, nil},
+		{"delete negative", func(c *Chat) error { return c.DeleteMessage(-1) }, nil, ErrIndexOutOfRange},
+		{"delete past end", func(c *Chat) error { return c.DeleteMessage(4) }, nil, ErrIndexOutOfRange},
+		{"insert", func(c *Chat) error { return c.InsertMessage(1, *NewUserMessage("new")) }, []string{"one", "new", "two", "three", "four"}, nil},
+		{"insert at end", func(c *Chat) error { return c.InsertMessage(4, *NewAssistantMessage("new")) }, []string{"one", "two", "three", "four", "new"}, nil},
+		{"insert negative", func(c *Chat) error { return c.InsertMessage(-1, *NewUserMessage("x")) }, nil, ErrIndexOutOfRange},
+		{"insert past end", func(c *Chat) error { return c.InsertMessage(5, *NewUserMessage("x")) }, nil, ErrIndexOutOfRange},
+		{"insert system", func(c *Chat) error { return c.InsertMessage(0, *NewSystemMessage("x")) }, nil, errors.New("only user and assistant messages can be inserted")},
+		{"truncate", func(c *Chat) error { return c.TruncateAfter(1) }, []string{"one", "two"}, nil},
+		{"truncate all", func(c *Chat) error { return c.TruncateAfter(-1) }, []string{}, nil},
+		{"truncate last", func(c *Chat) error { return c.TruncateAfter(3) }, []string{"one", "two", "three", "four"}, nil},
+		{"truncate below -1", func(c *Chat) error { return c.TruncateAfter(-2) }, nil, ErrIndexOutOfRange},
+		{"truncate past end", func(c *Chat) error { return c.TruncateAfter(4) }, nil, ErrIndexOutOfRange},
+		{"pop", func(c *Chat) error { _, err := c.PopLast(); return err }, []string{"one", "two", "three"}, nil},
+		{"set system message", func(c *Chat) error { c.SetSystemMessage("Be verbose."); return nil }, []string{"one", "two", "three", "four"}, nil},
+		{"clear system message", func(c *Chat) error { c.ClearSystemMessage(); return nil }, []string{"one", "two", "three", "four"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chat := editChat()
+			original := chat.GetMessages()
+			err := test.mutate(&chat)
+			if test.err != nil {
+				if err == nil || err.Error() != test.err.Error() {
+					t.Fatalf("error %v, want %v", err, test.err)
+				}
+				// A failed mutation changes nothing, and can not be undone.
+				if !reflect.DeepEqual(chat.GetMessages(), original) || chat.CanUndo() {
+					t.Errorf("the failed mutation changed the chat: %v", chat.GetMessages())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(&chat); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("messages %q, want %q", got, test.want)
+			}
+
+			if !chat.Undo() {
+				t.Fatal("the mutation can not be undone")
+			}
+			if got := chat.GetMessages(); !reflect.DeepEqual(got, original) {
+				t.Errorf("after undo: messages %v, want %v", got, original)
+			}
+			if chat.CanUndo() || chat.Undo() {
+				t.Error("undid more than the mutation")
+			}
+		})
+	}
+}
+
+func TestEditMessageKeepsSystemMessage(t *testing.T) {
+	chat := editChat()
+	// Indices do not count the system message, so it can not be edited or
+	// deleted by index.
+	if err := chat.EditMessage(0, "edited"); err != nil {
+		t.Fatal(err)
+	}
+	if err := chat.DeleteMessage(3); err != nil {
+		t.Fatal(err)
+	}
+	if chat.GetSystemMessage() != "Be brief." {
+		t.Errorf("system message %q, want it unchanged", chat.GetSystemMessage())
+	}
+	messages := chat.GetMessages()
+	if messages[0].Type != SystemMessage || messages[1].Text != "edited" {
+		t.Errorf("messages %v, want the system message followed by the edited message", messages)
+	}
+
+	chat.SetSystemMessage("Be verbose.")
+	chat.Undo()
+	chat.Undo()
+	chat.Undo()
+	if chat.GetSystemMessage() != "Be brief." || !reflect.DeepEqual(texts(&chat), []string{"one", "two", "three", "four"}) {
+		t.Errorf("after undoing everything: %v", chat.GetMessages())
+	}
+}
+
+func TestPopLastEmpty(t *testing.T) {
+	chat := Chat{}
+	chat.SetSystemMessage("Be brief.")
+	chat.ClearUndo()
+	if _, err := chat.PopLast(); err == nil {
+		t.Error("popped a message of an empty chat")
+	}
+	if chat.GetSystemMessage() != "Be brief." || chat.CanUndo() {
+		t.Error("popping an empty chat changed it")
+	}
+}
+
+func TestUndoDepth(t *testing.T) {
+	chat := Chat{}
+	for i := 0; i < maxUndoDepth+10; i++ {
+		chat.AddUserMessage("message")
+	}
+	undone := 0
+	for chat.Undo() {
+		undone++
+	}
+	if undone != maxUndoDepth {
+		t.Errorf("undid %d mutations, want %d", undone, maxUndoDepth)
+	}
+	if n := len(chat.GetMessages()); n != 10 {
+		t.Errorf("%d messages left, want the 10 that are too old to undo", n)
+	}
+}
+
+func TestRegenerate(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		sent     []string
+		kept     []string
+	}{
+		{"trailing assistant message", []string{"one", "two", "three", "four"}, []string{"one", "two", "three"}, []string{"one", "two", "three"}},
+		{"trailing user message", []string{"one", "two", "three"}, []string{"one", "two", "three"}, []string{"one", "two", "three"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sent []string
+			transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				var body struct {
+					Messages []JsonMessage `json:"messages"`
+				}
+				data, _ := io.ReadAll(req.Body)
+				if err := json.Unmarshal(data, &body); err != nil {
+					t.Error(err)
+				}
+				for _, m := range body.Messages {
+					sent = append(sent, m.Content)
+				}
+				return &http.Response{
+					StatusCode: http.StatusOK,
+					Body:       io.NopCloser(strings.NewReader("data: {\"choices\": [{\"delta\": {\"content\": \"five\"}, \"finish_reason\": \"stop\"}]}\n\ndata: [DONE]\n\n")),
+					Request:    req,
+				}, nil
+			})
+			client := &Client{HTTPClient: &http.Client{Transport: transport}}
+			client.AddModelDefinition(NewModelDefinition("GPT", OpenAI, "key", "gpt-4o"))
+			for i, text := range test.messages {
+				if i%2 == 0 {
+					client.Chat.AddUserMessage(text)
+				} else {
+					client.Chat.AddAssistantMessage(text)
+				}
+			}
+
+			n, ch, err := client.Regenerate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 {
+				t.Fatalf("%d responses, want 1", n)
+			}
+			for chunk := range ch {
+				if chunk.Err != nil {
+					t.Fatal(chunk.Err)
+				}
+			}
+			if !reflect.DeepEqual(sent, test.sent) {
+				t.Errorf("sent %q, want %q", sent, test.sent)
+			}
+			if got := texts(&client.Chat); !reflect.DeepEqual(got, test.kept) {
+				t.Errorf("chat %q, want %q", got, test.kept)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package multi_ai_client
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// placeholderText is the text of the messages inserted during normalization to
+// keep the conversation valid, and of messages that would otherwise be empty.
+const placeholderText = "..."
+
+// ValidationError is an error describing why a chat is not accepted by an API.
+type ValidationError struct {
+	// Index is the index of the offending message, as returned by
+	// GetMessagesWithoutSystemMessage, or -1 if the error concerns the chat as
+	// a whole.
+	Index int
+
+	// Reason describes the problem.
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Index < 0 {
+		return "invalid chat: " + e.Reason
+	}
+	return "invalid chat: message " + strconv.Itoa(e.Index) + ": " + e.Reason
+}
+
+// Validate checks whether the chat would be accepted as-is by the given API.
+// It returns a *ValidationError describing the first problem found, or nil.
+//
+// Note that MakeBody normalizes the chat before sending it, so a chat that
+// fails validation may still work. Validate is meant for callers that want to
+// know whether the chat is sent exactly as it is.
+func (c *Chat) Validate(apiType APIType) error {
+	if len(c.messages) == 0 {
+		return &ValidationError{Index: -1, Reason: "chat has no messages"}
+	}
+
+	for i, m := range c.messages {
+		if m.Type != UserMessage && m.Type != AssistantMessage {
+			return &ValidationError{Index: i, Reason: "only the first message may be a system message"}
+		}
+	}
+
+	switch apiType {
+	case OpenAI:
+		return nil
+	case Mistral:
+		if c.messages[0].Type != UserMessage {
+			return &ValidationError{Index: 0, Reason: "the first message must be a user message"}
+		}
+		return nil
+	case Anthropic:
+		if c.messages[0].Type != UserMessage {
+			return &ValidationError{Index: 0, Reason: "the first message must be a user message"}
+		}
+		for i, m := range c.messages {
+			if strings.TrimSpace(m.Text) == "" && i != len(c.messages)-1 {
+				return &ValidationError{Index: i, Reason: "messages may not be empty"}
+			}
+			if i > 0 && c.messages[i-1].Type == m.Type {
+				return &ValidationError{Index: i, Reason: "consecutive messages may not have the same role"}
+			}
+		}
+		last := c.messages[len(c.messages)-1]
+		if last.Type == AssistantMessage && last.Text != strings.TrimRightFunc(last.Text, unicode.IsSpace) {
+			return &ValidationError{Index: len(c.messages) - 1, Reason: "a final assistant message may not end with whitespace"}
+		}
+		if last.Type == UserMessage && strings.TrimSpace(last.Text) == "" {
+			return &ValidationError{Index: len(c.messages) - 1, Reason: "messages may not be empty"}
+		}
+		return nil
+	default:
+		return &ValidationError{Index: -1, Reason: "invalid API type"}
+	}
+}
+
+// normalizeMessages rewrites a sequence of user and assistant messages so it
+// is accepted by the given API:
+//   - Empty messages get placeholder text.
+//   - Consecutive messages with the same role are merged.
+//   - A placeholder user message is inserted before a leading assistant message.
+//   - Trailing whitespace is stripped from a final assistant message.
+//
+// OpenAI accepts any sequence, so messages are returned unchanged for it.
+func normalizeMessages(apiType APIType, messages []Message) []Message {
+	if apiType == OpenAI || len(messages) == 0 {
+		return messages
+	}
+
+	normalized := make([]Message, 0, len(messages)+1)
+	for i, m := range messages {
+		if strings.TrimSpace(m.Text) == "" && !(apiType == Anthropic && i == len(messages)-1 && m.Type == AssistantMessage) {
+			m.Text = placeholderText
+		}
+		if len(normalized) == 0 && m.Type == AssistantMessage {
+			normalized = append(normalized, *NewUserMessage(placeholderText))
+		}
+		if len(normalized) > 0 && normalized[len(normalized)-1].Type == m.Type {
+			normalized[len(normalized)-1].Text += "\n\n" + m.Text
+			continue
+		}
+		normalized = append(normalized, m)
+	}
+
+	last := &normalized[len(normalized)-1]
+	if apiType == Anthropic && last.Type == AssistantMessage {
+		last.Text = strings.TrimRightFunc(last.Text, unicode.IsSpace)
+		if last.Text == "" {
+			normalized = normalized[:len(normalized)-1]
+		}
+	}
+	return normalized
+}
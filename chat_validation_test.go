@@ -0,0 +1,228 @@
+package multi_ai_client
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// messageList creates messages from pairs of a role and a text.
+func messageList(pairs ...string) []Message {
+	messages := make([]Message, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		switch pairs[i] {
+		case "system":
+			messages = append(messages, *NewSystemMessage(pairs[i+1]))
+		case "user":
+			messages = append(messages, *NewUserMessage(pairs[i+1]))
+		case "assistant":
+			messages = append(messages, *NewAssistantMessage(pairs[i+1]))
+		}
+	}
+	return messages
+}
+
+func TestValidate(t *testing.T) {
+	const ok = -2
+	tests := []struct {
+		name     string
+		system   string
+		messages []Message
+		// index is the index of the error for each API type, -1 for an error
+		// of the whole chat, or ok for no error.
+		index map[APIType]int
+	}{
+		{
+			"valid",
+			"",
+			messageList("user", "Hi!", "assistant", "Hello."),
+			map[APIType]int{OpenAI: ok, Mistral: ok, Anthropic: ok},
+		},
+		{
+			"system message",
+			"Be brief.",
+			messageList("user", "Hi!"),
+			map[APIType]int{OpenAI: ok, Mistral: ok, Anthropic: ok},
+		},
+		{
+			"no messages",
+			"Be brief.",
+			nil,
+			map[APIType]int{OpenAI: -1, Mistral: -1, Anthropic: -1},
+		},
+		{
+			"system message after the first",
+			"",
+			messageList("user", "Hi!", "system", "Be brief."),
+			map[APIType]int{OpenAI: 1, Mistral: 1, Anthropic: 1},
+		},
+		{
+			"leading assistant message",
+			"",
+			messageList("assistant", "Hello.", "user", "Hi!"),
+			map[APIType]int{OpenAI: ok, Mistral: 0, Anthropic: 0},
+		},
+		{
+			"consecutive user messages",
+			"",
+			messageList("user", "Hi!", "user", "Are you there?"),
+			map[APIType]int{OpenAI: ok, Mistral: ok, Anthropic: 1},
+		},
+		{
+			"consecutive assistant messages",
+			"",
+			messageList("user", "Hi!", "assistant", "Hello.", "assistant", "How can I help?"),
+			map[APIType]int{OpenAI: ok, Mistral: ok, Anthropic: 2},
+		},
+		{
+			"empty message",
+			"",
+			messageList("user", " ", "assistant", "Hello.", "user", "Hi!"),
+			map[APIType]int{OpenAI: ok, Mistral: ok, Anthropic: 0},
+		},
+		{
+			"empty last user message",
+			"",
+			messageList("user", "Hi!", "assistant", "Hello.", "user", ""),
+			map[APIType]int{OpenAI: ok, Mistral: ok, Anthropic: 2},
+		},
+		{
+			"empty prefill",
+			"",
+			messageList("user", "Hi!", "assistant", ""),
+			map[APIType]int{OpenAI: ok, Mistral: ok, Anthropic: ok},
+		},
+		{
+			"prefill ending with whitespace",
+			"",
+			messageList("user", "Hi!", "assistant", "Hello "),
+			map[APIType]int{OpenAI: ok, Mistral: ok, Anthropic: 1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chat := Chat{messages: test.messages}
+			if test.system != "" {
+				chat.SetSystemMessage(test.system)
+			}
+			for _, apiType := range []APIType{OpenAI, Mistral, Anthropic} {
+				err := chat.Validate(apiType)
+				want := test.index[apiType]
+				if want == ok {
+					if err != nil {
+						t.Errorf("%v: %v", apiType, err)
+					}
+					continue
+				}
+				var validationError *ValidationError
+				if !errors.As(err, &validationError) {
+					t.Errorf("%v: error %v, want a ValidationError", apiType, err)
+					continue
+				}
+				if validationError.Index != want {
+					t.Errorf("%v: error at %d (%v), want at %d", apiType, validationError.Index, err, want)
+				}
+			}
+		})
+	}
+
+	chat := Chat{}
+	chat.AddUserMessage("Hi!")
+	if err := chat.Validate(APIType(42)); err == nil {
+		t.Error("accepted an invalid API type")
+	}
+}
+
+func TestNormalizeMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []Message
+		want     map[APIType][]Message
+	}{
+		{
+			"consecutive messages",
+			messageList("user", "Hi!", "user", "Are you there?", "assistant", "Yes.", "assistant", "How can I help?"),
+			map[APIType][]Message{
+				OpenAI:    messageList("user", "Hi!", "user", "Are you there?", "assistant", "Yes.", "assistant", "How can I help?"),
+				Mistral:   messageList("user", "Hi!\n\nAre you there?", "assistant", "Yes.\n\nHow can I help?"),
+				Anthropic: messageList("user", "Hi!\n\nAre you there?", "assistant", "Yes.\n\nHow can I help?"),
+			},
+		},
+		{
+			"leading assistant message",
+			messageList("assistant", "Hello.", "user", "Hi!"),
+			map[APIType][]Message{
+				OpenAI:    messageList("assistant", "Hello.", "user", "Hi!"),
+				Mistral:   messageList("user", placeholderText, "assistant", "Hello.", "user", "Hi!"),
+				Anthropic: messageList("user", placeholderText, "assistant", "Hello.", "user", "Hi!"),
+			},
+		},
+		{
+			"empty messages",
+			messageList("user", "", "assistant", " ", "user", "Hi!"),
+			map[APIType][]Message{
+				OpenAI:    messageList("user", "", "assistant", " ", "user", "Hi!"),
+				Mistral:   messageList("user", placeholderText, "assistant", placeholderText, "user", "Hi!"),
+				Anthropic: messageList("user", placeholderText, "assistant", placeholderText, "user", "Hi!"),
+			},
+		},
+		{
+			"prefill",
+			messageList("user", "Hi!", "assistant", "Hello \n"),
+			map[APIType][]Message{
+				OpenAI:    messageList("user", "Hi!", "assistant", "Hello \n"),
+				Mistral:   messageList("user", "Hi!", "assistant", "Hello \n"),
+				Anthropic: messageList("user", "Hi!", "assistant", "Hello"),
+			},
+		},
+		{
+			"empty prefill",
+			messageList("user", "Hi!", "assistant", " "),
+			map[APIType][]Message{
+				OpenAI:    messageList("user", "Hi!", "assistant", " "),
+				Mistral:   messageList("user", "Hi!", "assistant", placeholderText),
+				Anthropic: messageList("user", "Hi!"),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for apiType, want := range test.want {
+				messages := append([]Message(nil), test.messages...)
+				got := normalizeMessages(apiType, messages)
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%v: %q, want %q", apiType, got, want)
+				}
+				if !reflect.DeepEqual(messages, test.messages) {
+					t.Errorf("%v: the messages were changed", apiType)
+				}
+			}
+		})
+	}
+}
+
+func TestNormalizedMessagesSystemMessage(t *testing.T) {
+	chat := Chat{}
+	chat.SetSystemMessage("Be brief.")
+	chat.AddAssistantMessage("Hello.")
+	chat.AddUserMessage("Hi!")
+	for _, apiType := range []APIType{OpenAI, Mistral, Anthropic} {
+		// The system message stays first and is not merged with the
+		// placeholder before the leading assistant message.
+		got := chat.normalizedMessages(apiType, true)
+		if len(got) < 3 || got[0] != *NewSystemMessage("Be brief.") {
+			t.Errorf("%v: %q, want the system message first", apiType, got)
+		}
+		if apiType != OpenAI && (got[1].Type != UserMessage || got[1].Text != placeholderText) {
+			t.Errorf("%v: %q, want a placeholder user message after the system message", apiType, got)
+		}
+
+		// Anthropic sends the system message in a field of its own.
+		without := chat.normalizedMessages(apiType, false)
+		for _, m := range without {
+			if m.Type == SystemMessage {
+				t.Errorf("%v: %q, want no system message", apiType, without)
+			}
+		}
+	}
+}
@@ -1,27 +1,119 @@
 package multi_ai_client
 
 import (
-	"bufio"
-	"encoding/json"
+	"bytes"
+	"context"
 	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 	"sync"
-
-	"github.com/icza/dyno"
+	"sync/atomic"
+	"time"
 )
 
 type Client struct {
-	modelDefinitions []ModelDefinition
+	// modelDefinitions holds a *[]ModelDefinition that is replaced as a
+	// whole, so responses keep the model definitions they were started with.
+	modelDefinitions atomic.Value
 	Chat             Chat
+
+	// Pricing is used to look up the price of models that have no pricing of
+	// their own. If it is nil, a DefaultPricingTable is created on first use.
+	Pricing *PricingTable
+
+	// Budgets are the daily spending limits checked before every request.
+	Budgets []Budget
+	// BudgetStore stores the spending against the budgets. If it is nil, a
+	// MemoryBudgetStore is created on first use.
+	BudgetStore BudgetStore
+	// OnSoftLimit is called when spending crosses the limit of a soft budget,
+	// with the key the spending is tracked under and the new total.
+	OnSoftLimit func(budget Budget, key string, spent Spend)
+
+	// Cache stores responses so identical requests can be answered without
+	// contacting the API. If it is nil, nothing is cached.
+	Cache ResponseCache
+	// CacheTTL is how long cached responses stay valid. 0 means forever.
+	CacheTTL time.Duration
+	// CachePolicy determines which requests are answered from the cache.
+	CachePolicy CachePolicy
+
+	// Preferences stores the responses chosen among the responses of several
+	// models, from which the models can be rated. If it is nil, RecordPreference
+	// does nothing.
+	Preferences PreferenceStore
+
+	// HTTPClient is used to send the requests. If it is nil, a default
+	// http.Client is used. Set its Transport to intercept requests, for
+	// example to record and replay them in tests.
+	HTTPClient *http.Client
+
+	// Logger receives structured logs of the requests made by the client and
+	// of problems with their response streams. If it is nil, nothing is
+	// logged. API keys are never logged.
+	Logger *slog.Logger
+	// RedactContent replaces the text of messages in the logs with its
+	// length.
+	RedactContent bool
+
+	costs      *CostTracker
+	middleware []Middleware
+	observers  []Observer
+}
+
+// clientInit guards the fields of clients that are created on first use, so
+// responses can be created concurrently.
+var clientInit sync.Mutex
+
+// GetModelDefinition returns the model definition of the client with the
+// given name.
+func (c *Client) GetModelDefinition(name string) (ModelDefinition, bool) {
+	for _, definition := range c.loadModelDefinitions() {
+		if definition.Name == name {
+			return definition, true
+		}
+	}
+	return ModelDefinition{}, false
 }
 
 // AddModelDefinition adds a model definition to the client.
 func (c *Client) AddModelDefinition(modelDefinition ModelDefinition) {
-	if c.modelDefinitions == nil {
-		c.modelDefinitions = make([]ModelDefinition, 0)
+	for {
+		old := c.modelDefinitions.Load()
+		definitions := make([]ModelDefinition, 0)
+		if old != nil {
+			definitions = append(definitions, *old.(*[]ModelDefinition)...)
+		}
+		definitions = append(definitions, modelDefinition)
+		if c.modelDefinitions.CompareAndSwap(old, &definitions) {
+			return
+		}
 	}
-	c.modelDefinitions = append(c.modelDefinitions, modelDefinition)
+}
+
+// GetModelDefinitions returns a copy of the model definitions of the client.
+func (c *Client) GetModelDefinitions() []ModelDefinition {
+	return append([]ModelDefinition(nil), c.loadModelDefinitions()...)
+}
+
+// SetModelDefinitions replaces all model definitions of the client at once.
+// Responses that are being created keep using the model definitions they were
+// started with.
+func (c *Client) SetModelDefinitions(modelDefinitions []ModelDefinition) {
+	definitions := append(make([]ModelDefinition, 0, len(modelDefinitions)), modelDefinitions...)
+	c.modelDefinitions.Store(&definitions)
+}
+
+// loadModelDefinitions returns the current model definitions of the client.
+// The returned slice must not be changed.
+func (c *Client) loadModelDefinitions() []ModelDefinition {
+	definitions, _ := c.modelDefinitions.Load().(*[]ModelDefinition)
+	if definitions == nil {
+		return nil
+	}
+	return *definitions
 }
 
 // ResetChat resets the chat history of the client.
@@ -32,80 +124,345 @@ func (c *Client) ResetChat() {
 // CreateResponse creates a response to a user prompt using the model definitions added to the client.
 // It returns the total amount of responses initiated, a channel to receive message chunks, and an error if one occurred.
 func (c *Client) CreateResponse() (int, chan MessageChunk, error) {
-	if c.modelDefinitions == nil || len(c.modelDefinitions) == 0 {
+	return c.CreateResponseContext(context.Background())
+}
+
+// CreateResponseContext functions like CreateResponse, but stops all requests
+// when the context is cancelled. The last chunk of a cancelled response is
+// still delivered, with the error of the context. The channel must be read
+// until it is closed.
+func (c *Client) CreateResponseContext(ctx context.Context) (int, chan MessageChunk, error) {
+	return c.createResponse(ctx, &c.Chat, c.loadModelDefinitions())
+}
+
+// CreateResponseForChat functions like CreateResponseContext, but answers the
+// given chat with the given model definitions instead of the chat and model
+// definitions of the client. The chat of the client is not used, so it can be
+// called concurrently, for example to serve several users with one client.
+// The given chat is compacted in place if needed.
+func (c *Client) CreateResponseForChat(ctx context.Context, chat *Chat, modelDefinitions []ModelDefinition) (int, chan MessageChunk, error) {
+	return c.createResponse(ctx, chat, append([]ModelDefinition(nil), modelDefinitions...))
+}
+
+// complete answers the chat with a single model definition and waits for the
+// full response. It returns the text of the response, or the error of the
+// response if it failed.
+func (c *Client) complete(ctx context.Context, chat *Chat, modelDefinition ModelDefinition) (string, error) {
+	_, ch, err := c.CreateResponseForChat(ctx, chat, []ModelDefinition{modelDefinition})
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	var last MessageChunk
+	for chunk := range ch {
+		sb.WriteString(chunk.Delta)
+		last = chunk
+	}
+	if last.Err != nil {
+		return "", last.Err
+	}
+	return sb.String(), nil
+}
+
+// createResponse creates the responses of the model definitions to a chat.
+func (c *Client) createResponse(ctx context.Context, target *Chat, modelDefinitions []ModelDefinition) (int, chan MessageChunk, error) {
+	if len(modelDefinitions) == 0 {
 		return 0, nil, errors.New("no model definitions added to client")
 	}
 
+	if err := c.compactIfNeeded(ctx, target); err != nil {
+		return 0, nil, err
+	}
+	chat := *target
+
+	if err := c.checkBudgets(chat, modelDefinitions); err != nil {
+		return 0, nil, err
+	}
+
+	observers := append([]Observer(nil), c.observers...)
+	logger, redactContent := c.Logger, c.RedactContent
+	if logger != nil {
+		observers = append([]Observer{newLogObserver(logger, redactContent)}, observers...)
+	}
+	responseCtx := ctx
+	for _, o := range observers {
+		responseCtx = o.ResponseStarted(responseCtx, chat, append([]ModelDefinition(nil), modelDefinitions...))
+	}
+
 	requests := make([]*http.Request, 0)
-	for _, modelDefinition := range c.modelDefinitions {
-		req, err := modelDefinition.CreateRequest(c.Chat)
+	keys := make([]string, 0)
+	filters := make([]*prefillFilter, 0)
+	for _, modelDefinition := range modelDefinitions {
+		req, key, err := modelDefinition.createRequest(chat)
 		if err != nil {
+			for _, o := range observers {
+				o.ResponseFinished(responseCtx)
+			}
 			return 0, nil, err
 		}
-		requests = append(requests, req)
+		requests = append(requests, req.WithContext(responseCtx))
+		keys = append(keys, key)
+		filters = append(filters, modelDefinition.newPrefillFilter(chat))
 	}
 
+	pricing := c.GetPricing()
+	costs := c.Costs()
+	budgets := append([]Budget(nil), c.Budgets...)
+	budgetStore := c.getBudgetStore()
+	onSoftLimit := c.OnSoftLimit
+	cache, cacheTTL, cachePolicy := c.Cache, c.CacheTTL, c.CachePolicy
+	if cacheBypassed(ctx) {
+		cache = nil
+	}
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	handler := chain(c.middleware, func(ctx context.Context, exchange *Exchange) (EventStream, error) {
+		modelDefinition := exchange.ModelDefinition
+		req := exchange.Request
+		r := redactor{apiKey: exchange.apiKey, content: redactContent}
+		newStream := func(body io.ReadCloser) *sseStream {
+			stream := newSSEStream(body)
+			stream.model = modelDefinition.Name
+			if logger != nil {
+				stream.logger = logger.With(slog.String("model", modelDefinition.Name))
+				stream.ctx = ctx
+				stream.redactor = r
+			}
+			return stream
+		}
+
+		cacheKey := ""
+		if cache != nil && (cachePolicy == CacheAll || isDeterministic(modelDefinition.ModelSettings)) {
+			cacheKey, _ = ResponseCacheKey(modelDefinition, req)
+		}
+		if cacheKey != "" {
+			if stream, ok := cache.Get(cacheKey); ok {
+				exchange.Cached = true
+				if logger != nil {
+					logger.DebugContext(ctx, "answering request from cache", slog.String("model", modelDefinition.Name))
+				}
+				return newStream(io.NopCloser(bytes.NewReader(stream))), nil
+			}
+		}
+
+		client := httpClient
+		if modelDefinition.Transport != nil {
+			client = &http.Client{Transport: modelDefinition.Transport}
+		}
+		if logger != nil {
+			logRequest(ctx, logger, r, exchange)
+		}
+		response, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		exchange.StatusCode = response.StatusCode
+
+		// Retry requests rejected because of their key with the other keys the
+		// credential provider offers.
+		tried := map[string]bool{exchange.apiKey: true}
+		for {
+			reporter, ok := modelDefinition.APISettings.Credentials.(CredentialReporter)
+			if !ok {
+				break
+			}
+			reporter.ReportResult(exchange.apiKey, response.StatusCode)
+			if !isKeyRejection(response.StatusCode) || req.GetBody == nil {
+				break
+			}
+			key, err := modelDefinition.APISettings.GetAPIKey()
+			if err != nil || tried[key] {
+				break
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				break
+			}
+			if logger != nil {
+				logger.WarnContext(ctx, "retrying request with another API key",
+					slog.String("model", modelDefinition.Name),
+					slog.Int("status", response.StatusCode))
+			}
+			_ = response.Body.Close()
+			tried[key] = true
+			req = req.Clone(ctx)
+			req.Body = body
+			setAPIKey(req, modelDefinition.APISettings.APIType, key)
+			exchange.Request, exchange.apiKey = req, key
+			response, err = client.Do(req)
+			if err != nil {
+				return nil, err
+			}
+			exchange.StatusCode = response.StatusCode
+		}
+
+		if response.StatusCode != http.StatusOK {
+			defer response.Body.Close()
+			return nil, newAPIError(modelDefinition.Name, response)
+		}
+		if cacheKey == "" {
+			return newStream(response.Body), nil
+		}
+		recorder := &cachingReader{r: response.Body}
+		stream := newStream(struct {
+			io.Reader
+			io.Closer
+		}{recorder, response.Body})
+		stream.onEnd = func() {
+			_ = cache.Set(cacheKey, recorder.buf.Bytes(), cacheTTL)
+		}
+		return stream, nil
+	})
+
 	var wg sync.WaitGroup
-	ch := make(chan MessageChunk)
+	// The channel has room for the last chunk of every response, which is
+	// always delivered, even when the context was cancelled.
+	ch := make(chan MessageChunk, len(requests))
 	for i, req := range requests {
 		wg.Add(1)
-		go func(req *http.Request) {
+		go func(modelDefinition ModelDefinition, req *http.Request) {
 			defer wg.Done()
-			client := http.Client{}
-			response, err := client.Do(req)
+			send := func(chunk MessageChunk) bool {
+				if ctx.Err() != nil {
+					return false
+				}
+				select {
+				case ch <- chunk:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			}
+
+			exchange := &Exchange{
+				Index:           i,
+				ModelDefinition: &modelDefinition,
+				Chat:            chat,
+				Request:         req,
+				apiKey:          keys[i],
+			}
+			requestCtx := responseCtx
+			for _, o := range observers {
+				requestCtx = o.RequestStarted(requestCtx, exchange)
+			}
+			exchange.Request = exchange.Request.WithContext(requestCtx)
+			finish := func(chunk MessageChunk) {
+				for _, o := range observers {
+					o.RequestFinished(requestCtx, exchange, chunk)
+				}
+				ch <- chunk
+			}
+
+			stream, err := handler(requestCtx, exchange)
 			if err != nil {
+				finish(MessageChunk{Index: i, Done: true, Err: err})
 				return
 			}
-			body := bufio.NewScanner(response.Body)
-			defer response.Body.Close()
+			defer stream.Close()
+
+			var text strings.Builder
+			usage := Usage{Estimated: true}
+			finishReason := ""
 			for {
-				success := body.Scan()
-				if success {
-					t := strings.TrimSpace(body.Text())
-					if strings.Index(t, "data: ") == 0 {
-						if t == "data: [DONE]" {
-							break
-						}
-						t = strings.TrimPrefix(t, "data: ")
-						var data interface{}
-						err := json.Unmarshal([]byte(t), &data)
-						if err != nil {
-							continue
-						}
-
-						s, err := dyno.GetString(data, "choices", 0, "delta", "content")
-						if err == nil {
-							ch <- MessageChunk{
-								Index: i,
-								Delta: s,
-							}
-							continue
-						}
-
-						s, err = dyno.GetString(data, "delta", "text")
-						if err == nil {
-							ch <- MessageChunk{
-								Index: i,
-								Delta: s,
-							}
-							continue
-						}
+				var event StreamEvent
+				event, err = stream.Next()
+				if err != nil {
+					break
+				}
+				if event.Usage != nil {
+					usage.merge(event.Usage)
+					usage.Estimated = false
+				}
+				if event.FinishReason != "" {
+					finishReason = event.FinishReason
+				}
+				delta := filters[i].Write(event.Delta)
+				if delta == "" {
+					continue
+				}
+				if text.Len() == 0 {
+					for _, o := range observers {
+						o.FirstToken(requestCtx, exchange)
 					}
-				} else {
+				}
+				text.WriteString(delta)
+				if !send(MessageChunk{Index: i, Delta: delta}) {
 					break
 				}
 			}
-		}(req)
+
+			// The response is billed once it started, even if it did not
+			// finish, so the usage is recorded in every case. If the API did
+			// not report it, it is estimated from the text received so far.
+			cached := exchange.Cached
+			if usage.Estimated {
+				usage.InputTokens = modelDefinition.CountTokens(modelDefinition.FitChat(chat))
+				usage.OutputTokens = modelDefinition.GetTokenizer().CountTokens(text.String())
+			}
+			cost := 0.0
+			if price, ok := modelDefinition.GetPricing(pricing); ok && !cached {
+				cost = price.Cost(usage)
+			}
+			user := userOf(modelDefinition.ModelSettings)
+			costs.Record(modelDefinition.Name, user, usage, cost)
+			if !cached {
+				recordSpend(budgetStore, budgets, onSoftLimit, modelDefinition.Name, user, usage, cost)
+			}
+
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			if err != io.EOF {
+				finish(MessageChunk{Index: i, Done: true, Usage: &usage, Cost: cost, Err: err})
+				return
+			}
+			finish(MessageChunk{
+				Index:        i,
+				Done:         true,
+				Usage:        &usage,
+				Cost:         cost,
+				FinishReason: finishReason,
+				Cached:       cached,
+			})
+		}(modelDefinitions[i], req)
 	}
 
 	go func() {
 		wg.Wait()
+		for _, o := range observers {
+			o.ResponseFinished(responseCtx)
+		}
 		close(ch)
 	}()
 
 	return len(requests), ch, nil
 }
 
+// GetPricing returns the pricing table of the client, creating a
+// DefaultPricingTable if none was set.
+func (c *Client) GetPricing() *PricingTable {
+	clientInit.Lock()
+	defer clientInit.Unlock()
+	if c.Pricing == nil {
+		c.Pricing = DefaultPricingTable()
+	}
+	return c.Pricing
+}
+
+// Costs returns the running usage and cost totals of all responses created
+// by the client.
+func (c *Client) Costs() *CostTracker {
+	clientInit.Lock()
+	defer clientInit.Unlock()
+	if c.costs == nil {
+		c.costs = NewCostTracker()
+	}
+	return c.costs
+}
+
 // CreateResponseWithPrompt creates a response to a user prompt using the model definitions added to the client.
 // If functions like CreateResponse, but allows for a user prompt and assistant response to be passed in first.
 func (c *Client) CreateResponseWithPrompt(usrPrompt string, assistantResponse string) (int, chan MessageChunk, error) {
@@ -118,6 +475,17 @@ func (c *Client) CreateResponseWithPrompt(usrPrompt string, assistantResponse st
 	return c.CreateResponse()
 }
 
+// Regenerate creates a new set of responses to the last user prompt.
+// If the chat ends with an assistant message, it is removed first.
+// It functions like CreateResponse otherwise.
+func (c *Client) Regenerate() (int, chan MessageChunk, error) {
+	messages := c.Chat.GetMessagesWithoutSystemMessage()
+	if len(messages) > 0 && messages[len(messages)-1].Type == AssistantMessage {
+		_, _ = c.Chat.PopLast()
+	}
+	return c.CreateResponse()
+}
+
 func (c Client) String() string {
 	return c.Chat.String()
 }
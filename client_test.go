@@ -0,0 +1,94 @@
+package multi_ai_client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// blockingBody returns a delta, then blocks until the request is cancelled.
+type blockingBody struct {
+	ctx  context.Context
+	sent bool
+}
+
+func (b *blockingBody) Read(p []byte) (int, error) {
+	if !b.sent {
+		b.sent = true
+		return copy(p, "data: {\"choices\": [{\"delta\": {\"content\": \"Hel\"}}]}\n\n"), nil
+	}
+	<-b.ctx.Done()
+	return 0, b.ctx.Err()
+}
+
+func (b *blockingBody) Close() error {
+	return nil
+}
+
+func TestCancelledResponseIsDone(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       &blockingBody{ctx: req.Context()},
+			Request:    req,
+		}, nil
+	})
+	client := &Client{HTTPClient: &http.Client{Transport: transport}}
+	definitions := []ModelDefinition{
+		NewModelDefinition("A", OpenAI, "key", "gpt-4o"),
+		NewModelDefinition("B", OpenAI, "key", "gpt-4o-mini"),
+	}
+	chat := Chat{}
+	chat.AddUserMessage("Hi!")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n, ch, err := client.CreateResponseForChat(ctx, &chat, definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deltas := 0
+	done := make([]MessageChunk, 0, n)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case chunk, ok := <-ch:
+			if !ok {
+				if len(done) != n {
+					t.Fatalf("got %d last chunks, want %d", len(done), n)
+				}
+				for _, chunk := range done {
+					if !errors.Is(chunk.Err, context.Canceled) {
+						t.Errorf("response %d: error %v, want context.Canceled", chunk.Index, chunk.Err)
+					}
+					// The text received so far is billed.
+					if chunk.Usage == nil || !chunk.Usage.Estimated || chunk.Usage.InputTokens == 0 || chunk.Usage.OutputTokens == 0 || chunk.Cost == 0 {
+						t.Errorf("response %d: usage %+v and cost %v, want the estimate of the partial response", chunk.Index, chunk.Usage, chunk.Cost)
+					}
+				}
+				if total := client.Costs().Total(); total.Responses != n || total.Cost == 0 {
+					t.Errorf("recorded %+v, want the partial responses", total)
+				}
+				return
+			}
+			if chunk.Done {
+				done = append(done, chunk)
+				continue
+			}
+			deltas++
+			if deltas == n {
+				cancel()
+			}
+		case <-timeout:
+			t.Fatal("the channel was not closed")
+		}
+	}
+}
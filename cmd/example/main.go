@@ -1,9 +1,9 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/villadelfia/multi-ai-client"
-	"math/rand"
 	"time"
 )
 
@@ -26,11 +26,14 @@ func main() {
 	client.AddModelDefinition(gpt)
 	client.AddModelDefinition(mistral)
 
+	// A judge picks the best response. Judging twice in swapped order keeps it from favouring a position.
+	judge := multi_ai_client.Judge{Model: &gpt, Swap: true}
+
 	// (Optionally) set a system message.
 	client.Chat.SetSystemMessage("You are a helpful assistant. You can help me by answering my questions. You can also ask me questions.")
 
 	// Create a response with a prompt. Note that you can also add an assistant response for the model to add on to.
-	// This does not work for Mistral.
+	// The deltas never repeat the assistant response, so it plus all deltas is the full message.
 	i, ch, err := client.CreateResponseWithPrompt("What is the capital of France?", "")
 	if err != nil {
 		panic(err)
@@ -46,10 +49,12 @@ func main() {
 		}
 	}
 
-	// We pick a random response to keep...
-	choice := response[rand.Intn(len(response))]
-	client.Chat.AddAssistantMessage(choice)
-	println("\nChose response:", choice, "\n")
+	// We let the judge pick the response to keep...
+	verdict, err := client.SelectResponse(context.Background(), judge, response)
+	if err != nil {
+		panic(err)
+	}
+	println("\nChose response:", response[verdict.Winner], "\n", verdict.Rationale, "\n")
 	time.Sleep(1 * time.Second)
 
 	// Let's ask a second question!
@@ -68,12 +73,17 @@ func main() {
 		}
 	}
 
-	// We pick a random response to keep...
-	choice = response[rand.Intn(len(response))]
-	client.Chat.AddAssistantMessage(choice)
-	println("\nChose response:", choice, "\n")
+	// We let the judge pick the response to keep...
+	verdict, err = client.SelectResponse(context.Background(), judge, response)
+	if err != nil {
+		panic(err)
+	}
+	println("\nChose response:", response[verdict.Winner], "\n", verdict.Rationale, "\n")
 	time.Sleep(1 * time.Second)
 
 	// Pretty print it.
 	fmt.Println(client)
+
+	// And show what it cost.
+	fmt.Printf("\nTotal cost: $%.4f\n", client.Costs().Total().Cost)
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+// errAllFailed is returned by the ask command when no model answered.
+var errAllFailed = errors.New("all models failed")
+
+// result is the outcome of the response of a single model, as it is written
+// by the ask command.
+type result struct {
+	Model        string     `json:"model"`
+	Text         string     `json:"text"`
+	Usage        *usageJSON `json:"usage,omitempty"`
+	Cost         float64    `json:"cost"`
+	LatencyMS    int64      `json:"latency_ms"`
+	FinishReason string     `json:"finish_reason,omitempty"`
+	Cached       bool       `json:"cached,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	StatusCode   int        `json:"status_code,omitempty"`
+}
+
+// usageJSON is the token usage of a response, as it is written by the ask
+// command.
+type usageJSON struct {
+	InputTokens       int  `json:"input_tokens"`
+	CachedInputTokens int  `json:"cached_input_tokens,omitempty"`
+	OutputTokens      int  `json:"output_tokens"`
+	Estimated         bool `json:"estimated,omitempty"`
+}
+
+func newResult(r *response) result {
+	res := result{
+		Model:        r.name,
+		Text:         r.text.String(),
+		Cost:         r.cost,
+		LatencyMS:    r.latency.Milliseconds(),
+		FinishReason: r.finishReason,
+		Cached:       r.cached,
+	}
+	if r.usage != nil {
+		res.Usage = &usageJSON{
+			InputTokens:       r.usage.InputTokens,
+			CachedInputTokens: r.usage.CachedInputTokens,
+			OutputTokens:      r.usage.OutputTokens,
+			Estimated:         r.usage.Estimated,
+		}
+	}
+	if r.err != nil {
+		res.Error = r.err.Error()
+		var apiError *mac.APIError
+		if errors.As(r.err, &apiError) {
+			res.StatusCode = apiError.StatusCode
+		}
+	}
+	return res
+}
+
+func runAsk(args []string) error {
+	flags := flag.NewFlagSet("ask", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), "Usage: multi-ai ask [flags] [prompt...]\n\nSends a prompt to all models and prints their answers. Without a prompt,\nit is read from standard input.\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	config := configFlag(flags)
+	models := flags.String("models", "", "comma separated names of the models to ask (default all)")
+	system := flags.String("system", "", "the system message")
+	systemFile := flags.String("system-file", "", "a file holding the system message")
+	transcript := flags.String("transcript", "", "a transcript to continue")
+	format := flags.String("format", "text", "the output format: text, json or jsonl")
+	timeout := flags.Duration("timeout", 0, "how long to wait for the answers (default no limit)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" && *format != "jsonl" {
+		return errors.New("unknown format " + *format + ", use text, json or jsonl")
+	}
+	if *system != "" && *systemFile != "" {
+		return errors.New("use either -system or -system-file")
+	}
+
+	prompt := strings.Join(flags.Args(), " ")
+	if prompt == "" || prompt == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		prompt = string(data)
+	}
+	prompt = strings.TrimSpace(prompt)
+	if prompt == "" {
+		return errors.New("no prompt given")
+	}
+
+	client, definitions, err := loadClient(*config)
+	if err != nil {
+		return err
+	}
+	if names := splitList(*models); len(names) > 0 {
+		if definitions, err = selectModels(definitions, names); err != nil {
+			return err
+		}
+		client.SetModelDefinitions(definitions)
+	}
+	if *transcript != "" {
+		chat, err := mac.LoadTranscript(*transcript)
+		if err != nil {
+			return err
+		}
+		client.Chat = *chat
+	}
+	if *systemFile != "" {
+		data, err := os.ReadFile(*systemFile)
+		if err != nil {
+			return err
+		}
+		*system = strings.TrimSpace(string(data))
+	}
+	if *system != "" {
+		client.Chat.SetSystemMessage(*system)
+	}
+	client.Chat.AddUserMessage(prompt)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	return ask(ctx, client, definitions, *format, os.Stdout)
+}
+
+// ask creates the responses of all models and writes them to out. It returns
+// errAllFailed if no model answered.
+func ask(ctx context.Context, client *mac.Client, definitions []mac.ModelDefinition, format string, out io.Writer) error {
+	n, ch, err := client.CreateResponseContext(ctx)
+	if err != nil {
+		return err
+	}
+	responses := make([]*response, n)
+	for i := range responses {
+		responses[i] = &response{name: definitions[i].Name, started: time.Now()}
+	}
+
+	// A single answer in text is streamed as it arrives.
+	streaming := format == "text" && n == 1
+	encoder := json.NewEncoder(out)
+	for chunk := range ch {
+		responses[chunk.Index].add(chunk)
+		if streaming {
+			_, _ = io.WriteString(out, chunk.Delta)
+		}
+		if !chunk.Done {
+			continue
+		}
+		if format == "jsonl" {
+			if err := encoder.Encode(newResult(responses[chunk.Index])); err != nil {
+				return err
+			}
+		}
+	}
+
+	failed := 0
+	for _, r := range responses {
+		if !r.ok() {
+			failed++
+		}
+	}
+	switch {
+	case streaming:
+		if responses[0].ok() {
+			fmt.Fprintln(out)
+		} else {
+			fmt.Fprintln(os.Stderr, "error:", responses[0].err)
+		}
+	case format == "text":
+		for i, r := range responses {
+			fmt.Fprintf(out, "== %s ==\n", r.name)
+			if text := r.text.String(); text != "" {
+				fmt.Fprintln(out, text)
+			}
+			if !r.ok() {
+				fmt.Fprintln(out, "error:", r.err)
+			}
+			if i < len(responses)-1 {
+				fmt.Fprintln(out)
+			}
+		}
+	case format == "json":
+		results := make([]result, n)
+		for i, r := range responses {
+			results[i] = newResult(r)
+		}
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(struct {
+			Responses []result `json:"responses"`
+		}{results}); err != nil {
+			return err
+		}
+	}
+	if failed == n {
+		return errAllFailed
+	}
+	return nil
+}
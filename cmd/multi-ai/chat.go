@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+const chatHelp = `Type a prompt to send it to all active models. End a line with \ to
+continue the prompt on the next line. Press Ctrl-C to stop the responses.
+
+Commands:
+  /system [TEXT]       show the system message, or set it (- removes it)
+  /regenerate, /r      answer the last prompt again
+  /undo                undo the last change to the chat
+  /save FILE           save the chat as a transcript
+  /load FILE           replace the chat with a saved transcript
+  /models [MODEL...]   list the models, or choose the active ones by name or
+                       number (all activates every model)
+  /layout LAYOUT       show the responses as columns or stacked
+  /history             show the chat
+  /cost                show the usage and cost so far
+  /clear               start a new chat
+  /help                show this help
+  /quit                leave
+`
+
+// session is an interactive chat with several models.
+type session struct {
+	client *mac.Client
+	// models are all model definitions of the configuration. The active ones
+	// are the model definitions of the client.
+	models   []mac.ModelDefinition
+	renderer *renderer
+	in       *bufio.Reader
+	out      io.Writer
+}
+
+func runChat(args []string) error {
+	flags := flag.NewFlagSet("chat", flag.ContinueOnError)
+	config := configFlag(flags)
+	layoutName := flags.String("layout", "columns", "how to show the responses: columns or stacked")
+	models := flags.String("models", "", "comma separated names of the models to start with (default all)")
+	system := flags.String("system", "", "the system message")
+	transcript := flags.String("load", "", "a transcript to continue")
+	preferences := preferencesFlag(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	l, err := parseLayout(*layoutName)
+	if err != nil {
+		return err
+	}
+	client, definitions, err := loadClient(*config)
+	if err != nil {
+		return err
+	}
+	if names := splitList(*models); len(names) > 0 {
+		active, err := selectModels(definitions, names)
+		if err != nil {
+			return err
+		}
+		client.SetModelDefinitions(active)
+	}
+	if *preferences != "" {
+		client.Preferences = mac.NewFilePreferenceStore(*preferences)
+	}
+	if *transcript != "" {
+		chat, err := mac.LoadTranscript(*transcript)
+		if err != nil {
+			return err
+		}
+		client.Chat = *chat
+	}
+	if *system != "" {
+		client.Chat.SetSystemMessage(*system)
+		client.Chat.ClearUndo()
+	}
+
+	s := &session{
+		client:   client,
+		models:   definitions,
+		renderer: newRenderer(os.Stdout, l),
+		in:       bufio.NewReader(os.Stdin),
+		out:      os.Stdout,
+	}
+	return s.run()
+}
+
+// run reads prompts and commands until the input ends or the user quits.
+func (s *session) run() error {
+	fmt.Fprintf(s.out, "%s. Type /help for commands.\n", s.activeSummary())
+	for {
+		line, err := s.readInput("> ")
+		if errors.Is(err, io.EOF) {
+			fmt.Fprintln(s.out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "/") {
+			quit, err := s.command(line)
+			if err != nil {
+				fmt.Fprintln(s.out, "error:", err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+		s.client.Chat.AddUserMessage(line)
+		s.respond()
+	}
+}
+
+// readInput reads a line of input, joining lines that end in a backslash.
+func (s *session) readInput(prompt string) (string, error) {
+	fmt.Fprint(s.out, prompt)
+	lines := make([]string, 0, 1)
+	for {
+		line, err := s.in.ReadString('\n')
+		if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if !strings.HasSuffix(line, "\\") {
+			return strings.Join(append(lines, line), "\n"), nil
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\\"))
+		fmt.Fprint(s.out, "… ")
+	}
+}
+
+// command runs a slash command. It returns true if the session should end.
+func (s *session) command(line string) (bool, error) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case "/quit", "/exit", "/q":
+		return true, nil
+	case "/help", "/?":
+		fmt.Fprint(s.out, chatHelp)
+	case "/system":
+		switch arg {
+		case "":
+			if system := s.client.Chat.GetSystemMessage(); system != "" {
+				fmt.Fprintln(s.out, system)
+			} else {
+				fmt.Fprintln(s.out, "No system message.")
+			}
+		case "-":
+			s.client.Chat.ClearSystemMessage()
+			fmt.Fprintln(s.out, "System message removed.")
+		default:
+			s.client.Chat.SetSystemMessage(arg)
+			fmt.Fprintln(s.out, "System message set.")
+		}
+	case "/regenerate", "/r":
+		messages := s.client.Chat.GetMessagesWithoutSystemMessage()
+		if len(messages) == 0 {
+			return false, errors.New("there is no prompt to answer")
+		}
+		if messages[len(messages)-1].Type == mac.AssistantMessage {
+			_, _ = s.client.Chat.PopLast()
+		}
+		s.respond()
+	case "/undo":
+		if !s.client.Chat.Undo() {
+			return false, errors.New("nothing to undo")
+		}
+		fmt.Fprintln(s.out, s.lastMessageSummary())
+	case "/save":
+		if arg == "" {
+			return false, errors.New("usage: /save FILE")
+		}
+		if err := s.client.Chat.SaveTranscript(arg); err != nil {
+			return false, err
+		}
+		fmt.Fprintln(s.out, "Saved to "+arg+".")
+	case "/load":
+		if arg == "" {
+			return false, errors.New("usage: /load FILE")
+		}
+		chat, err := mac.LoadTranscript(arg)
+		if err != nil {
+			return false, err
+		}
+		s.client.Chat = *chat
+		fmt.Fprintf(s.out, "Loaded %d messages. %s\n", len(chat.GetMessages()), s.lastMessageSummary())
+	case "/models":
+		return false, s.selectModels(strings.Fields(arg))
+	case "/layout":
+		l, err := parseLayout(arg)
+		if err != nil {
+			return false, err
+		}
+		s.renderer.layout = l
+	case "/history":
+		fmt.Fprintln(s.out, s.client.Chat.String())
+	case "/cost":
+		s.printCosts()
+	case "/clear":
+		system := s.client.Chat.GetSystemMessage()
+		s.client.ResetChat()
+		s.client.Chat.SetSystemMessage(system)
+		s.client.Chat.ClearUndo()
+		fmt.Fprintln(s.out, "Started a new chat.")
+	default:
+		return false, errors.New("unknown command " + name + ", type /help for commands")
+	}
+	return false, nil
+}
+
+// selectModels lists the models, or makes the named ones active.
+func (s *session) selectModels(names []string) error {
+	if len(names) == 0 {
+		active := make(map[string]bool)
+		for _, definition := range s.client.GetModelDefinitions() {
+			active[definition.Name] = true
+		}
+		for i, definition := range s.models {
+			marker := " "
+			if active[definition.Name] {
+				marker = "*"
+			}
+			fmt.Fprintf(s.out, "%s %d. %s (%s %s)\n", marker, i+1, definition.Name, definition.APISettings.APIType, definition.GetModelName())
+		}
+		return nil
+	}
+
+	models := s.models
+	if !(len(names) == 1 && names[0] == "all") {
+		var err error
+		if models, err = selectModels(s.models, names); err != nil {
+			return err
+		}
+	}
+	s.client.SetModelDefinitions(models)
+	fmt.Fprintln(s.out, s.activeSummary()+".")
+	return nil
+}
+
+// respond creates responses to the chat, shows them, and lets the user pick
+// the one to keep, until a response is kept or the user gives up.
+func (s *session) respond() {
+	for {
+		responses := s.stream()
+		if responses == nil || !s.pick(responses) {
+			return
+		}
+	}
+}
+
+// stream creates responses to the chat and shows them as they arrive. It
+// returns nil if no responses could be created.
+func (s *session) stream() []*response {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	definitions := s.client.GetModelDefinitions()
+	n, ch, err := s.client.CreateResponseContext(ctx)
+	if err != nil {
+		fmt.Fprintln(s.out, "error:", err)
+		return nil
+	}
+	responses := make([]*response, n)
+	for i := range responses {
+		responses[i] = &response{name: definitions[i].Name, started: time.Now()}
+	}
+	for chunk := range ch {
+		responses[chunk.Index].add(chunk)
+		s.renderer.update(responses)
+	}
+	s.renderer.finish(responses)
+	return responses
+}
+
+// pick asks the user which response to keep, and adds it to the chat. It
+// returns true if the user wants new responses instead.
+func (s *session) pick(responses []*response) bool {
+	choices := make([]int, 0, len(responses))
+	for i, r := range responses {
+		if r.ok() {
+			choices = append(choices, i)
+		}
+	}
+	if len(choices) == 0 {
+		fmt.Fprintln(s.out, "There is no answer to keep. Use /regenerate to try again.")
+		return false
+	}
+
+	prompt := fmt.Sprintf("Keep answer [%d], r to regenerate, d to discard the prompt: ", choices[0]+1)
+	if len(choices) > 1 {
+		numbers := make([]string, len(choices))
+		for i, choice := range choices {
+			numbers[i] = strconv.Itoa(choice + 1)
+		}
+		prompt = "Keep answer " + strings.Join(numbers, "/") + ", r to regenerate, d to discard the prompt: "
+	}
+	for {
+		input, err := s.readInput(prompt)
+		if err != nil {
+			return false
+		}
+		input = strings.TrimSpace(input)
+		switch {
+		case input == "r":
+			return true
+		case input == "d":
+			_, _ = s.client.Chat.PopLast()
+			return false
+		case input == "" && len(choices) == 1:
+			s.client.Chat.AddAssistantMessage(responses[choices[0]].text.String())
+			return false
+		}
+		if i, err := strconv.Atoi(input); err == nil && i >= 1 && i <= len(responses) && responses[i-1].ok() {
+			if len(choices) > 1 {
+				s.recordPreference(responses, choices, i-1)
+			}
+			s.client.Chat.AddAssistantMessage(responses[i-1].text.String())
+			return false
+		}
+	}
+}
+
+// recordPreference records that the user picked the response at index winner
+// among the responses at the indexes of choices.
+func (s *session) recordPreference(responses []*response, choices []int, winner int) {
+	models := make([]string, len(choices))
+	candidates := make([]string, len(choices))
+	index := 0
+	for i, choice := range choices {
+		models[i] = responses[choice].name
+		candidates[i] = responses[choice].text.String()
+		if choice == winner {
+			index = i
+		}
+	}
+	preference, err := mac.NewPreference(s.client.Chat, models, candidates, index)
+	if err == nil {
+		err = s.client.RecordPreference(preference)
+	}
+	if err != nil {
+		fmt.Fprintln(s.out, "error: could not record the preference:", err)
+	}
+}
+
+// activeSummary describes the active models.
+func (s *session) activeSummary() string {
+	definitions := s.client.GetModelDefinitions()
+	names := make([]string, len(definitions))
+	for i, definition := range definitions {
+		names[i] = definition.Name
+	}
+	if len(names) == 1 {
+		return "Using " + names[0]
+	}
+	return fmt.Sprintf("Using %d models: %s", len(names), strings.Join(names, ", "))
+}
+
+// lastMessageSummary describes the last message of the chat.
+func (s *session) lastMessageSummary() string {
+	messages := s.client.Chat.GetMessagesWithoutSystemMessage()
+	if len(messages) == 0 {
+		return "The chat is empty."
+	}
+	last := messages[len(messages)-1]
+	return fmt.Sprintf("Last message (%s): %s", last.Type, truncate(last.Text, 60))
+}
+
+// printCosts shows the usage and cost per model and in total.
+func (s *session) printCosts() {
+	byModel := s.client.Costs().ByModel()
+	names := make([]string, 0, len(byModel))
+	for name := range byModel {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		totals := byModel[name]
+		fmt.Fprintf(s.out, "%-20s %3d responses %8d in %8d out  $%.4f\n", name, totals.Responses, totals.InputTokens, totals.OutputTokens, totals.Cost)
+	}
+	total := s.client.Costs().Total()
+	fmt.Fprintf(s.out, "%-20s %3d responses %8d in %8d out  $%.4f\n", "total", total.Responses, total.InputTokens, total.OutputTokens, total.Cost)
+}
@@ -0,0 +1,151 @@
+// Command multi-ai sends prompts to several models at once, using the models
+// described by a configuration file.
+//
+// Usage:
+//
+//	multi-ai [chat] [flags]
+//	multi-ai ask [flags] [prompt...]
+//	multi-ai serve [flags]
+//	multi-ai report [flags]
+//
+// The chat command opens an interactive session in which every prompt is
+// answered by all active models, and you pick the answer to keep. Type /help
+// in the session for its commands.
+//
+// The ask command answers a single prompt, given as arguments or on standard
+// input, and prints the answers as text, JSON or JSON lines with their usage,
+// latency and errors. It exits with status 1 if all models fail, so it can be
+// used in scripts.
+//
+// The serve command runs a gateway that makes every model reachable through
+// the OpenAI chat completions API and the Anthropic messages API, for tools
+// that only speak one of those protocols. It requires a file with the accepted
+// tokens, given with -tokens, unless -allow-unauthenticated is set.
+// See the gateway package. With -ui, it also serves a web app that shows the
+// answers of all models side by side; see the webui package.
+//
+// With -preferences, the chat command and the web app record which answer was
+// picked among the answers of several models. The report command rates the
+// models by these preferences, with Bradley-Terry or Elo ratings, and lists
+// them as a leaderboard.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+// defaultConfig is the configuration file used when neither the -config flag
+// nor the MULTI_AI_CONFIG environment variable is set.
+const defaultConfig = "models.yaml"
+
+const usage = `Usage: multi-ai [command] [flags]
+
+Commands:
+  chat    answer prompts interactively with all models (default)
+  ask     answer a single prompt with all models
+  serve   serve the models over the OpenAI and Anthropic APIs
+  report  rate the models by the answers picked by users
+
+Run multi-ai <command> -h for the flags of a command.
+`
+
+func main() {
+	args := os.Args[1:]
+	command := "chat"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "chat":
+		err = runChat(args)
+	case "ask":
+		err = runAsk(args)
+	case "serve":
+		err = runServe(args)
+	case "report":
+		err = runReport(args)
+	case "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, "unknown command "+command+"\n\n"+usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "multi-ai:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// configFlag adds the -config flag to a flag set.
+func configFlag(flags *flag.FlagSet) *string {
+	path := os.Getenv("MULTI_AI_CONFIG")
+	if path == "" {
+		path = defaultConfig
+	}
+	return flags.String("config", path, "the configuration file with the models (or set MULTI_AI_CONFIG)")
+}
+
+// preferencesFlag adds the -preferences flag to a flag set.
+func preferencesFlag(flags *flag.FlagSet) *string {
+	return flags.String("preferences", os.Getenv("MULTI_AI_PREFERENCES"), "a JSON lines file to record the picked answers in, to rate the models with the report command (or set MULTI_AI_PREFERENCES)")
+}
+
+// loadClient creates a client with the models of a configuration file. It
+// returns the client and all model definitions of the file.
+func loadClient(path string) (*mac.Client, []mac.ModelDefinition, error) {
+	client, err := mac.NewClientFromConfig(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	definitions := client.GetModelDefinitions()
+	if len(definitions) == 0 {
+		return nil, nil, errors.New(path + ": no models configured")
+	}
+	return client, definitions, nil
+}
+
+// selectModels returns the model definitions with the given names, in the
+// order of definitions. Models can also be selected by their 1-based index.
+func selectModels(definitions []mac.ModelDefinition, names []string) ([]mac.ModelDefinition, error) {
+	selected := make([]bool, len(definitions))
+	for _, name := range names {
+		found := false
+		for i, definition := range definitions {
+			if definition.Name == name || fmt.Sprint(i+1) == name {
+				selected[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("unknown model " + name)
+		}
+	}
+	models := make([]mac.ModelDefinition, 0, len(names))
+	for i, definition := range definitions {
+		if selected[i] {
+			models = append(models, definition)
+		}
+	}
+	return models, nil
+}
+
+// splitList splits a comma separated flag value.
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	mac "github.com/villadelfia/multi-ai-client"
+	"golang.org/x/term"
+)
+
+// layout is an enum representing how the responses of several models are
+// shown.
+type layout int
+
+const (
+	layoutColumns layout = iota
+	layoutStacked
+)
+
+func parseLayout(s string) (layout, error) {
+	switch s {
+	case "columns", "side":
+		return layoutColumns, nil
+	case "stacked", "stack":
+		return layoutStacked, nil
+	default:
+		return 0, errors.New("unknown layout " + s + ", use columns or stacked")
+	}
+}
+
+func (l layout) String() string {
+	if l == layoutStacked {
+		return "stacked"
+	}
+	return "columns"
+}
+
+// minColumnWidth is the narrowest column shown side by side. With less room,
+// the responses are stacked.
+const minColumnWidth = 24
+
+// redrawInterval is how often streaming responses are redrawn.
+const redrawInterval = 50 * time.Millisecond
+
+// response is the state of the response of a single model.
+type response struct {
+	name   string
+	text   strings.Builder
+	done   bool
+	err    error
+	usage  *mac.Usage
+	cost   float64
+	cached bool
+	// finishReason is the reason the model stopped, as reported by the API.
+	finishReason string
+	started      time.Time
+	latency      time.Duration
+}
+
+// add applies a chunk of the response.
+func (r *response) add(chunk mac.MessageChunk) {
+	r.text.WriteString(chunk.Delta)
+	if !chunk.Done {
+		return
+	}
+	r.done = true
+	r.err = chunk.Err
+	r.usage = chunk.Usage
+	r.cost = chunk.Cost
+	r.cached = chunk.Cached
+	r.finishReason = chunk.FinishReason
+	r.latency = time.Since(r.started)
+}
+
+// ok returns whether the response finished without an error.
+func (r *response) ok() bool {
+	return r.done && r.err == nil
+}
+
+// status returns a one line summary of the state of the response.
+func (r *response) status() string {
+	switch {
+	case !r.done:
+		return fmt.Sprintf("… %.1fs", time.Since(r.started).Seconds())
+	case errors.Is(r.err, context.Canceled):
+		return "cancelled"
+	case r.err != nil:
+		return "error: " + r.err.Error()
+	}
+	status := ""
+	if r.usage != nil {
+		status = fmt.Sprintf("%d in / %d out tokens, ", r.usage.InputTokens, r.usage.OutputTokens)
+	}
+	status += fmt.Sprintf("$%.4f, %.1fs", r.cost, r.latency.Seconds())
+	if r.cached {
+		status += " (cached)"
+	}
+	return status
+}
+
+// renderer draws the responses of all models to a terminal. On a terminal,
+// the responses are redrawn as they stream in; otherwise they are written once
+// they are complete.
+type renderer struct {
+	out    io.Writer
+	layout layout
+	// live is set when out is a terminal that can be redrawn.
+	live bool
+
+	drawn    int
+	lastDraw time.Time
+}
+
+func newRenderer(out *os.File, l layout) *renderer {
+	return &renderer{out: out, layout: l, live: term.IsTerminal(int(out.Fd()))}
+}
+
+// size returns the size of the terminal, or a default size if it is unknown.
+func (r *renderer) size() (int, int) {
+	if f, ok := r.out.(*os.File); ok {
+		if width, height, err := term.GetSize(int(f.Fd())); err == nil && width > 0 && height > 0 {
+			return width, height
+		}
+	}
+	return 80, 24
+}
+
+// update redraws the streaming responses, at most every redrawInterval.
+func (r *renderer) update(responses []*response) {
+	if !r.live || time.Since(r.lastDraw) < redrawInterval {
+		return
+	}
+	width, height := r.size()
+	// Only the part of the frame that fits on the screen can be redrawn.
+	r.draw(r.frame(responses, width, height-1))
+}
+
+// finish draws the complete responses, which stay on the screen.
+func (r *renderer) finish(responses []*response) {
+	width, _ := r.size()
+	r.draw(r.frame(responses, width, 0))
+	r.drawn = 0
+}
+
+// draw replaces the previously drawn frame with lines.
+func (r *renderer) draw(lines []string) {
+	var b strings.Builder
+	if r.drawn > 0 {
+		fmt.Fprintf(&b, "\x1b[%dF\x1b[J", r.drawn)
+	}
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	_, _ = io.WriteString(r.out, b.String())
+	r.drawn = len(lines)
+	r.lastDraw = time.Now()
+}
+
+// frame returns the lines showing the responses. If height is not 0, the
+// frame is at most height lines, showing the end of each response.
+func (r *renderer) frame(responses []*response, width int, height int) []string {
+	n := len(responses)
+	if n == 0 {
+		return nil
+	}
+	columnWidth := (width - 3*(n-1)) / n
+	if r.layout == layoutColumns && n > 1 && columnWidth >= minColumnWidth {
+		return columnsFrame(responses, columnWidth, height)
+	}
+	return stackedFrame(responses, width, height)
+}
+
+// columnsFrame returns the lines showing the responses side by side.
+func columnsFrame(responses []*response, width int, height int) []string {
+	bodyHeight := 0
+	if height > 0 {
+		bodyHeight = max(height-3, 1)
+	}
+	columns := make([][]string, len(responses))
+	rows := 0
+	for i, resp := range responses {
+		body := tail(wrap(resp.text.String(), width), bodyHeight)
+		column := []string{
+			truncate(fmt.Sprintf("[%d] %s", i+1, resp.name), width),
+			strings.Repeat("─", width),
+		}
+		column = append(column, body...)
+		columns[i] = append(column, truncate(resp.status(), width))
+		rows = max(rows, len(columns[i]))
+	}
+
+	lines := make([]string, 0, rows)
+	for row := 0; row < rows; row++ {
+		cells := make([]string, len(columns))
+		for i, column := range columns {
+			cell := ""
+			if row < len(column)-1 {
+				cell = column[row]
+			} else if row == rows-1 {
+				// The status lines are aligned at the bottom.
+				cell = column[len(column)-1]
+			}
+			cells[i] = pad(cell, width)
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, " │ "), " "))
+	}
+	return lines
+}
+
+// stackedFrame returns the lines showing the responses below each other.
+func stackedFrame(responses []*response, width int, height int) []string {
+	bodyHeight := 0
+	if height > 0 {
+		bodyHeight = max(height/len(responses)-2, 1)
+	}
+	lines := make([]string, 0)
+	for i, resp := range responses {
+		header := fmt.Sprintf("── [%d] %s ", i+1, resp.name)
+		lines = append(lines, truncate(header+strings.Repeat("─", max(width-utf8.RuneCountInString(header), 0)), width))
+		lines = append(lines, tail(wrap(resp.text.String(), width), bodyHeight)...)
+		lines = append(lines, truncate(resp.status(), width))
+	}
+	return lines
+}
+
+// wrap breaks text into lines of at most width runes, at spaces where
+// possible.
+func wrap(text string, width int) []string {
+	lines := make([]string, 0)
+	for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\t", "    "), "\n") {
+		line := make([]rune, 0, width)
+		for _, word := range strings.SplitAfter(paragraph, " ") {
+			runes := []rune(word)
+			// Trailing spaces do not need to fit.
+			visible := utf8.RuneCountInString(strings.TrimRight(word, " "))
+			if len(line) > 0 && len(line)+visible > width {
+				lines = append(lines, strings.TrimRight(string(line), " "))
+				line = line[:0]
+			}
+			for visible > width {
+				lines = append(lines, string(runes[:width]))
+				runes = runes[width:]
+				visible -= width
+			}
+			line = append(line, runes...)
+		}
+		lines = append(lines, strings.TrimRight(string(line), " "))
+	}
+	return lines
+}
+
+// tail returns the last n lines, or all lines if n is 0.
+func tail(lines []string, n int) []string {
+	if n > 0 && len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// truncate shortens s to at most width runes.
+func truncate(s string, width int) string {
+	s = strings.ReplaceAll(s, "\n", " ")
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	runes := []rune(s)
+	return string(runes[:width-1]) + "…"
+}
+
+// pad fills s with spaces to width runes.
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+func runReport(args []string) error {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	preferences := preferencesFlag(flags)
+	method := flags.String("method", "bradley-terry", "how to rate the models: bradley-terry or elo")
+	format := flags.String("format", "text", "the output format: text or json")
+	bootstrap := flags.Int("bootstrap", 200, "how often to resample the comparisons to estimate the confidence intervals, 0 for no intervals")
+	confidence := flags.Float64("confidence", 0.95, "the confidence level of the intervals")
+	k := flags.Float64("k", 32, "the K-factor of Elo ratings")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *preferences == "" {
+		return errors.New("no preferences file, set -preferences or MULTI_AI_PREFERENCES")
+	}
+	if *format != "text" && *format != "json" {
+		return errors.New("unknown format " + *format + ", use text or json")
+	}
+	if *confidence <= 0 || *confidence >= 1 {
+		return errors.New("the confidence level must be between 0 and 1")
+	}
+
+	recorded, err := mac.NewFilePreferenceStore(*preferences).All()
+	if err != nil {
+		return err
+	}
+	options := mac.RatingOptions{K: *k, Bootstrap: *bootstrap, Confidence: *confidence}
+	if *bootstrap <= 0 {
+		options.Bootstrap = -1
+	}
+	var ratings []mac.Rating
+	switch *method {
+	case "bradley-terry", "bt":
+		ratings = mac.BradleyTerryRatings(recorded, options)
+	case "elo":
+		ratings = mac.EloRatings(recorded, options)
+	default:
+		return errors.New("unknown method " + *method + ", use bradley-terry or elo")
+	}
+
+	if *format == "json" {
+		if ratings == nil {
+			ratings = []mac.Rating{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(ratings)
+	}
+	if len(recorded) == 0 {
+		fmt.Println("No preferences recorded in " + *preferences + ".")
+		return nil
+	}
+	writeLeaderboard(os.Stdout, ratings, len(recorded), *bootstrap > 0, *confidence)
+	return nil
+}
+
+// writeLeaderboard writes the ratings as a table.
+func writeLeaderboard(out io.Writer, ratings []mac.Rating, preferences int, intervals bool, confidence float64) {
+	fmt.Fprintf(out, "%d models rated by %d preferences.\n\n", len(ratings), preferences)
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	if intervals {
+		fmt.Fprintf(w, "#\tMODEL\tRATING\t%g%% INTERVAL\tWINS\tLOSSES\n", confidence*100)
+	} else {
+		fmt.Fprintln(w, "#\tMODEL\tRATING\tWINS\tLOSSES")
+	}
+	for i, r := range ratings {
+		if intervals {
+			fmt.Fprintf(w, "%d\t%s\t%.0f\t%.0f to %.0f\t%d\t%d\n", i+1, r.Model, r.Rating, r.Lower, r.Upper, r.Wins, r.Losses)
+		} else {
+			fmt.Fprintf(w, "%d\t%s\t%.0f\t%d\t%d\n", i+1, r.Model, r.Rating, r.Wins, r.Losses)
+		}
+	}
+	_ = w.Flush()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	mac "github.com/villadelfia/multi-ai-client"
+	"github.com/villadelfia/multi-ai-client/gateway"
+	"github.com/villadelfia/multi-ai-client/webui"
+)
+
+func runServe(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	config := configFlag(flags)
+	addr := flags.String("addr", "127.0.0.1:8080", "the address to listen on")
+	tokens := flags.String("tokens", "", "a YAML or JSON file with the accepted tokens")
+	unauthenticated := flags.Bool("allow-unauthenticated", false, "accept every request if there is no tokens file")
+	watch := flags.Duration("watch", 2*time.Second, "how often to check the configuration file for changes, 0 to never reload it")
+	origins := flags.String("origins", "", "comma separated web pages that may send requests and open WebSocket connections (default the host of the server)")
+	concurrency := flags.Int("max-concurrent", gateway.DefaultMaxConcurrentRequests, "the amount of requests a WebSocket connection may have in progress at once")
+	preferences := preferencesFlag(flags)
+	ui := flags.Bool("ui", false, "also serve a web app to compare the answers of the models at /")
+	verbose := flags.Bool("v", false, "also log debug messages, such as the requests sent upstream")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	level := slog.LevelInfo
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	client, _, err := loadClient(*config)
+	if err != nil {
+		return err
+	}
+	client.Logger = logger
+	if *preferences != "" {
+		client.Preferences = mac.NewFilePreferenceStore(*preferences)
+	}
+	if *watch > 0 {
+		if err := client.WatchConfig(ctx, *config, *watch, nil); err != nil {
+			return err
+		}
+	}
+
+	var server *gateway.Server
+	if *tokens != "" {
+		accepted, err := gateway.LoadTokens(*tokens)
+		if err != nil {
+			return err
+		}
+		if server, err = gateway.NewServer(client, accepted...); err != nil {
+			return err
+		}
+	} else if *unauthenticated {
+		logger.Warn("no tokens configured, every request is accepted")
+		server = &gateway.Server{Client: client, AllowUnauthenticated: true}
+	} else {
+		return errors.New("serve: -tokens is required, or -allow-unauthenticated to accept every request")
+	}
+	server.Logger = logger
+	server.AllowedOrigins = splitList(*origins)
+	server.MaxConcurrentRequests = *concurrency
+
+	var handler http.Handler = server
+	if *ui {
+		mux := http.NewServeMux()
+		mux.Handle("/v1/", server)
+		mux.Handle("/", webui.Handler())
+		handler = mux
+	}
+
+	httpServer := &http.Server{Addr: *addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = httpServer.Shutdown(shutdownCtx)
+	}()
+	logger.Info("listening", slog.String("addr", *addr), slog.Int("models", len(client.GetModelDefinitions())))
+	if *ui {
+		logger.Info("web app available", slog.String("url", "http://"+*addr+"/"))
+	}
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
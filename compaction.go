@@ -0,0 +1,186 @@
+package multi_ai_client
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// CompactionMode is an enum representing how the summary of a compacted chat
+// is presented to the models.
+type CompactionMode int
+
+const (
+	// SummaryAsSystemAddendum appends the summary to the system message.
+	SummaryAsSystemAddendum CompactionMode = iota
+	// SummaryAsExchange inserts the summary as a synthetic user message,
+	// followed by a short assistant acknowledgement, before the kept messages.
+	SummaryAsExchange
+)
+
+// DefaultCompactionPrompt is the system prompt given to the summarizer if no
+// other prompt is set.
+const DefaultCompactionPrompt = "You are summarizing the earlier part of a conversation between a user and an assistant, " +
+	"so that the conversation can continue without the original messages. " +
+	"Write a concise summary that keeps all facts, decisions, names, numbers and open questions that may matter later. " +
+	"Respond with the summary only."
+
+// Compaction is a struct representing the settings used to compact a chat by
+// summarizing its older messages.
+type Compaction struct {
+	// Summarizer is the model used to write the summary.
+	Summarizer *ModelDefinition
+
+	// Threshold is the amount of tokens, as counted by the summarizer, above
+	// which the chat is compacted.
+	Threshold int
+
+	// KeepMessages is the amount of most recent messages that are never
+	// summarized. If it is 0, the last 4 messages are kept.
+	KeepMessages int
+
+	// Prompt is the system prompt given to the summarizer. If it is empty,
+	// DefaultCompactionPrompt is used.
+	Prompt string
+
+	// Mode determines how the summary is presented to the models.
+	Mode CompactionMode
+
+	// Acknowledgement is the assistant message that follows the summary when
+	// Mode is SummaryAsExchange. If it is empty, "Understood." is used.
+	Acknowledgement string
+}
+
+// SetCompaction enables summarization based compaction of the chat.
+// Passing nil disables it. Messages that were already compacted stay that way.
+func (c *Chat) SetCompaction(compaction *Compaction) {
+	c.compaction = compaction
+}
+
+// GetSummary returns the summary of the compacted messages, or an empty string
+// if the chat was never compacted.
+func (c *Chat) GetSummary() string {
+	return c.summary
+}
+
+// GetArchivedMessages returns the original messages that were replaced by the
+// summary, in the order they were added to the chat.
+func (c *Chat) GetArchivedMessages() []Message {
+	return c.archive
+}
+
+// NeedsCompaction returns whether compaction is enabled and the chat exceeds
+// the compaction threshold.
+func (c *Chat) NeedsCompaction() bool {
+	if c.compaction == nil || c.compaction.Summarizer == nil || c.compaction.Threshold <= 0 {
+		return false
+	}
+	return c.compaction.Summarizer.CountTokens(*c) > c.compaction.Threshold
+}
+
+// Compact summarizes all but the most recent messages of the chat with its
+// summarizer, replacing them with the summary.
+// The replaced messages are kept in the archive. If there is nothing to
+// summarize, this function does nothing. The summary is requested through the
+// client, so its HTTP client, budgets, costs, cache and observers apply.
+func (c *Client) Compact(ctx context.Context, chat *Chat) error {
+	if chat.compaction == nil || chat.compaction.Summarizer == nil {
+		return errors.New("compaction is not enabled for this chat")
+	}
+
+	keep := chat.compaction.KeepMessages
+	if keep <= 0 {
+		keep = 4
+	}
+	split := len(chat.messages) - keep
+	for split > 0 && chat.messages[split].Type != UserMessage {
+		split++
+		if split == len(chat.messages) {
+			return nil
+		}
+	}
+	if split <= 0 {
+		return nil
+	}
+
+	prompt := chat.compaction.Prompt
+	if prompt == "" {
+		prompt = DefaultCompactionPrompt
+	}
+	request := Chat{}
+	request.SetSystemMessage(prompt)
+	request.AddUserMessage(transcript(chat.summary, chat.messages[:split]))
+
+	summary, err := c.complete(ctx, &request, *chat.compaction.Summarizer)
+	if err != nil {
+		return err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return errors.New("summarizer returned an empty summary")
+	}
+
+	chat.pushUndo()
+	chat.archive = append(chat.archive, chat.messages[:split]...)
+	chat.messages = append(make([]Message, 0, len(chat.messages)-split), chat.messages[split:]...)
+	chat.summary = summary
+	return nil
+}
+
+// compactIfNeeded compacts the chat if it exceeds the compaction threshold.
+func (c *Client) compactIfNeeded(ctx context.Context, chat *Chat) error {
+	if !chat.NeedsCompaction() {
+		return nil
+	}
+	return c.Compact(ctx, chat)
+}
+
+// summaryMessages returns the messages that present the summary to the models
+// when the summary is given as a synthetic exchange.
+func (c *Chat) summaryMessages() []Message {
+	if c.summary == "" || c.compaction == nil || c.compaction.Mode != SummaryAsExchange {
+		return nil
+	}
+	ack := c.compaction.Acknowledgement
+	if ack == "" {
+		ack = "Understood."
+	}
+	return []Message{
+		*NewUserMessage("Summary of our conversation so far:\n\n" + c.summary),
+		*NewAssistantMessage(ack),
+	}
+}
+
+// effectiveSystemMessage returns the system message as sent to the models,
+// including the summary when it is given as a system addendum.
+func (c *Chat) effectiveSystemMessage() string {
+	system := c.GetSystemMessage()
+	if c.summary == "" || (c.compaction != nil && c.compaction.Mode != SummaryAsSystemAddendum) {
+		return system
+	}
+	addendum := "Summary of the conversation so far:\n\n" + c.summary
+	if system == "" {
+		return addendum
+	}
+	return system + "\n\n" + addendum
+}
+
+func transcript(summary string, messages []Message) string {
+	var sb strings.Builder
+	if summary != "" {
+		sb.WriteString("Summary of the conversation before this point:\n")
+		sb.WriteString(summary)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("Conversation to summarize:\n")
+	for _, m := range messages {
+		if m.Type == UserMessage {
+			sb.WriteString("\nUser: ")
+		} else {
+			sb.WriteString("\nAssistant: ")
+		}
+		sb.WriteString(m.Text)
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
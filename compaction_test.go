@@ -0,0 +1,82 @@
+package multi_ai_client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type contextKey struct{}
+
+func TestCompactThroughClient(t *testing.T) {
+	requests := 0
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		if req.Context().Value(contextKey{}) != "caller" {
+			t.Error("the summary is not requested with the context of the caller")
+		}
+		body := `data: {"choices": [{"delta": {"content": "  They said hi.  "}, "finish_reason": "stop"}]}` + "\n\n" +
+			"data: [DONE]\n\n"
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+	client := &Client{HTTPClient: &http.Client{Transport: transport}}
+	summarizer := NewModelDefinition("Summarizer", OpenAI, "key", "gpt-4o-mini")
+
+	chat := Chat{}
+	chat.SetCompaction(&Compaction{Summarizer: &summarizer, KeepMessages: 2})
+	chat.AddUserMessage("Hi!")
+	chat.AddAssistantMessage("Hello.")
+	chat.AddUserMessage("How are you?")
+	chat.AddAssistantMessage("Fine.")
+
+	ctx := context.WithValue(context.Background(), contextKey{}, "caller")
+	if err := client.Compact(ctx, &chat); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Errorf("sent %d requests, want 1", requests)
+	}
+	if chat.GetSummary() != "They said hi." {
+		t.Errorf("summary %q", chat.GetSummary())
+	}
+	if len(chat.GetArchivedMessages()) != 2 || len(chat.GetMessages()) != 2 {
+		t.Errorf("archived %d and kept %d messages, want 2 and 2", len(chat.GetArchivedMessages()), len(chat.GetMessages()))
+	}
+	if total := client.Costs().Total(); total.Responses != 1 || total.Cost == 0 {
+		t.Errorf("recorded %+v, want the cost of the summary", total)
+	}
+}
+
+func TestCompactFailure(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Status:     "429 Too Many Requests",
+			Body:       io.NopCloser(strings.NewReader("slow down")),
+			Request:    req,
+		}, nil
+	})
+	client := &Client{HTTPClient: &http.Client{Transport: transport}}
+	summarizer := NewModelDefinition("Summarizer", OpenAI, "key", "gpt-4o-mini")
+
+	chat := Chat{}
+	chat.SetCompaction(&Compaction{Summarizer: &summarizer, KeepMessages: 2})
+	chat.AddUserMessage("Hi!")
+	chat.AddAssistantMessage("Hello.")
+	chat.AddUserMessage("How are you?")
+
+	var apiError *APIError
+	if err := client.Compact(context.Background(), &chat); !errors.As(err, &apiError) {
+		t.Fatalf("error %v, want an *APIError", err)
+	}
+	if chat.GetSummary() != "" || len(chat.GetMessages()) != 3 {
+		t.Error("the chat was compacted by a failed summary")
+	}
+}
@@ -0,0 +1,731 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a struct representing the declarative configuration of a client,
+// as loaded from a YAML or JSON file:
+//
+//	models:
+//	  - name: GPT-4o
+//	    provider: openai
+//	    model: gpt-4o
+//	    api_key: ${OPENAI_API_KEY}
+//	    settings:
+//	      temperature: 0.8
+//	    tags: [fast]
+//	  - name: Claude
+//	    provider: anthropic
+//	    model: claude-3-5-sonnet-latest
+//	    api_key_file: ~/.secrets/anthropic
+//	    pricing: {input: 3, output: 15}
+//
+// API keys can not be written inline. They are referenced as an environment
+// variable with api_key, or read from a file with api_key_file.
+type Config struct {
+	Models []ModelConfig `json:"models" yaml:"models"`
+
+	// file is the file the config was loaded from. Relative API key files are
+	// resolved against its directory.
+	file string
+}
+
+// ModelConfig is a struct representing the configuration of a single model
+// definition.
+type ModelConfig struct {
+	Name string `json:"name" yaml:"name"`
+	// Provider is the API type: openai, mistral or anthropic.
+	Provider string `json:"provider" yaml:"provider"`
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	Model    string `json:"model" yaml:"model"`
+	// APIKey is a reference to an environment variable holding the API key,
+	// written as ${NAME}.
+	APIKey string `json:"api_key,omitempty" yaml:"api_key,omitempty"`
+	// APIKeyFile is the path of a file holding the API key. Relative paths
+	// are relative to the configuration file.
+	APIKeyFile           string                 `json:"api_key_file,omitempty" yaml:"api_key_file,omitempty"`
+	ContextWindow        int                    `json:"context_window,omitempty" yaml:"context_window,omitempty"`
+	ReservedOutputTokens int                    `json:"reserved_output_tokens,omitempty" yaml:"reserved_output_tokens,omitempty"`
+	Settings             map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+	Tags                 []string               `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Pricing              *Pricing               `json:"pricing,omitempty" yaml:"pricing,omitempty"`
+
+	// line and column are the position of the model in the configuration
+	// file, if it was loaded from one.
+	line, column int
+}
+
+// ConfigError is an error representing a problem at a position in a
+// configuration file.
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	position := e.File
+	if e.Line > 0 {
+		position += ":" + strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Column)
+	}
+	if position == "" {
+		return e.Message
+	}
+	return position + ": " + e.Message
+}
+
+// ConfigErrors is an error holding every problem found in a configuration
+// file, in order of their position.
+type ConfigErrors []*ConfigError
+
+func (e ConfigErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// settingKind is the type of value a setting accepts.
+type settingKind int
+
+const (
+	settingString settingKind = iota
+	settingFloat
+	settingInt
+	settingBool
+	settingStrings
+	settingIntMap
+)
+
+func (k settingKind) String() string {
+	switch k {
+	case settingString:
+		return "a string"
+	case settingFloat:
+		return "a number"
+	case settingInt:
+		return "an integer"
+	case settingBool:
+		return "a boolean"
+	case settingStrings:
+		return "a list of strings"
+	default:
+		return "a map of integers"
+	}
+}
+
+// settingKinds are the settings accepted by ModelSettings.Set per API type,
+// except for the model, which has a field of its own.
+var settingKinds = map[APIType]map[string]settingKind{
+	OpenAI: {
+		"frequency_penalty":   settingFloat,
+		"logit_bias":          settingIntMap,
+		"logprobs":            settingBool,
+		"top_logprobs":        settingInt,
+		"max_tokens":          settingInt,
+		"presence_penalty":    settingFloat,
+		"response_format":     settingString,
+		"seed":                settingInt,
+		"stop":                settingStrings,
+		"temperature":         settingFloat,
+		"top_p":               settingFloat,
+		"user":                settingString,
+		"prefill_strategy":    settingString,
+		"prefill_instruction": settingString,
+	},
+	Mistral: {
+		"response_format": settingString,
+		"temperature":     settingFloat,
+		"top_p":           settingFloat,
+		"max_tokens":      settingInt,
+		"safe_prompt":     settingBool,
+		"random_seed":     settingInt,
+	},
+	Anthropic: {
+		"max_tokens":     settingInt,
+		"metadata":       settingString,
+		"stop_sequences": settingStrings,
+		"temperature":    settingFloat,
+		"top_k":          settingInt,
+		"top_p":          settingFloat,
+	},
+}
+
+// ParseAPIType returns the API type with the given name, as returned by
+// APIType.String.
+func ParseAPIType(s string) (APIType, error) {
+	for _, t := range []APIType{OpenAI, Mistral, Anthropic} {
+		if t.String() == s {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid provider %q, expected one of openai, mistral or anthropic", s)
+}
+
+var envReference = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// LoadConfig reads and validates a configuration file. YAML and JSON files
+// are both accepted. Problems are returned as ConfigErrors.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data, path)
+}
+
+// ParseConfig parses and validates a configuration. The file name is used in
+// errors, and relative API key files are resolved against its directory.
+// Problems are returned as ConfigErrors.
+func ParseConfig(data []byte, file string) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, ConfigErrors{syntaxError(file, err)}
+	}
+
+	v := &configValidator{file: file}
+	config := &Config{file: file}
+	if len(root.Content) > 0 {
+		v.config(root.Content[0], config)
+	}
+	if len(v.errors) > 0 {
+		sort.SliceStable(v.errors, func(i, j int) bool {
+			if v.errors[i].Line != v.errors[j].Line {
+				return v.errors[i].Line < v.errors[j].Line
+			}
+			return v.errors[i].Column < v.errors[j].Column
+		})
+		return nil, v.errors
+	}
+	return config, nil
+}
+
+var yamlLine = regexp.MustCompile(`line (\d+)`)
+
+// syntaxError converts a parse error of the YAML package to a ConfigError.
+func syntaxError(file string, err error) *ConfigError {
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	line := 0
+	if m := yamlLine.FindStringSubmatch(message); m != nil {
+		line, _ = strconv.Atoi(m[1])
+		message = strings.TrimSpace(yamlLine.ReplaceAllString(message, ""))
+		message = strings.TrimPrefix(strings.TrimPrefix(message, ":"), " ")
+	}
+	return &ConfigError{File: file, Line: line, Column: 1, Message: message}
+}
+
+// configValidator walks the parsed document, collecting every problem with
+// its position.
+type configValidator struct {
+	file   string
+	errors ConfigErrors
+}
+
+func (v *configValidator) errorf(node *yaml.Node, format string, args ...interface{}) {
+	v.errors = append(v.errors, &ConfigError{
+		File:    v.file,
+		Line:    node.Line,
+		Column:  node.Column,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// fields returns the keys and values of a mapping, reporting unknown and
+// duplicate keys.
+func (v *configValidator) fields(node *yaml.Node, known ...string) map[string]*yaml.Node {
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if _, ok := fields[key.Value]; ok {
+			v.errorf(key, "duplicate key %q", key.Value)
+			continue
+		}
+		found := known == nil
+		for _, k := range known {
+			if k == key.Value {
+				found = true
+			}
+		}
+		if !found {
+			v.errorf(key, "unknown key %q", key.Value)
+			continue
+		}
+		fields[key.Value] = value
+	}
+	return fields
+}
+
+func (v *configValidator) decode(node *yaml.Node, kind settingKind, out interface{}) bool {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		v.errorf(node, "expected %s, got null", kind)
+		return false
+	}
+	mismatch := false
+	switch kind {
+	case settingFloat:
+		mismatch = node.Tag != "!!float" && node.Tag != "!!int"
+	case settingInt:
+		mismatch = node.Tag != "!!int"
+	case settingBool:
+		mismatch = node.Tag != "!!bool"
+	}
+	if err := node.Decode(out); mismatch || err != nil {
+		v.errorf(node, "expected %s", kind)
+		return false
+	}
+	return true
+}
+
+func (v *configValidator) config(node *yaml.Node, config *Config) {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "expected a mapping with a models key")
+		return
+	}
+	fields := v.fields(node, "models")
+	models, ok := fields["models"]
+	if !ok {
+		v.errorf(node, "missing key \"models\"")
+		return
+	}
+	if models.Kind != yaml.SequenceNode {
+		v.errorf(models, "expected a list of models")
+		return
+	}
+
+	names := make(map[string]int)
+	for _, m := range models.Content {
+		model, ok := v.model(m)
+		if !ok {
+			continue
+		}
+		if line, ok := names[model.Name]; ok {
+			v.errorf(m, "duplicate model name %q, first used on line %d", model.Name, line)
+			continue
+		}
+		names[model.Name] = m.Line
+		config.Models = append(config.Models, model)
+	}
+}
+
+func (v *configValidator) model(node *yaml.Node) (ModelConfig, bool) {
+	model := ModelConfig{line: node.Line, column: node.Column}
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "expected a mapping describing a model")
+		return model, false
+	}
+	problems := len(v.errors)
+	fields := v.fields(node, "name", "provider", "endpoint", "model", "api_key", "api_key_file",
+		"context_window", "reserved_output_tokens", "settings", "tags", "pricing")
+
+	for _, key := range []string{"name", "provider", "model"} {
+		if _, ok := fields[key]; !ok {
+			v.errorf(node, "missing key %q", key)
+		}
+	}
+	stringFields := map[string]*string{
+		"name":         &model.Name,
+		"provider":     &model.Provider,
+		"endpoint":     &model.Endpoint,
+		"model":        &model.Model,
+		"api_key":      &model.APIKey,
+		"api_key_file": &model.APIKeyFile,
+	}
+	for key, out := range stringFields {
+		if value, ok := fields[key]; ok && v.decode(value, settingString, out) && *out == "" {
+			v.errorf(value, "%s may not be empty", key)
+		}
+	}
+	for key, out := range map[string]*int{"context_window": &model.ContextWindow, "reserved_output_tokens": &model.ReservedOutputTokens} {
+		if value, ok := fields[key]; ok && v.decode(value, settingInt, out) && *out < 0 {
+			v.errorf(value, "%s may not be negative", key)
+		}
+	}
+	if value, ok := fields["tags"]; ok {
+		v.decode(value, settingStrings, &model.Tags)
+	}
+
+	apiType, err := ParseAPIType(model.Provider)
+	if value, ok := fields["provider"]; ok && model.Provider != "" && err != nil {
+		v.errorf(value, "%s", err.Error())
+	}
+	if value, ok := fields["api_key"]; ok && model.APIKey != "" && !envReference.MatchString(model.APIKey) {
+		v.errorf(value, "api_key must reference an environment variable as ${NAME}, use api_key_file to read it from a file")
+	}
+	if _, ok := fields["api_key"]; ok {
+		if value, ok := fields["api_key_file"]; ok {
+			v.errorf(value, "api_key and api_key_file can not both be set")
+		}
+	}
+	if value, ok := fields["pricing"]; ok {
+		model.Pricing = v.pricing(value)
+	}
+	if value, ok := fields["settings"]; ok && err == nil {
+		model.Settings = v.settings(value, apiType)
+	}
+	return model, len(v.errors) == problems
+}
+
+func (v *configValidator) pricing(node *yaml.Node) *Pricing {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "expected a mapping with input, output, cached_input and cache_write prices")
+		return nil
+	}
+	pricing := &Pricing{}
+	fields := v.fields(node, "input", "output", "cached_input", "cache_write")
+	for key, out := range map[string]*float64{"input": &pricing.Input, "output": &pricing.Output, "cached_input": &pricing.CachedInput, "cache_write": &pricing.CacheWrite} {
+		if value, ok := fields[key]; ok && v.decode(value, settingFloat, out) && *out < 0 {
+			v.errorf(value, "%s price may not be negative", key)
+		}
+	}
+	return pricing
+}
+
+func (v *configValidator) settings(node *yaml.Node, apiType APIType) map[string]interface{} {
+	if node.Kind != yaml.MappingNode {
+		v.errorf(node, "expected a mapping of settings")
+		return nil
+	}
+	kinds := settingKinds[apiType]
+	settings := make(map[string]interface{})
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		if key.Value == "model" {
+			v.errorf(key, "set the model with the model key of the model, not in its settings")
+			continue
+		}
+		kind, ok := kinds[key.Value]
+		if !ok {
+			v.errorf(key, "unknown setting %q for provider %s", key.Value, apiType)
+			continue
+		}
+		if _, ok := settings[key.Value]; ok {
+			v.errorf(key, "duplicate setting %q", key.Value)
+			continue
+		}
+		if parsed, ok := v.setting(value, kind); ok {
+			if err := NewModelSettings(apiType, "").Set(key.Value, parsed); err != nil {
+				v.errorf(value, "invalid value for %s: %s", key.Value, err.Error())
+				continue
+			}
+			settings[key.Value] = parsed
+		}
+	}
+	return settings
+}
+
+// setting decodes the value of a setting into the type ModelSettings.Set
+// expects for it.
+func (v *configValidator) setting(node *yaml.Node, kind settingKind) (interface{}, bool) {
+	switch kind {
+	case settingString:
+		var s string
+		return s, v.decode(node, kind, &s)
+	case settingFloat:
+		var f float64
+		return f, v.decode(node, kind, &f)
+	case settingInt:
+		var i int
+		return i, v.decode(node, kind, &i)
+	case settingBool:
+		var b bool
+		return b, v.decode(node, kind, &b)
+	case settingStrings:
+		var s []string
+		return s, v.decode(node, kind, &s)
+	default:
+		var m map[string]int
+		return m, v.decode(node, kind, &m)
+	}
+}
+
+// ModelDefinitions creates the model definitions described by the config,
+// resolving their API keys. Problems are returned as ConfigErrors.
+func (c *Config) ModelDefinitions() ([]ModelDefinition, error) {
+	dir := ""
+	if c.file != "" {
+		dir = filepath.Dir(c.file)
+	}
+	definitions := make([]ModelDefinition, 0, len(c.Models))
+	var problems ConfigErrors
+	for _, model := range c.Models {
+		definition, err := model.ModelDefinition(dir)
+		if err != nil {
+			problems = append(problems, &ConfigError{File: c.file, Line: model.line, Column: model.column, Message: err.Error()})
+			continue
+		}
+		definitions = append(definitions, definition)
+	}
+	if len(problems) > 0 {
+		return nil, problems
+	}
+	return definitions, nil
+}
+
+// ModelDefinition creates the model definition described by the model config.
+// The API key is read from its environment variable or file for every
+// request, but it must be available now. Relative API key files are resolved
+// against dir.
+func (m ModelConfig) ModelDefinition(dir string) (ModelDefinition, error) {
+	apiType, err := ParseAPIType(m.Provider)
+	if err != nil {
+		return ModelDefinition{}, fmt.Errorf("model %s: %w", m.Name, err)
+	}
+
+	var credentials CredentialProvider
+	ref := ""
+	switch {
+	case m.APIKey != "":
+		match := envReference.FindStringSubmatch(m.APIKey)
+		if match == nil {
+			return ModelDefinition{}, fmt.Errorf("model %s: api_key must reference an environment variable as ${NAME}", m.Name)
+		}
+		credentials, ref = EnvCredential(match[1]), m.APIKey
+	case m.APIKeyFile != "":
+		credentials, ref = FileCredential(resolvePath(dir, m.APIKeyFile)), m.APIKeyFile
+	}
+	if credentials != nil {
+		if _, err := credentials.GetAPIKey(); err != nil {
+			return ModelDefinition{}, fmt.Errorf("model %s: could not get API key: %w", m.Name, err)
+		}
+	}
+
+	definition := NewModelDefinition(m.Name, apiType, "", m.Model)
+	definition.APISettings.APIEndpoint = m.Endpoint
+	definition.APISettings.APIKeyRef = ref
+	definition.APISettings.Credentials = credentials
+	definition.ContextWindow = m.ContextWindow
+	definition.ReservedOutputTokens = m.ReservedOutputTokens
+	definition.Tags = append([]string(nil), m.Tags...)
+	if m.Pricing != nil {
+		pricing := *m.Pricing
+		definition.Pricing = &pricing
+	}
+
+	keys := make([]string, 0, len(m.Settings))
+	for key := range m.Settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := definition.ModelSettings.Set(key, m.Settings[key]); err != nil {
+			return ModelDefinition{}, fmt.Errorf("model %s: setting %s: %w", m.Name, key, err)
+		}
+	}
+	return definition, nil
+}
+
+// resolvePath expands a leading ~ to the home directory, and makes relative
+// paths relative to dir.
+func resolvePath(dir string, path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[1:])
+		}
+	}
+	if !filepath.IsAbs(path) && dir != "" {
+		path = filepath.Join(dir, path)
+	}
+	return path
+}
+
+// NewClientFromConfig creates a client with the model definitions described
+// by a configuration file.
+func NewClientFromConfig(path string) (*Client, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	definitions, err := config.ModelDefinitions()
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{}
+	for _, definition := range definitions {
+		client.AddModelDefinition(definition)
+	}
+	return client, nil
+}
+
+// Config returns the configuration of the model definitions of the client.
+// API keys are not included, only the references they were loaded from.
+func (c *Client) Config() *Config {
+	definitions := c.loadModelDefinitions()
+	config := &Config{Models: make([]ModelConfig, 0, len(definitions))}
+	for _, definition := range definitions {
+		config.Models = append(config.Models, NewModelConfig(definition))
+	}
+	return config
+}
+
+// ExportConfig writes the configuration of the model definitions of the client
+// to a file, as JSON if its name ends in .json and as YAML otherwise.
+func (c *Client) ExportConfig(path string) error {
+	return c.Config().WriteFile(path)
+}
+
+// NewModelConfig returns the configuration of a model definition. The API key
+// is not included, only the reference it was loaded from.
+func NewModelConfig(definition ModelDefinition) ModelConfig {
+	model := ModelConfig{
+		Name:                 definition.Name,
+		Provider:             definition.APISettings.APIType.String(),
+		Endpoint:             definition.APISettings.APIEndpoint,
+		Model:                definition.GetModelName(),
+		ContextWindow:        definition.ContextWindow,
+		ReservedOutputTokens: definition.ReservedOutputTokens,
+		Settings:             settingsOf(definition.APISettings.APIType, definition.ModelSettings),
+		Tags:                 append([]string(nil), definition.Tags...),
+	}
+	if ref := definition.APISettings.APIKeyRef; envReference.MatchString(ref) {
+		model.APIKey = ref
+	} else if ref != "" {
+		model.APIKeyFile = ref
+	}
+	if definition.Pricing != nil {
+		pricing := *definition.Pricing
+		model.Pricing = &pricing
+	}
+	return model
+}
+
+// settingsOf returns the settings of the model settings that are set, in the
+// form accepted by ModelSettings.Set, with the types listed in settingKinds.
+func settingsOf(apiType APIType, settings ModelSettings) map[string]interface{} {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil
+	}
+	for _, key := range []string{"model", "messages", "stream", "stream_options", "system"} {
+		delete(values, key)
+	}
+	for key, value := range values {
+		if value == nil {
+			delete(values, key)
+		}
+	}
+	if format, ok := values["response_format"].(map[string]interface{}); ok {
+		values["response_format"] = format["type"]
+	}
+	if metadata, ok := values["metadata"].(map[string]interface{}); ok {
+		values["metadata"] = metadata["user_id"]
+	}
+	if maxTokens, ok := values["max_tokens"].(float64); ok && maxTokens == 0 {
+		delete(values, "max_tokens")
+	}
+	if s, ok := settings.(*ModelSettingsOpenAI); ok {
+		if s.PrefillStrategy != PrefillAsSystemInstruction {
+			values["prefill_strategy"] = s.PrefillStrategy.String()
+		}
+		if s.PrefillInstruction != "" {
+			values["prefill_instruction"] = s.PrefillInstruction
+		}
+	}
+	// Decoding JSON made all numbers float64 and all lists []interface{}.
+	for key, value := range values {
+		if converted, err := convertSetting(value, settingKinds[apiType][key]); err == nil {
+			values[key] = converted
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// convertSetting converts the value of a setting to the type of its kind.
+func convertSetting(value interface{}, kind settingKind) (interface{}, error) {
+	switch kind {
+	case settingFloat:
+		return toFloat(value)
+	case settingInt:
+		return toInt(value)
+	case settingBool:
+		return toBool(value)
+	case settingStrings:
+		return toStrings(value)
+	case settingIntMap:
+		return toIntMap(value)
+	default:
+		return toString(value)
+	}
+}
+
+// Marshal encodes the config as JSON if asJSON is set, and as YAML otherwise.
+func (c *Config) Marshal(asJSON bool) ([]byte, error) {
+	if asJSON {
+		data, err := json.MarshalIndent(c, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	quoted := *c
+	quoted.Models = make([]ModelConfig, len(c.Models))
+	for i, model := range c.Models {
+		settings := make(map[string]interface{}, len(model.Settings))
+		for key, value := range model.Settings {
+			settings[key] = quoteLeadingSpace(value)
+		}
+		if model.Settings == nil {
+			settings = nil
+		}
+		model.Settings = settings
+		quoted.Models[i] = model
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&quoted); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// quoteLeadingSpace returns strings that start with whitespace, such as stop
+// sequences, as double quoted YAML nodes. The YAML encoder writes them as
+// block scalars that it can not read back.
+func quoteLeadingSpace(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if strings.TrimLeft(v, " \t\r\n") != v {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.DoubleQuotedStyle, Value: v}
+		}
+	case []string:
+		values := make([]interface{}, len(v))
+		for i, s := range v {
+			values[i] = quoteLeadingSpace(s)
+		}
+		return values
+	}
+	return value
+}
+
+// WriteFile writes the config to a file, as JSON if its name ends in .json and
+// as YAML otherwise.
+func (c *Config) WriteFile(path string) error {
+	data, err := c.Marshal(strings.EqualFold(filepath.Ext(path), ".json"))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,126 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// testDefinitions returns model definitions with settings of every kind.
+func testDefinitions(t *testing.T) []ModelDefinition {
+	t.Helper()
+	set := func(m *ModelDefinition, key string, value interface{}) {
+		if err := m.ModelSettings.Set(key, value); err != nil {
+			t.Fatalf("%s: Set(%q): %v", m.Name, key, err)
+		}
+	}
+
+	openAI := NewModelDefinition("GPT", OpenAI, "", "gpt-4o")
+	set(&openAI, "max_tokens", 256)
+	set(&openAI, "temperature", 0.5)
+	set(&openAI, "seed", 7)
+	set(&openAI, "stop", []string{"END"})
+	set(&openAI, "logit_bias", map[string]int{"50256": -100})
+	set(&openAI, "logprobs", true)
+	set(&openAI, "response_format", "json_object")
+	set(&openAI, "prefill_strategy", "user_instruction")
+
+	mistral := NewModelDefinition("Mistral", Mistral, "", "mistral-large-latest")
+	set(&mistral, "max_tokens", 128)
+	set(&mistral, "random_seed", 3)
+	set(&mistral, "safe_prompt", true)
+
+	anthropic := NewModelDefinition("Claude", Anthropic, "", "claude-3-5-sonnet-20240620")
+	set(&anthropic, "max_tokens", 1024)
+	set(&anthropic, "top_k", 40)
+	set(&anthropic, "metadata", "user-1")
+	set(&anthropic, "stop_sequences", []string{"\n\nHuman:"})
+
+	return []ModelDefinition{openAI, mistral, anthropic}
+}
+
+// bodies returns the request bodies of the model definitions for a chat.
+func bodies(definitions []ModelDefinition) [][]byte {
+	chat := Chat{}
+	chat.AddUserMessage("Hi!")
+	result := make([][]byte, len(definitions))
+	for i, definition := range definitions {
+		result[i] = definition.ModelSettings.MakeBody(chat)
+	}
+	return result
+}
+
+func compareBodies(t *testing.T, want, got []ModelDefinition) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %d model definitions, want %d", len(got), len(want))
+	}
+	wantBodies, gotBodies := bodies(want), bodies(got)
+	for i := range wantBodies {
+		if !bytes.Equal(wantBodies[i], gotBodies[i]) {
+			t.Errorf("%s: body after round trip\n got %s\nwant %s", want[i].Name, gotBodies[i], wantBodies[i])
+		}
+	}
+}
+
+func TestConfigRoundTrip(t *testing.T) {
+	definitions := testDefinitions(t)
+	client := &Client{}
+	client.SetModelDefinitions(definitions)
+
+	got, err := client.Config().ModelDefinitions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareBodies(t, definitions, got)
+}
+
+func TestExportConfigRoundTrip(t *testing.T) {
+	definitions := testDefinitions(t)
+	client := &Client{}
+	client.SetModelDefinitions(definitions)
+
+	for _, name := range []string{"models.yaml", "models.json"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := client.ExportConfig(path); err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := NewClientFromConfig(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			compareBodies(t, definitions, loaded.GetModelDefinitions())
+		})
+	}
+}
+
+func TestSetConvertsDecodedValues(t *testing.T) {
+	settings := NewModelSettings(OpenAI, "gpt-4o")
+	values := map[string]interface{}{
+		"max_tokens": float64(100),
+		"seed":       int64(1),
+		"stop":       []interface{}{"a", "b"},
+		"logit_bias": map[string]interface{}{"1": float64(5)},
+	}
+	for key, value := range values {
+		if err := settings.Set(key, value); err != nil {
+			t.Errorf("Set(%q, %#v): %v", key, value, err)
+		}
+	}
+}
+
+func TestSetRejectsWrongTypes(t *testing.T) {
+	settings := NewModelSettings(Anthropic, "claude-3-haiku-20240307")
+	values := map[string]interface{}{
+		"max_tokens":     1.5,
+		"temperature":    "hot",
+		"stop_sequences": []interface{}{1},
+		"metadata":       3,
+	}
+	for key, value := range values {
+		if err := settings.Set(key, value); err == nil {
+			t.Errorf("Set(%q, %#v) returned no error", key, value)
+		}
+	}
+}
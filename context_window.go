@@ -0,0 +1,150 @@
+package multi_ai_client
+
+import "github.com/guregu/null/v5"
+
+// tokensPerMessage is the amount of tokens every message costs on top of its
+// text, for the role and the delimiters around it.
+const tokensPerMessage = 4
+
+// tokensPerReply is the amount of tokens used to prime the assistant reply.
+const tokensPerReply = 3
+
+// GetTokenizer returns the tokenizer of the model definition.
+// If no tokenizer was set, one is picked based on the API type and model name.
+func (m *ModelDefinition) GetTokenizer() Tokenizer {
+	if m.Tokenizer != nil {
+		return m.Tokenizer
+	}
+	return NewTokenizer(m.APISettings.APIType, modelNameOf(m.ModelSettings))
+}
+
+// CountTokens returns the estimated amount of prompt tokens the given chat
+// takes up for this model.
+func (m *ModelDefinition) CountTokens(chat Chat) int {
+	tokenizer := m.GetTokenizer()
+	total := tokensPerReply
+	for _, message := range chat.requestMessages(true) {
+		total += tokensPerMessage + tokenizer.CountTokens(message.Text)
+	}
+	return total
+}
+
+// GetReservedOutputTokens returns the amount of tokens kept free for the
+// response of the model.
+// If ReservedOutputTokens is not set, the max_tokens setting of the model is
+// used instead.
+func (m *ModelDefinition) GetReservedOutputTokens() int {
+	if m.ReservedOutputTokens > 0 {
+		return m.ReservedOutputTokens
+	}
+	return maxTokensOf(m.ModelSettings)
+}
+
+// FitChat returns a copy of the chat that fits in the context window of the
+// model, leaving room for the reserved output tokens.
+// The oldest messages are dropped first. The system message, the summary of
+// compacted messages, and the last user message (and any assistant message
+// after it) are always kept, and the remaining messages always start with a
+// user message.
+// If ContextWindow is not set, the chat is returned unchanged.
+func (m *ModelDefinition) FitChat(chat Chat) Chat {
+	if m.ContextWindow <= 0 {
+		return chat
+	}
+
+	budget := m.ContextWindow - m.GetReservedOutputTokens()
+	tokenizer := m.GetTokenizer()
+	total := tokensPerReply
+	fixed := chat.requestMessages(true)
+	for _, message := range fixed[:len(fixed)-len(chat.messages)] {
+		total += tokensPerMessage + tokenizer.CountTokens(message.Text)
+	}
+	sizes := make([]int, len(chat.messages))
+	for i, message := range chat.messages {
+		sizes[i] = tokensPerMessage + tokenizer.CountTokens(message.Text)
+		total += sizes[i]
+	}
+	if total <= budget {
+		return chat
+	}
+
+	lastUser := len(chat.messages) - 1
+	for lastUser > 0 && chat.messages[lastUser].Type != UserMessage {
+		lastUser--
+	}
+
+	start := 0
+	for start < lastUser && total > budget {
+		total -= sizes[start]
+		start++
+		for start < lastUser && chat.messages[start].Type != UserMessage {
+			total -= sizes[start]
+			start++
+		}
+	}
+
+	fitted := chat
+	fitted.messages = append(make([]Message, 0, len(chat.messages)-start), chat.messages[start:]...)
+	return fitted
+}
+
+// GetModelName returns the name of the model the API is asked for, as set in
+// the model settings.
+func (m *ModelDefinition) GetModelName() string {
+	return modelNameOf(m.ModelSettings)
+}
+
+// GetMaxTokens returns the max_tokens setting of the model definition, or 0 if
+// it is not set.
+func (m *ModelDefinition) GetMaxTokens() int {
+	return maxTokensOf(m.ModelSettings)
+}
+
+// GetTemperature returns the temperature setting of the model definition, and
+// whether it is set.
+func (m *ModelDefinition) GetTemperature() (float64, bool) {
+	t := temperatureOf(m.ModelSettings)
+	return t.Float64, t.Valid
+}
+
+func modelNameOf(settings ModelSettings) string {
+	switch s := settings.(type) {
+	case *ModelSettingsOpenAI:
+		return s.Model
+	case *ModelSettingsMistral:
+		return s.Model
+	case *ModelSettingsAnthropic:
+		return s.Model
+	default:
+		return ""
+	}
+}
+
+func maxTokensOf(settings ModelSettings) int {
+	switch s := settings.(type) {
+	case *ModelSettingsOpenAI:
+		return int(s.MaxTokens.Int64)
+	case *ModelSettingsMistral:
+		return int(s.MaxTokens.Int64)
+	case *ModelSettingsAnthropic:
+		if s.MaxTokens == 0 {
+			return 4096
+		}
+		return s.MaxTokens
+	default:
+		return 0
+	}
+}
+
+func temperatureOf(settings ModelSettings) null.Float {
+	switch s := settings.(type) {
+	case *ModelSettingsOpenAI:
+		return s.Temperature
+	case *ModelSettingsMistral:
+		return s.Temperature
+	case *ModelSettingsAnthropic:
+		return s.Temperature
+	default:
+		return null.Float{}
+	}
+}
@@ -0,0 +1,149 @@
+package multi_ai_client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/guregu/null/v5"
+)
+
+// wordTokenizer counts every word as one token.
+type wordTokenizer struct{}
+
+func (wordTokenizer) CountTokens(text string) int {
+	return len(strings.Fields(text))
+}
+
+// windowChat returns a chat with a system message of 1 token and messages of
+// 5 tokens each, so every message takes up 9 tokens.
+func windowChat(messages ...string) Chat {
+	chat := Chat{}
+	chat.SetSystemMessage("system")
+	for _, m := range messages {
+		text := m + " w w w w"
+		if strings.HasPrefix(m, "u") {
+			chat.AddUserMessage(text)
+		} else {
+			chat.AddAssistantMessage(text)
+		}
+	}
+	chat.ClearUndo()
+	return chat
+}
+
+// names returns the first word of every message of the chat, without the
+// system message.
+func names(chat Chat) []string {
+	names := make([]string, 0)
+	for _, m := range chat.GetMessagesWithoutSystemMessage() {
+		names = append(names, strings.Fields(m.Text)[0])
+	}
+	return names
+}
+
+func TestFitChat(t *testing.T) {
+	// The chat takes up 3 tokens for the reply, 5 for the system message, and
+	// 9 for every message.
+	conversation := []string{"u1", "a1", "u2", "a2", "u3"}
+	tests := []struct {
+		name     string
+		messages []string
+		window   int
+		reserved int
+		want     []string
+	}{
+		{"no context window", conversation, 0, 0, conversation},
+		{"fits", conversation, 53, 0, conversation},
+		{"one token too many", conversation, 52, 0, []string{"u2", "a2", "u3"}},
+		{"reserved output tokens", conversation, 60, 10, []string{"u2", "a2", "u3"}},
+		{"exchanges dropped together", conversation, 34, 0, []string{"u3"}},
+		{"too small for the last user message", conversation, 1, 0, []string{"u3"}},
+		{"prefill kept", []string{"u1", "a1", "u2", "a2"}, 26, 0, []string{"u2", "a2"}},
+		{"consecutive user messages", []string{"u1", "u2", "a1", "u3", "u4"}, 40, 0, []string{"u3", "u4"}},
+		{"leading assistant message", []string{"a0", "u1", "a1", "u2"}, 30, 0, []string{"u2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chat := windowChat(test.messages...)
+			m := NewModelDefinition("GPT", OpenAI, "", "gpt-4o")
+			m.Tokenizer = wordTokenizer{}
+			m.ContextWindow = test.window
+			m.ReservedOutputTokens = test.reserved
+
+			fitted := m.FitChat(chat)
+			if got := names(fitted); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("messages %q, want %q", got, test.want)
+			}
+			if fitted.GetSystemMessage() != "system" {
+				t.Errorf("system message %q, want it kept", fitted.GetSystemMessage())
+			}
+			if got := names(chat); !reflect.DeepEqual(got, test.messages) {
+				t.Errorf("the chat was changed to %q", got)
+			}
+			if test.window > 0 && len(test.want) > 1 && m.CountTokens(fitted)+m.GetReservedOutputTokens() > test.window {
+				t.Errorf("the fitted chat takes up %d tokens, more than the context window", m.CountTokens(fitted))
+			}
+		})
+	}
+}
+
+func TestFitChatReservesMaxTokens(t *testing.T) {
+	chat := windowChat("u1", "a1", "u2", "a2", "u3")
+
+	// Without ReservedOutputTokens, the max_tokens setting is kept free.
+	m := NewModelDefinition("GPT", OpenAI, "", "gpt-4o")
+	m.Tokenizer = wordTokenizer{}
+	m.ContextWindow = 60
+	m.ModelSettings.(*ModelSettingsOpenAI).MaxTokens = null.IntFrom(10)
+	if got := names(m.FitChat(chat)); !reflect.DeepEqual(got, []string{"u2", "a2", "u3"}) {
+		t.Errorf("with max_tokens: messages %q", got)
+	}
+	m.ReservedOutputTokens = 1
+	if got := names(m.FitChat(chat)); len(got) != 5 {
+		t.Errorf("ReservedOutputTokens does not take precedence: messages %q", got)
+	}
+
+	// Anthropic reserves its default max_tokens.
+	anthropic := NewModelDefinition("Claude", Anthropic, "", "claude-3-5-sonnet-latest")
+	anthropic.Tokenizer = wordTokenizer{}
+	anthropic.ContextWindow = 4096 + 60
+	if reserved := anthropic.GetReservedOutputTokens(); reserved != 4096 {
+		t.Errorf("reserved %d tokens, want 4096", reserved)
+	}
+	if got := names(anthropic.FitChat(chat)); len(got) != 5 {
+		t.Errorf("Anthropic: messages %q, want all", got)
+	}
+}
+
+func TestFitChatKeepsSummary(t *testing.T) {
+	for _, mode := range []CompactionMode{SummaryAsSystemAddendum, SummaryAsExchange} {
+		chat := windowChat("u1", "a1", "u2", "a2", "u3")
+		chat.SetCompaction(&Compaction{Mode: mode})
+		chat.summary = "summary s s s s s s s s s"
+
+		m := NewModelDefinition("GPT", OpenAI, "", "gpt-4o")
+		m.Tokenizer = wordTokenizer{}
+		// The summary is counted with the rest of the chat.
+		m.ContextWindow = m.CountTokens(chat)
+		if m.ContextWindow <= 53+10 {
+			t.Errorf("mode %d: the chat takes up %d tokens, want the summary counted", mode, m.ContextWindow)
+		}
+		if got := names(m.FitChat(chat)); len(got) != 5 {
+			t.Errorf("mode %d: messages %q, want all", mode, got)
+		}
+
+		m.ContextWindow--
+		fitted := m.FitChat(chat)
+		if got := names(fitted); !reflect.DeepEqual(got, []string{"u2", "a2", "u3"}) {
+			t.Errorf("mode %d: messages %q, want the oldest exchange dropped", mode, got)
+		}
+		found := false
+		for _, message := range fitted.requestMessages(true) {
+			found = found || strings.Contains(message.Text, chat.summary)
+		}
+		if !found || fitted.GetSystemMessage() != "system" {
+			t.Errorf("mode %d: %v, want the system message and the summary kept", mode, fitted.requestMessages(true))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package multi_ai_client
+
+import "sync"
+
+// CostTotals is a struct representing the accumulated usage and cost of a set
+// of responses.
+type CostTotals struct {
+	Responses         int
+	InputTokens       int
+	CachedInputTokens int
+	OutputTokens      int
+	// Cost is the total cost in dollars. Responses of models without a known
+	// price do not add to it.
+	Cost float64
+}
+
+func (t *CostTotals) add(usage Usage, cost float64) {
+	t.Responses++
+	t.InputTokens += usage.InputTokens
+	t.CachedInputTokens += usage.CachedInputTokens
+	t.OutputTokens += usage.OutputTokens
+	t.Cost += cost
+}
+
+// CostTracker keeps running totals of usage and cost, overall, per model and
+// per end user. It is safe for concurrent use.
+type CostTracker struct {
+	mu      sync.Mutex
+	total   CostTotals
+	byModel map[string]CostTotals
+	byUser  map[string]CostTotals
+}
+
+// NewCostTracker creates a new, empty CostTracker.
+func NewCostTracker() *CostTracker {
+	return &CostTracker{
+		byModel: make(map[string]CostTotals),
+		byUser:  make(map[string]CostTotals),
+	}
+}
+
+// Record adds the usage and cost of a response to the totals.
+// The user is the end user the response was made for, and may be empty.
+func (t *CostTracker) Record(model string, user string, usage Usage, cost float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total.add(usage, cost)
+	totals := t.byModel[model]
+	totals.add(usage, cost)
+	t.byModel[model] = totals
+	if user != "" {
+		totals = t.byUser[user]
+		totals.add(usage, cost)
+		t.byUser[user] = totals
+	}
+}
+
+// Total returns the totals of all responses.
+func (t *CostTracker) Total() CostTotals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.total
+}
+
+// ByModel returns the totals per model definition name.
+func (t *CostTracker) ByModel() map[string]CostTotals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return copyTotals(t.byModel)
+}
+
+// ByUser returns the totals per end user, as set by the user setting for
+// OpenAI, or the metadata user_id setting for Anthropic.
+func (t *CostTracker) ByUser() map[string]CostTotals {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return copyTotals(t.byUser)
+}
+
+// Reset clears all totals.
+func (t *CostTracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.total = CostTotals{}
+	t.byModel = make(map[string]CostTotals)
+	t.byUser = make(map[string]CostTotals)
+}
+
+func copyTotals(m map[string]CostTotals) map[string]CostTotals {
+	c := make(map[string]CostTotals, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// userOf returns the end user set in the model settings, if any.
+func userOf(settings ModelSettings) string {
+	switch s := settings.(type) {
+	case *ModelSettingsOpenAI:
+		return s.User.String
+	case *ModelSettingsAnthropic:
+		if s.Metadata != nil {
+			return s.Metadata.UserID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,272 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CredentialProvider is an interface representing a source of API keys. It is
+// asked for a key every time a request is created, so keys can change while
+// the client runs.
+type CredentialProvider interface {
+	GetAPIKey() (string, error)
+}
+
+// CredentialReporter is an interface that credential providers implement to
+// learn how the API responded to a key they provided.
+type CredentialReporter interface {
+	// ReportResult is called with the key a request was sent with, and the
+	// HTTP status code of the response.
+	ReportResult(apiKey string, statusCode int)
+}
+
+// StaticCredential is a CredentialProvider that always provides the same key.
+type StaticCredential string
+
+func (c StaticCredential) GetAPIKey() (string, error) {
+	return string(c), nil
+}
+
+// EnvCredential is a CredentialProvider that reads the key from the
+// environment variable with this name.
+type EnvCredential string
+
+func (c EnvCredential) GetAPIKey() (string, error) {
+	key, ok := os.LookupEnv(string(c))
+	if !ok {
+		return "", errors.New("environment variable " + string(c) + " is not set")
+	}
+	return key, nil
+}
+
+// FileCredential is a CredentialProvider that reads the key from the file at
+// this path. Surrounding whitespace is removed.
+type FileCredential string
+
+func (c FileCredential) GetAPIKey() (string, error) {
+	data, err := os.ReadFile(string(c))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// CredentialFunc is a CredentialProvider that calls a function for the key.
+type CredentialFunc func() (string, error)
+
+func (f CredentialFunc) GetAPIKey() (string, error) {
+	return f()
+}
+
+// CommandCredential is a CredentialProvider that runs a command and uses its
+// output as the key, such as a password manager or a cloud secret store.
+type CommandCredential struct {
+	// Command is the program to run, followed by its arguments.
+	Command []string
+	// TTL is how long the output is reused before the command is run again.
+	// 0 means the command runs for every request.
+	TTL time.Duration
+	// Timeout is how long the command may take. If it is 0, 30 seconds is
+	// used.
+	Timeout time.Duration
+
+	mu      sync.Mutex
+	key     string
+	expires time.Time
+}
+
+// NewCommandCredential creates a new CommandCredential running the given
+// command, and reusing its output for ttl.
+func NewCommandCredential(ttl time.Duration, command ...string) *CommandCredential {
+	return &CommandCredential{Command: command, TTL: ttl}
+}
+
+func (c *CommandCredential) GetAPIKey() (string, error) {
+	if len(c.Command) == 0 {
+		return "", errors.New("no command to run for the API key")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.key != "" && time.Now().Before(c.expires) {
+		return c.key, nil
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Command[0], c.Command[1:]...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", errors.New(c.Command[0] + ": " + err.Error() + ": " + message)
+		}
+		return "", errors.New(c.Command[0] + ": " + err.Error())
+	}
+	key := strings.TrimSpace(string(output))
+	if key == "" {
+		return "", errors.New(c.Command[0] + ": no API key in output")
+	}
+	c.key = key
+	c.expires = time.Now().Add(c.TTL)
+	return key, nil
+}
+
+// KeyRotation is an enum representing when a KeyPool moves on to its next key.
+type KeyRotation int
+
+const (
+	// RotateRoundRobin uses the next key for every request.
+	RotateRoundRobin KeyRotation = iota
+	// RotateOnError keeps using a key until it is rejected with status 401,
+	// 403 or 429.
+	RotateOnError
+)
+
+// DefaultQuarantine is how long a KeyPool skips a rejected key by default.
+const DefaultQuarantine = time.Minute
+
+// ErrNoAvailableKey is returned by a KeyPool when all its keys are in
+// quarantine.
+var ErrNoAvailableKey = errors.New("all API keys are quarantined")
+
+// KeyPool is a CredentialProvider that spreads requests over several keys.
+// Keys that are rejected with an authentication or quota error are skipped
+// for a while. When a request is rejected, the client retries it once with
+// every other available key of the pool.
+// It is safe for concurrent use.
+type KeyPool struct {
+	Keys     []CredentialProvider
+	Rotation KeyRotation
+	// Quarantine is how long a rejected key is skipped. If it is 0,
+	// DefaultQuarantine is used.
+	Quarantine time.Duration
+
+	mu          sync.Mutex
+	next        int
+	current     string
+	quarantined map[string]time.Time
+}
+
+// NewKeyPool creates a new KeyPool with the given rotation and keys.
+func NewKeyPool(rotation KeyRotation, keys ...CredentialProvider) *KeyPool {
+	return &KeyPool{Keys: keys, Rotation: rotation}
+}
+
+func (p *KeyPool) GetAPIKey() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.Keys) == 0 {
+		return "", errors.New("no API keys in pool")
+	}
+
+	var lastErr error
+	for i := 0; i < len(p.Keys); i++ {
+		index := (p.next + i) % len(p.Keys)
+		key, err := p.Keys[index].GetAPIKey()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if p.isQuarantined(key) {
+			continue
+		}
+		if p.Rotation == RotateRoundRobin {
+			p.next = index + 1
+		} else {
+			p.next = index
+		}
+		p.current = key
+		return key, nil
+	}
+	if lastErr != nil {
+		return "", lastErr
+	}
+	return "", ErrNoAvailableKey
+}
+
+// Available returns the amount of keys that are not in quarantine.
+func (p *KeyPool) Available() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	available := 0
+	for _, provider := range p.Keys {
+		if key, err := provider.GetAPIKey(); err == nil && !p.isQuarantined(key) {
+			available++
+		}
+	}
+	return available
+}
+
+func (p *KeyPool) ReportResult(apiKey string, statusCode int) {
+	if !isKeyRejection(statusCode) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	quarantine := p.Quarantine
+	if quarantine <= 0 {
+		quarantine = DefaultQuarantine
+	}
+	if p.quarantined == nil {
+		p.quarantined = make(map[string]time.Time)
+	}
+	p.quarantined[apiKey] = time.Now().Add(quarantine)
+	// A request that was sent with an older key may fail after the pool
+	// moved on, which must not skip the key in use now.
+	if p.Rotation == RotateOnError && apiKey == p.current && len(p.Keys) > 0 {
+		p.next = (p.next + 1) % len(p.Keys)
+	}
+}
+
+// isQuarantined returns whether the key is in quarantine, forgetting expired
+// quarantines. The lock must be held.
+func (p *KeyPool) isQuarantined(key string) bool {
+	until, ok := p.quarantined[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(p.quarantined, key)
+		return false
+	}
+	return true
+}
+
+// isKeyRejection returns whether a status code means the API key was rejected
+// or ran out of quota.
+func isKeyRejection(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden || statusCode == http.StatusTooManyRequests
+}
+
+// GetAPIKey returns the API key to send requests with: the key provided by
+// Credentials if it is set, and APIKey otherwise.
+func (s *APISettings) GetAPIKey() (string, error) {
+	if s.Credentials != nil {
+		return s.Credentials.GetAPIKey()
+	}
+	return s.APIKey, nil
+}
+
+// setAPIKey sets the header that carries the API key on a request.
+func setAPIKey(request *http.Request, apiType APIType, key string) {
+	if key == "" {
+		return
+	}
+	if apiType == Anthropic {
+		request.Header.Set("x-api-key", key)
+	} else {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
+}
@@ -0,0 +1,255 @@
+package multi_ai_client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCredentialProviders(t *testing.T) {
+	t.Setenv("MAC_TEST_KEY", "env-key")
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("  file-key\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		provider CredentialProvider
+		key      string
+		fails    bool
+	}{
+		{"static", StaticCredential("static-key"), "static-key", false},
+		{"env", EnvCredential("MAC_TEST_KEY"), "env-key", false},
+		{"unset env", EnvCredential("MAC_TEST_UNSET_KEY"), "", true},
+		{"file", FileCredential(path), "file-key", false},
+		{"missing file", FileCredential(filepath.Join(t.TempDir(), "missing")), "", true},
+		{"func", CredentialFunc(func() (string, error) { return "func-key", nil }), "func-key", false},
+		{"empty pool", NewKeyPool(RotateRoundRobin), "", true},
+	}
+	for _, test := range tests {
+		key, err := test.provider.GetAPIKey()
+		if (err != nil) != test.fails || key != test.key {
+			t.Errorf("%s: key %q and error %v, want %q", test.name, key, err, test.key)
+		}
+	}
+}
+
+func TestCommandCredential(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell to run commands with")
+	}
+	runs := filepath.Join(t.TempDir(), "runs")
+	credential := NewCommandCredential(time.Hour, "sh", "-c", "echo run >> "+runs+"; echo ' command-key '")
+	for i := 0; i < 3; i++ {
+		key, err := credential.GetAPIKey()
+		if err != nil || key != "command-key" {
+			t.Fatalf("key %q and error %v, want command-key", key, err)
+		}
+	}
+	if data, _ := os.ReadFile(runs); strings.Count(string(data), "run") != 1 {
+		t.Errorf("the command ran %d times, want once within its TTL", strings.Count(string(data), "run"))
+	}
+
+	credential.TTL = 0
+	credential.key = ""
+	for i := 0; i < 2; i++ {
+		if _, err := credential.GetAPIKey(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if data, _ := os.ReadFile(runs); strings.Count(string(data), "run") != 3 {
+		t.Errorf("the command ran %d times, want it to run for every key without a TTL", strings.Count(string(data), "run"))
+	}
+
+	failing := NewCommandCredential(0, "sh", "-c", "echo locked >&2; exit 1")
+	if _, err := failing.GetAPIKey(); err == nil || !strings.Contains(err.Error(), "locked") {
+		t.Errorf("error %v, want the output of the failed command", err)
+	}
+	empty := NewCommandCredential(0, "sh", "-c", "true")
+	if _, err := empty.GetAPIKey(); err == nil {
+		t.Error("accepted an empty key")
+	}
+	if _, err := (&CommandCredential{}).GetAPIKey(); err == nil {
+		t.Error("accepted a credential without command")
+	}
+}
+
+// nextKeys returns the next n keys of the pool.
+func nextKeys(t *testing.T, pool *KeyPool, n int) []string {
+	t.Helper()
+	keys := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		key, err := pool.GetAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestKeyPoolRotation(t *testing.T) {
+	roundRobin := NewKeyPool(RotateRoundRobin, StaticCredential("a"), StaticCredential("b"), StaticCredential("c"))
+	if got := nextKeys(t, roundRobin, 4); !reflect.DeepEqual(got, []string{"a", "b", "c", "a"}) {
+		t.Errorf("round robin: %q", got)
+	}
+	roundRobin.ReportResult("b", http.StatusTooManyRequests)
+	if got := nextKeys(t, roundRobin, 3); !reflect.DeepEqual(got, []string{"c", "a", "c"}) {
+		t.Errorf("round robin with b quarantined: %q", got)
+	}
+
+	onError := NewKeyPool(RotateOnError, StaticCredential("a"), StaticCredential("b"), StaticCredential("c"))
+	if got := nextKeys(t, onError, 2); !reflect.DeepEqual(got, []string{"a", "a"}) {
+		t.Errorf("on error: %q", got)
+	}
+	onError.ReportResult("a", http.StatusOK)
+	onError.ReportResult("a", http.StatusInternalServerError)
+	if got := nextKeys(t, onError, 1); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("on error after other failures: %q", got)
+	}
+	onError.ReportResult("a", http.StatusUnauthorized)
+	if got := nextKeys(t, onError, 2); !reflect.DeepEqual(got, []string{"b", "b"}) {
+		t.Errorf("on error after a was rejected: %q", got)
+	}
+
+	// A late failure of a request sent with a, while b is in use, must not
+	// make the pool skip b.
+	onError.ReportResult("a", http.StatusTooManyRequests)
+	if got := nextKeys(t, onError, 1); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Errorf("on error after a stale rejection: %q", got)
+	}
+}
+
+func TestKeyPoolQuarantine(t *testing.T) {
+	pool := NewKeyPool(RotateOnError, StaticCredential("a"), StaticCredential("b"))
+	pool.Quarantine = 50 * time.Millisecond
+	pool.ReportResult("a", http.StatusForbidden)
+	pool.ReportResult("b", http.StatusTooManyRequests)
+	if pool.Available() != 0 {
+		t.Errorf("%d keys available, want none", pool.Available())
+	}
+	if _, err := pool.GetAPIKey(); !errors.Is(err, ErrNoAvailableKey) {
+		t.Errorf("error %v, want ErrNoAvailableKey", err)
+	}
+
+	time.Sleep(2 * pool.Quarantine)
+	if pool.Available() != 2 {
+		t.Errorf("%d keys available after the quarantine, want 2", pool.Available())
+	}
+	if _, err := pool.GetAPIKey(); err != nil {
+		t.Error(err)
+	}
+}
+
+// keyServer is an API that answers each key with the status code it was
+// given, and records the keys of the requests it received.
+type keyServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	status map[string]int
+	keys   []string
+}
+
+func newKeyServer(t *testing.T, status map[string]int) *keyServer {
+	s := &keyServer{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if body, _ := io.ReadAll(r.Body); len(body) == 0 {
+			t.Error("a retried request has no body")
+		}
+		s.mu.Lock()
+		s.keys = append(s.keys, key)
+		status := s.status[key]
+		s.mu.Unlock()
+		if status != http.StatusOK {
+			http.Error(w, `{"error": {"message": "rejected"}}`, status)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: {\"choices\": [{\"delta\": {\"content\": \"Hello.\"}, \"finish_reason\": \"stop\"}]}\n\ndata: [DONE]\n\n")
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// received returns the keys of the requests received since the last call.
+func (s *keyServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := s.keys
+	s.keys = nil
+	return keys
+}
+
+func TestKeyPoolRetries(t *testing.T) {
+	server := newKeyServer(t, map[string]int{
+		"revoked": http.StatusUnauthorized,
+		"limited": http.StatusTooManyRequests,
+		"good":    http.StatusOK,
+	})
+	pool := NewKeyPool(RotateOnError, StaticCredential("revoked"), StaticCredential("limited"), StaticCredential("good"))
+	m := NewModelDefinition("GPT", OpenAI, "", "gpt-4o")
+	m.APISettings.APIEndpoint = server.URL
+	m.APISettings.Credentials = pool
+	client := &Client{}
+
+	if _, last := respondOnce(t, client, m); last.Err != nil {
+		t.Fatal(last.Err)
+	}
+	if got := server.received(); !reflect.DeepEqual(got, []string{"revoked", "limited", "good"}) {
+		t.Errorf("sent with %q, want every key until one is accepted", got)
+	}
+	if pool.Available() != 1 {
+		t.Errorf("%d keys available, want the rejected keys quarantined", pool.Available())
+	}
+
+	// The pool stays with the accepted key.
+	if _, last := respondOnce(t, client, m); last.Err != nil {
+		t.Fatal(last.Err)
+	}
+	if got := server.received(); !reflect.DeepEqual(got, []string{"good"}) {
+		t.Errorf("sent with %q, want the accepted key", got)
+	}
+}
+
+func TestKeyPoolRetriesStop(t *testing.T) {
+	server := newKeyServer(t, map[string]int{
+		"a": http.StatusTooManyRequests,
+		"b": http.StatusTooManyRequests,
+		"c": http.StatusUnauthorized,
+	})
+	m := NewModelDefinition("GPT", OpenAI, "", "gpt-4o")
+	m.APISettings.APIEndpoint = server.URL
+	m.APISettings.Credentials = NewKeyPool(RotateRoundRobin, StaticCredential("a"), StaticCredential("b"), StaticCredential("c"))
+	client := &Client{}
+
+	_, last := respondOnce(t, client, m)
+	var apiError *APIError
+	if !errors.As(last.Err, &apiError) || apiError.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("error %v, want the rejection of the last key", last.Err)
+	}
+	if got := server.received(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("sent with %q, want every key once", got)
+	}
+
+	// All keys are in quarantine, so no request is sent.
+	chat := Chat{}
+	chat.AddUserMessage("Say hello.")
+	if _, _, err := client.CreateResponseForChat(context.Background(), &chat, []ModelDefinition{m}); !errors.Is(err, ErrNoAvailableKey) {
+		t.Errorf("error %v, want ErrNoAvailableKey", err)
+	}
+	if got := server.received(); len(got) != 0 {
+		t.Errorf("sent with %q, want no request", got)
+	}
+}
@@ -0,0 +1,66 @@
+package multi_ai_client
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// APIError is an error representing a response of an API with a status code
+// other than 200 OK.
+type APIError struct {
+	// Model is the name of the model definition the request was made for.
+	Model      string
+	StatusCode int
+	Status     string
+	// Body is the body of the response, which usually describes the error.
+	Body string
+}
+
+func (e *APIError) Error() string {
+	status := e.Status
+	if status == "" {
+		status = strconv.Itoa(e.StatusCode)
+	}
+	if e.Body == "" {
+		return e.Model + ": " + status
+	}
+	return e.Model + ": " + status + ": " + e.Body
+}
+
+// StreamError is an error representing an error event in the stream of a
+// response, as the APIs send when they fail after the response started, for
+// example because they are overloaded.
+type StreamError struct {
+	// Model is the name of the model definition the request was made for.
+	Model string
+	// Type is the type of the error as reported by the API, such as
+	// "overloaded_error".
+	Type    string
+	Message string
+}
+
+func (e *StreamError) Error() string {
+	message := e.Message
+	if e.Type != "" && message != "" {
+		message = e.Type + ": " + message
+	} else if e.Type != "" {
+		message = e.Type
+	}
+	if e.Model == "" {
+		return message
+	}
+	return e.Model + ": " + message
+}
+
+// newAPIError reads the body of a failed response into an APIError.
+func newAPIError(model string, response *http.Response) *APIError {
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	return &APIError{
+		Model:      model,
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Body:       strings.TrimSpace(string(body)),
+	}
+}
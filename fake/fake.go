@@ -0,0 +1,419 @@
+// Package fake provides an in-process provider, to test applications built on
+// multi_ai_client without network access.
+//
+// The provider is an http.RoundTripper that answers requests with scripted
+// responses per model name, can delay chunks and inject errors, and remembers
+// every chat it received:
+//
+//	provider := fake.NewProvider()
+//	provider.Script("small", fake.Text("Paris is the capital of France."))
+//	client.AddModelDefinition(provider.NewModelDefinition("Small model", "small"))
+//
+// It answers the requests of every API type, so it can also stand in for the
+// APIs of existing model definitions:
+//
+//	client.HTTPClient = provider.HTTPClient()
+package fake
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+// Failure is an enum representing the kind of error a scripted response
+// fails with.
+type Failure int
+
+const (
+	// NoFailure makes the response succeed.
+	NoFailure Failure = iota
+	// FailStatus answers the request with Response.Status and
+	// Response.ErrorBody instead of a stream.
+	FailStatus
+	// FailConnection fails the request before any response is received.
+	FailConnection
+	// FailDisconnect breaks off the stream after Response.FailAfter chunks.
+	FailDisconnect
+	// FailMalformedJSON sends an event that is not valid JSON after
+	// Response.FailAfter chunks, and then continues normally.
+	FailMalformedJSON
+)
+
+// Response is a scripted response.
+type Response struct {
+	// Chunks are the text deltas of the response, streamed in order.
+	Chunks []string
+
+	// ChunkDelay is the time waited before every chunk.
+	ChunkDelay time.Duration
+
+	// Usage is reported at the end of the stream. If it is nil, no usage is
+	// reported.
+	Usage *mac.Usage
+
+	// FinishReason is reported at the end of the stream. If it is empty,
+	// "stop" is reported.
+	FinishReason string
+
+	// Failure is the kind of error the response fails with.
+	Failure Failure
+	// FailAfter is the amount of chunks streamed before a FailDisconnect or
+	// FailMalformedJSON failure.
+	FailAfter int
+	// Status is the HTTP status code of a FailStatus failure. If it is 0,
+	// 500 is used.
+	Status int
+	// ErrorBody is the body of a FailStatus failure.
+	ErrorBody string
+}
+
+// Text returns a response streaming the given text, one word per chunk.
+func Text(text string) Response {
+	chunks := make([]string, 0)
+	for _, word := range strings.SplitAfter(text, " ") {
+		if word != "" {
+			chunks = append(chunks, word)
+		}
+	}
+	return Response{Chunks: chunks}
+}
+
+// Status returns a response that fails with the given HTTP status code and
+// error body.
+func Status(code int, body string) Response {
+	return Response{Failure: FailStatus, Status: code, ErrorBody: body}
+}
+
+// Request is a request received by the provider.
+type Request struct {
+	// Model is the model name the request was made for.
+	Model string
+	// Messages are the messages as they were received, including system
+	// messages at any position, such as the instruction that emulates prefill
+	// for OpenAI.
+	Messages []mac.Message
+	// Chat is the chat as it was received. A leading system message is its
+	// system message; system messages at other positions are only in
+	// Messages.
+	Chat *mac.Chat
+	// Body is the raw body of the request.
+	Body []byte
+	// Header is the header of the request.
+	Header http.Header
+}
+
+// Provider is a fake provider answering requests in the stream format of the
+// OpenAI API. It is safe for concurrent use.
+type Provider struct {
+	mu       sync.Mutex
+	scripts  map[string][]Response
+	requests []Request
+}
+
+// NewProvider creates a new Provider without any scripted responses.
+func NewProvider() *Provider {
+	return &Provider{scripts: make(map[string][]Response)}
+}
+
+// Endpoint is the API endpoint of the model definitions created by
+// NewModelDefinition. It can not be reached over the network, so requests
+// that are not sent through the provider fail.
+const Endpoint = "http://fake.invalid/v1/chat/completions"
+
+// NewModelDefinition creates a model definition with the OpenAI API type that
+// is answered by this provider.
+func (p *Provider) NewModelDefinition(name string, model string) mac.ModelDefinition {
+	m := mac.NewModelDefinition(name, mac.OpenAI, "", model)
+	m.APISettings.APIEndpoint = Endpoint
+	m.Transport = p
+	return m
+}
+
+// HTTPClient returns an HTTP client that sends all requests to this provider,
+// to be used as the HTTP client of a multi_ai_client.Client.
+func (p *Provider) HTTPClient() *http.Client {
+	return &http.Client{Transport: p}
+}
+
+// Script adds responses for the given model name. Requests are answered with
+// the scripted responses in order, and the last response is repeated once all
+// others are used. Requests for models without responses fail with status 404.
+func (p *Provider) Script(model string, responses ...Response) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scripts[model] = append(p.scripts[model], responses...)
+}
+
+// Requests returns all requests received for the given model name, or all
+// requests if the model name is empty.
+func (p *Provider) Requests(model string) []Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	requests := make([]Request, 0)
+	for _, r := range p.requests {
+		if model == "" || r.Model == model {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+// LastChat returns the chat of the last request received for the given model
+// name, or nil if there was none.
+func (p *Provider) LastChat(model string) *mac.Chat {
+	requests := p.Requests(model)
+	if len(requests) == 0 {
+		return nil
+	}
+	return requests[len(requests)-1].Chat
+}
+
+// Reset forgets all scripted responses and received requests.
+func (p *Provider) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.scripts = make(map[string][]Response)
+	p.requests = nil
+}
+
+// requestBody is the part of a request body the provider reads. The system
+// message is a message for most APIs, and a field of its own for Anthropic.
+type requestBody struct {
+	Model    string            `json:"model"`
+	System   string            `json:"system"`
+	Messages []mac.JsonMessage `json:"messages"`
+}
+
+func (p *Provider) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var decoded requestBody
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return respondError(req, http.StatusBadRequest, "invalid JSON body"), nil
+	}
+	messages := decoded.Messages
+	if decoded.System != "" {
+		messages = append([]mac.JsonMessage{{Role: "system", Content: decoded.System}}, messages...)
+	}
+	received, err := toMessages(messages)
+	if err != nil {
+		return respondError(req, http.StatusBadRequest, err.Error()), nil
+	}
+
+	p.mu.Lock()
+	p.requests = append(p.requests, Request{
+		Model:    decoded.Model,
+		Messages: received,
+		Chat:     toChat(received),
+		Body:     body,
+		Header:   req.Header.Clone(),
+	})
+	script := p.scripts[decoded.Model]
+	var response Response
+	found := len(script) > 0
+	if found {
+		response = script[0]
+		if len(script) > 1 {
+			p.scripts[decoded.Model] = script[1:]
+		}
+	}
+	p.mu.Unlock()
+
+	if !found {
+		return respondError(req, http.StatusNotFound, "no response scripted for model "+decoded.Model), nil
+	}
+
+	switch response.Failure {
+	case FailConnection:
+		return nil, errors.New("fake: connection failed")
+	case FailStatus:
+		status := response.Status
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		return respond(req, status, strings.NewReader(response.ErrorBody)), nil
+	}
+	return respond(req, http.StatusOK, newStream(req, response)), nil
+}
+
+// respondError answers a request with an error body in the format of the
+// OpenAI API.
+func respondError(req *http.Request, status int, message string) *http.Response {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{"message": message},
+	})
+	return respond(req, status, bytes.NewReader(body))
+}
+
+func respond(req *http.Request, status int, body io.Reader) *http.Response {
+	rc, ok := body.(io.ReadCloser)
+	if !ok {
+		rc = io.NopCloser(body)
+	}
+	header := make(http.Header)
+	if status == http.StatusOK {
+		header.Set("Content-Type", "text/event-stream")
+	} else {
+		header.Set("Content-Type", "application/json")
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body:       rc,
+		Request:    req,
+	}
+}
+
+func toMessages(messages []mac.JsonMessage) ([]mac.Message, error) {
+	converted := make([]mac.Message, 0, len(messages))
+	for _, m := range messages {
+		switch m.Role {
+		case "system":
+			converted = append(converted, *mac.NewSystemMessage(m.Content))
+		case "user":
+			converted = append(converted, *mac.NewUserMessage(m.Content))
+		case "assistant":
+			converted = append(converted, *mac.NewAssistantMessage(m.Content))
+		default:
+			return nil, errors.New("invalid role " + m.Role)
+		}
+	}
+	return converted, nil
+}
+
+// toChat returns the messages as a chat. Like the APIs, it accepts system
+// messages at any position, but only a leading one becomes the system
+// message of the chat.
+func toChat(messages []mac.Message) *mac.Chat {
+	chat := &mac.Chat{}
+	for i, m := range messages {
+		switch m.Type {
+		case mac.SystemMessage:
+			if i == 0 {
+				chat.SetSystemMessage(m.Text)
+			}
+		case mac.UserMessage:
+			chat.AddUserMessage(m.Text)
+		case mac.AssistantMessage:
+			chat.AddAssistantMessage(m.Text)
+		}
+	}
+	chat.ClearUndo()
+	return chat
+}
+
+// stream is a response body that streams a scripted response in the event
+// format of the OpenAI API.
+type stream struct {
+	req      *http.Request
+	response Response
+	next     int
+	events   bytes.Buffer
+	done     bool
+}
+
+func newStream(req *http.Request, response Response) *stream {
+	return &stream{req: req, response: response}
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	for s.events.Len() == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		if err := s.produce(); err != nil {
+			return 0, err
+		}
+	}
+	return s.events.Read(p)
+}
+
+func (s *stream) Close() error {
+	s.done = true
+	return nil
+}
+
+// produce writes the next event of the response.
+func (s *stream) produce() error {
+	r := s.response
+	if s.next < len(r.Chunks) {
+		if s.next == r.FailAfter {
+			switch r.Failure {
+			case FailDisconnect:
+				return io.ErrUnexpectedEOF
+			case FailMalformedJSON:
+				s.events.WriteString("data: {\"choices\":[{\"delta\":{\"content\":\n\n")
+				r.Failure = NoFailure
+				s.response = r
+				return nil
+			}
+		}
+		if r.ChunkDelay > 0 {
+			select {
+			case <-time.After(r.ChunkDelay):
+			case <-s.req.Context().Done():
+				return s.req.Context().Err()
+			}
+		}
+		s.writeEvent(map[string]interface{}{
+			"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{"content": r.Chunks[s.next]}}},
+		})
+		s.next++
+		return nil
+	}
+
+	if r.Failure == FailDisconnect {
+		return io.ErrUnexpectedEOF
+	}
+	if r.Failure == FailMalformedJSON {
+		s.events.WriteString("data: {\"choices\":[{\"delta\":{\"content\":\n\n")
+	}
+	finishReason := r.FinishReason
+	if finishReason == "" {
+		finishReason = "stop"
+	}
+	s.writeEvent(map[string]interface{}{
+		"choices": []interface{}{map[string]interface{}{"index": 0, "delta": map[string]interface{}{}, "finish_reason": finishReason}},
+	})
+	if r.Usage != nil {
+		s.writeEvent(map[string]interface{}{
+			"choices": []interface{}{},
+			"usage": map[string]interface{}{
+				"prompt_tokens":         r.Usage.InputTokens,
+				"completion_tokens":     r.Usage.OutputTokens,
+				"prompt_tokens_details": map[string]interface{}{"cached_tokens": r.Usage.CachedInputTokens},
+			},
+		})
+	}
+	s.events.WriteString("data: [DONE]\n\n")
+	s.done = true
+	return nil
+}
+
+func (s *stream) writeEvent(event interface{}) {
+	data, _ := json.Marshal(event)
+	s.events.WriteString("data: ")
+	s.events.Write(data)
+	s.events.WriteString("\n\n")
+}
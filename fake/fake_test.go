@@ -0,0 +1,235 @@
+package fake
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+// converse sends a chat to the model definitions and returns the last chunk
+// and the text of every response.
+func converse(t *testing.T, client *mac.Client, chat mac.Chat, definitions ...mac.ModelDefinition) ([]mac.MessageChunk, []string) {
+	t.Helper()
+	n, ch, err := client.CreateResponseForChat(context.Background(), &chat, definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := make([]mac.MessageChunk, n)
+	texts := make([]strings.Builder, n)
+	for chunk := range ch {
+		if chunk.Done {
+			last[chunk.Index] = chunk
+		} else {
+			texts[chunk.Index].WriteString(chunk.Delta)
+		}
+	}
+	result := make([]string, n)
+	for i := range texts {
+		result[i] = texts[i].String()
+	}
+	return last, result
+}
+
+func newChat(system string, user string) mac.Chat {
+	chat := mac.Chat{}
+	if system != "" {
+		chat.SetSystemMessage(system)
+	}
+	chat.AddUserMessage(user)
+	return chat
+}
+
+func TestScriptedResponses(t *testing.T) {
+	provider := NewProvider()
+	first := Text("Paris is the capital of France.")
+	first.Usage = &mac.Usage{InputTokens: 12, OutputTokens: 7, CachedInputTokens: 4}
+	first.FinishReason = "length"
+	provider.Script("small", first, Text("Again."))
+	definition := provider.NewModelDefinition("Small model", "small")
+	client := &mac.Client{}
+
+	last, texts := converse(t, client, newChat("Be brief.", "Capital of France?"), definition)
+	if texts[0] != "Paris is the capital of France." {
+		t.Errorf("text %q", texts[0])
+	}
+	chunk := last[0]
+	if chunk.Err != nil {
+		t.Fatal(chunk.Err)
+	}
+	if chunk.Usage == nil || *chunk.Usage != *first.Usage {
+		t.Errorf("usage %+v, want %+v", chunk.Usage, first.Usage)
+	}
+	if chunk.FinishReason != "length" {
+		t.Errorf("finish reason %q, want length", chunk.FinishReason)
+	}
+
+	// The last response is repeated once the others are used.
+	for i := 0; i < 2; i++ {
+		last, texts = converse(t, client, newChat("", "Hi!"), definition)
+		if texts[0] != "Again." || last[0].FinishReason != "stop" || !last[0].Usage.Estimated {
+			t.Errorf("response %d: %q, %+v", i+2, texts[0], last[0])
+		}
+	}
+
+	requests := provider.Requests("small")
+	if len(requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(requests))
+	}
+	if system := requests[0].Chat.GetSystemMessage(); system != "Be brief." {
+		t.Errorf("the first chat has system message %q", system)
+	}
+	if chat := provider.LastChat("small"); chat == nil || chat.GetMessages()[0].Text != "Hi!" {
+		t.Errorf("last chat %v", chat)
+	}
+	if provider.LastChat("large") != nil || len(provider.Requests("")) != 3 {
+		t.Error("requests are not kept by model")
+	}
+
+	provider.Reset()
+	if len(provider.Requests("")) != 0 {
+		t.Error("Reset kept the requests")
+	}
+}
+
+func TestFailures(t *testing.T) {
+	provider := NewProvider()
+	chunks := []string{"one ", "two ", "three"}
+	provider.Script("status", Status(http.StatusTooManyRequests, `{"error": {"message": "slow down"}}`))
+	provider.Script("connection", Response{Chunks: chunks, Failure: FailConnection})
+	provider.Script("disconnect", Response{Chunks: chunks, Failure: FailDisconnect, FailAfter: 2})
+	provider.Script("malformed", Response{Chunks: chunks, Failure: FailMalformedJSON, FailAfter: 1})
+	client := &mac.Client{}
+	definitions := []mac.ModelDefinition{
+		provider.NewModelDefinition("status", "status"),
+		provider.NewModelDefinition("connection", "connection"),
+		provider.NewModelDefinition("disconnect", "disconnect"),
+		provider.NewModelDefinition("malformed", "malformed"),
+		provider.NewModelDefinition("unscripted", "unscripted"),
+	}
+	last, texts := converse(t, client, newChat("", "Hi!"), definitions...)
+
+	var apiError *mac.APIError
+	if !errors.As(last[0].Err, &apiError) || apiError.StatusCode != http.StatusTooManyRequests || !strings.Contains(apiError.Body, "slow down") {
+		t.Errorf("status: error %v", last[0].Err)
+	}
+	if last[1].Err == nil || !strings.Contains(last[1].Err.Error(), "connection failed") {
+		t.Errorf("connection: error %v", last[1].Err)
+	}
+	if !errors.Is(last[2].Err, io.ErrUnexpectedEOF) || texts[2] != "one two " {
+		t.Errorf("disconnect: error %v after %q", last[2].Err, texts[2])
+	}
+	// Malformed events are skipped, and the stream continues.
+	if last[3].Err != nil || texts[3] != "one two three" {
+		t.Errorf("malformed: error %v after %q", last[3].Err, texts[3])
+	}
+	if !errors.As(last[4].Err, &apiError) || apiError.StatusCode != http.StatusNotFound {
+		t.Errorf("unscripted: error %v", last[4].Err)
+	}
+}
+
+func TestErrorBodies(t *testing.T) {
+	provider := NewProvider()
+	bodies := []string{
+		`not JSON`,
+		`{"model": "m", "messages": [{"role": "tool", "content": "x"}]}`,
+		`{"model": "a \"quoted\" model", "messages": []}`,
+	}
+	for _, body := range bodies {
+		req, err := http.NewRequest(http.MethodPost, Endpoint, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := provider.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil || decoded.Error.Message == "" {
+			t.Errorf("%s: error body is not valid: %v", body, err)
+		}
+	}
+}
+
+func TestChunkDelay(t *testing.T) {
+	provider := NewProvider()
+	provider.Script("slow", Response{Chunks: []string{"a", "b", "c"}, ChunkDelay: time.Hour})
+	client := &mac.Client{}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	chat := newChat("", "Hi!")
+	_, ch, err := client.CreateResponseForChat(ctx, &chat, []mac.ModelDefinition{provider.NewModelDefinition("slow", "slow")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last mac.MessageChunk
+	for chunk := range ch {
+		last = chunk
+	}
+	if !errors.Is(last.Err, context.DeadlineExceeded) {
+		t.Errorf("error %v, want the deadline of the context", last.Err)
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	provider := NewProvider()
+	provider.Script("claude-3-5-haiku-20241022", Text("Hello!"))
+	provider.Script("mistral-small-latest", Text("Bonjour !"))
+	client := &mac.Client{HTTPClient: provider.HTTPClient()}
+	definitions := []mac.ModelDefinition{
+		mac.NewModelDefinition("Claude", mac.Anthropic, "key", "claude-3-5-haiku-20241022"),
+		mac.NewModelDefinition("Mistral", mac.Mistral, "key", "mistral-small-latest"),
+	}
+	last, texts := converse(t, client, newChat("Be brief.", "Hi!"), definitions...)
+	for i, want := range []string{"Hello!", "Bonjour !"} {
+		if last[i].Err != nil || texts[i] != want {
+			t.Errorf("%s: %q, %v", definitions[i].Name, texts[i], last[i].Err)
+		}
+	}
+	// Anthropic sends the system message as a field of its own.
+	chat := provider.LastChat("claude-3-5-haiku-20241022")
+	if system := chat.GetSystemMessage(); system != "Be brief." {
+		t.Errorf("the Anthropic request has system message %q", system)
+	}
+}
+
+func TestPrefilledChat(t *testing.T) {
+	provider := NewProvider()
+	provider.Script("small", Text("The capital is Paris."))
+	definition := provider.NewModelDefinition("Small model", "small")
+	client := &mac.Client{}
+
+	// The default prefill strategy of OpenAI sends the prefill as a system
+	// message after the other messages.
+	chat := newChat("", "Capital of France?")
+	chat.AddAssistantMessage("The capital is")
+	last, texts := converse(t, client, chat, definition)
+	if last[0].Err != nil {
+		t.Fatal(last[0].Err)
+	}
+	if texts[0] != " Paris." {
+		t.Errorf("text %q, want the response without the repeated prefill", texts[0])
+	}
+
+	requests := provider.Requests("small")
+	if len(requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(requests))
+	}
+	messages := requests[0].Messages
+	if len(messages) != 2 || messages[0].Type != mac.UserMessage || messages[1].Type != mac.SystemMessage || !strings.Contains(messages[1].Text, "The capital is") {
+		t.Errorf("messages %+v, want the user message and the prefill instruction", messages)
+	}
+	if chat := requests[0].Chat; chat.GetSystemMessage() != "" || len(chat.GetMessages()) != 1 {
+		t.Errorf("chat %v, want only the user message", chat)
+	}
+}
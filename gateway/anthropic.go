@@ -0,0 +1,274 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+// anthropicContent is the content of a message or the system prompt of a
+// messages request, which is either a string or a list of blocks.
+type anthropicContent string
+
+func (c *anthropicContent) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*c = anthropicContent(text)
+		return nil
+	}
+	var blocks []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return errors.New("content must be a string or a list of content blocks")
+	}
+	texts := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if block.Type != "text" {
+			return errors.New("content blocks of type " + block.Type + " are not supported")
+		}
+		texts = append(texts, block.Text)
+	}
+	*c = anthropicContent(strings.Join(texts, ""))
+	return nil
+}
+
+type messagesRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string           `json:"role"`
+		Content anthropicContent `json:"content"`
+	} `json:"messages"`
+	System        anthropicContent `json:"system"`
+	MaxTokens     *int             `json:"max_tokens"`
+	Stream        bool             `json:"stream"`
+	Temperature   *float64         `json:"temperature"`
+	TopP          *float64         `json:"top_p"`
+	TopK          *int             `json:"top_k"`
+	StopSequences []string         `json:"stop_sequences"`
+	Metadata      *struct {
+		UserID string `json:"user_id"`
+	} `json:"metadata"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicBlock struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type anthropicMessage struct {
+	ID           string           `json:"id"`
+	Type         string           `json:"type"`
+	Role         string           `json:"role"`
+	Model        string           `json:"model"`
+	Content      []anthropicBlock `json:"content"`
+	StopReason   *string          `json:"stop_reason"`
+	StopSequence *string          `json:"stop_sequence"`
+	Usage        anthropicUsage   `json:"usage"`
+}
+
+// anthropicErrors writes an error in the format of the Anthropic API.
+func anthropicErrors(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(anthropicError(status, message))
+}
+
+// anthropicError returns the body of an error in the format of the Anthropic
+// API.
+func anthropicError(status int, message string) interface{} {
+	errorType := "api_error"
+	switch {
+	case status == http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case status == http.StatusForbidden:
+		errorType = "permission_error"
+	case status == http.StatusNotFound:
+		errorType = "not_found_error"
+	case status == http.StatusRequestEntityTooLarge:
+		errorType = "request_too_large"
+	case status == http.StatusTooManyRequests:
+		errorType = "rate_limit_error"
+	case status < 500:
+		errorType = "invalid_request_error"
+	}
+	return map[string]interface{}{
+		"type": "error",
+		"error": map[string]interface{}{
+			"type":    errorType,
+			"message": message,
+		},
+	}
+}
+
+// anthropicStopReason translates the finish reason of any API to the stop
+// reason the Anthropic API would report.
+func anthropicStopReason(reason string) string {
+	switch reason {
+	case "", "stop":
+		return "end_turn"
+	case "length", "model_length":
+		return "max_tokens"
+	case "tool_calls":
+		return "tool_use"
+	default:
+		return reason
+	}
+}
+
+func (s *Server) handleMessages(w http.ResponseWriter, r *http.Request) {
+	var request messagesRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		anthropicErrors(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if request.MaxTokens == nil {
+		anthropicErrors(w, http.StatusBadRequest, "max_tokens: Field required")
+		return
+	}
+	messages := make([]message, 0, len(request.Messages))
+	for i, m := range request.Messages {
+		var messageType mac.MessageType
+		switch m.Role {
+		case "user":
+			messageType = mac.UserMessage
+		case "assistant":
+			messageType = mac.AssistantMessage
+		default:
+			anthropicErrors(w, http.StatusBadRequest, fmt.Sprintf("messages.%d.role: unexpected role %q", i, m.Role))
+			return
+		}
+		messages = append(messages, message{Type: messageType, Text: string(m.Content)})
+	}
+	var system []string
+	if request.System != "" {
+		system = append(system, string(request.System))
+	}
+	chat, err := newChat(system, messages)
+	if err != nil {
+		anthropicErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	definition, ok := s.model(r.Context(), request.Model)
+	if !ok {
+		anthropicErrors(w, http.StatusNotFound, "model: "+request.Model)
+		return
+	}
+	o := options{
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		TopK:        request.TopK,
+		MaxTokens:   request.MaxTokens,
+		Stop:        request.StopSequences,
+	}
+	if request.Metadata != nil {
+		o.User = request.Metadata.UserID
+	}
+	if err := o.apply(&definition); err != nil {
+		anthropicErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The input tokens are only known when the response is complete, so the
+	// message start reports an estimate.
+	estimate := definition.CountTokens(definition.FitChat(*chat))
+	ch, err := s.respond(r.Context(), chat, definition)
+	if err != nil {
+		anthropicErrors(w, statusOf(err), s.errorMessage(r.Context(), err))
+		return
+	}
+	reply := anthropicMessage{
+		ID:      newID("msg_"),
+		Type:    "message",
+		Role:    "assistant",
+		Model:   request.Model,
+		Content: []anthropicBlock{},
+		Usage:   anthropicUsage{InputTokens: estimate},
+	}
+	if request.Stream {
+		s.streamMessage(r.Context(), w, ch, reply)
+		return
+	}
+
+	var text strings.Builder
+	var last mac.MessageChunk
+	for chunk := range ch {
+		text.WriteString(chunk.Delta)
+		last = chunk
+	}
+	if last.Err != nil {
+		anthropicErrors(w, statusOf(last.Err), s.errorMessage(r.Context(), last.Err))
+		return
+	}
+	stopReason := anthropicStopReason(last.FinishReason)
+	reply.Content = append(reply.Content, anthropicBlock{Type: "text", Text: text.String()})
+	reply.StopReason = &stopReason
+	if last.Usage != nil {
+		reply.Usage = anthropicUsage{InputTokens: last.Usage.InputTokens, OutputTokens: last.Usage.OutputTokens}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(reply)
+}
+
+// streamMessage writes the response as the events of the Anthropic API. As
+// with the chat completions API, the status code is only sent once the
+// response has started, so early failures are answered with an error status.
+func (s *Server) streamMessage(ctx context.Context, w http.ResponseWriter, ch <-chan mac.MessageChunk, reply anthropicMessage) {
+	sse := newEventWriter(w)
+	event := func(name string, data map[string]interface{}) {
+		data["type"] = name
+		sse.event(name, data)
+	}
+
+	started := false
+	for chunk := range ch {
+		if !started && (chunk.Delta != "" || (chunk.Done && chunk.Err == nil)) {
+			started = true
+			event("message_start", map[string]interface{}{"message": reply})
+			event("content_block_start", map[string]interface{}{
+				"index":         0,
+				"content_block": anthropicBlock{Type: "text"},
+			})
+			event("ping", map[string]interface{}{})
+		}
+		if chunk.Delta != "" {
+			event("content_block_delta", map[string]interface{}{
+				"index": 0,
+				"delta": map[string]interface{}{"type": "text_delta", "text": chunk.Delta},
+			})
+		}
+		if !chunk.Done {
+			continue
+		}
+		if chunk.Err != nil {
+			if !started {
+				anthropicErrors(w, statusOf(chunk.Err), s.errorMessage(ctx, chunk.Err))
+				return
+			}
+			sse.event("error", anthropicError(statusOf(chunk.Err), s.errorMessage(ctx, chunk.Err)))
+			continue
+		}
+		usage := reply.Usage
+		if chunk.Usage != nil {
+			usage = anthropicUsage{InputTokens: chunk.Usage.InputTokens, OutputTokens: chunk.Usage.OutputTokens}
+		}
+		event("content_block_stop", map[string]interface{}{"index": 0})
+		event("message_delta", map[string]interface{}{
+			"delta": map[string]interface{}{"stop_reason": anthropicStopReason(chunk.FinishReason), "stop_sequence": nil},
+			"usage": usage,
+		})
+		event("message_stop", map[string]interface{}{})
+	}
+}
@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const messagesBody = `{"model": "small", "max_tokens": 16, "system": "Be brief.",
+	"messages": [{"role": "user", "content": [{"type": "text", "text": "Hi!"}]}]`
+
+// testMessage is the part of a message the tests check.
+type testMessage struct {
+	Type       string           `json:"type"`
+	Role       string           `json:"role"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason *string          `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+}
+
+func TestMessages(t *testing.T) {
+	server, provider := newTestServer(t)
+	response := serve(server, http.MethodPost, "/v1/messages", messagesBody+"}", nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, readAll(t, response))
+	}
+	var message testMessage
+	decode(t, readAll(t, response), &message)
+	if message.Type != "message" || message.Role != "assistant" || message.Model != "small" {
+		t.Errorf("message %+v", message)
+	}
+	if len(message.Content) != 1 || message.Content[0] != (anthropicBlock{Type: "text", Text: "Hello there."}) {
+		t.Errorf("content %+v", message.Content)
+	}
+	if message.StopReason == nil || *message.StopReason != "end_turn" {
+		t.Errorf("stop reason %v, want end_turn", message.StopReason)
+	}
+	if message.Usage != (anthropicUsage{InputTokens: 5, OutputTokens: 2}) {
+		t.Errorf("usage %+v", message.Usage)
+	}
+	if chat := provider.LastChat("small-model"); chat == nil || !strings.Contains(chat.String(), "Be brief.") {
+		t.Errorf("the provider received %v", chat)
+	}
+}
+
+func TestMessagesStream(t *testing.T) {
+	server, _ := newTestServer(t)
+	events := readEvents(t, serve(server, http.MethodPost, "/v1/messages", messagesBody+`, "stream": true}`, nil))
+
+	names := make([]string, 0, len(events))
+	var text strings.Builder
+	for _, event := range events {
+		var data struct {
+			Type  string `json:"type"`
+			Delta struct {
+				Type       string `json:"type"`
+				Text       string `json:"text"`
+				StopReason string `json:"stop_reason"`
+			} `json:"delta"`
+			Usage   *anthropicUsage `json:"usage"`
+			Message *testMessage    `json:"message"`
+		}
+		decode(t, event.Data, &data)
+		if data.Type != event.Name {
+			t.Errorf("event %s has type %s", event.Name, data.Type)
+		}
+		if len(names) == 0 || names[len(names)-1] != event.Name {
+			names = append(names, event.Name)
+		}
+		switch event.Name {
+		case "message_start":
+			if data.Message == nil || data.Message.Role != "assistant" || data.Message.Usage.InputTokens == 0 {
+				t.Errorf("message_start %s", event.Data)
+			}
+		case "content_block_delta":
+			if data.Delta.Type != "text_delta" {
+				t.Errorf("delta type %q", data.Delta.Type)
+			}
+			text.WriteString(data.Delta.Text)
+		case "message_delta":
+			if data.Delta.StopReason != "end_turn" {
+				t.Errorf("stop reason %q, want end_turn", data.Delta.StopReason)
+			}
+			if data.Usage == nil || *data.Usage != (anthropicUsage{InputTokens: 5, OutputTokens: 2}) {
+				t.Errorf("usage %+v", data.Usage)
+			}
+		}
+	}
+	want := "message_start content_block_start ping content_block_delta content_block_stop message_delta message_stop"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("events %s, want %s", got, want)
+	}
+	if text.String() != "Hello there." {
+		t.Errorf("text %q", text.String())
+	}
+}
+
+func TestMessagesErrors(t *testing.T) {
+	server, _ := newTestServer(t)
+	tests := []struct {
+		name      string
+		body      string
+		status    int
+		errorType string
+	}{
+		{"no max_tokens", `{"model": "small", "messages": [{"role": "user", "content": "Hi!"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"unknown model", `{"model": "large", "max_tokens": 16, "messages": [{"role": "user", "content": "Hi!"}]}`, http.StatusNotFound, "not_found_error"},
+		{"system role", `{"model": "small", "max_tokens": 16, "messages": [{"role": "system", "content": "Hi!"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"image", `{"model": "small", "max_tokens": 16, "messages": [{"role": "user", "content": [{"type": "image"}]}]}`, http.StatusBadRequest, "invalid_request_error"},
+	}
+	for _, test := range tests {
+		response := serve(server, http.MethodPost, "/v1/messages", test.body, nil)
+		var body struct {
+			Type  string `json:"type"`
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		decode(t, readAll(t, response), &body)
+		if response.StatusCode != test.status || body.Type != "error" || body.Error.Type != test.errorType {
+			t.Errorf("%s: status %d, error %+v, want %d and %s", test.name, response.StatusCode, body, test.status, test.errorType)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+// Package gateway serves the model definitions of a client over the HTTP APIs
+// of the providers, so tools that only speak one protocol can reach every
+// configured model, whatever its provider.
+//
+// The OpenAI chat completions API is served at /v1/chat/completions, with the
+// models listed at /v1/models, and the Anthropic messages API at /v1/messages.
+// Both stream their responses in the events of their own protocol, whatever
+// the provider of the model.
+//
+// Browsers can compare models over a WebSocket at /v1/ws, where the responses
+// of several models to the same messages arrive interleaved, as JSON frames
+// tagged with the model they belong to. The answer the user picks can be sent
+// back, and is recorded in the preference store of the client:
+//
+//	client, _ := multi_ai_client.NewClientFromConfig("models.yaml")
+//	server, err := gateway.NewServer(client, gateway.Token{Name: "ci", Token: "secret"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	http.ListenAndServe("127.0.0.1:8080", server)
+//
+// The model of a request is the name of a model definition. Settings of the
+// request, such as the temperature, override those of the model definition
+// for that request only.
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	mac "github.com/villadelfia/multi-ai-client"
+	"gopkg.in/yaml.v3"
+)
+
+// maxBodySize is the largest request body accepted.
+const maxBodySize = 16 << 20
+
+// Token is a bearer token a client of the server authenticates with.
+type Token struct {
+	// Name identifies the client in logs and to middleware and observers of
+	// the multi_ai_client.Client, through ClientName.
+	Name  string `json:"name" yaml:"name"`
+	Token string `json:"token" yaml:"token"`
+	// Models are the names of the model definitions the client may use. If
+	// it is empty, all model definitions may be used.
+	Models []string `json:"models,omitempty" yaml:"models,omitempty"`
+}
+
+// allows returns whether the token may use the model definition with the
+// given name.
+func (t *Token) allows(model string) bool {
+	return len(t.Models) == 0 || slices.Contains(t.Models, model)
+}
+
+var envReference = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// LoadTokens reads a list of tokens from a YAML or JSON file. Tokens written
+// as ${NAME} are read from that environment variable.
+func LoadTokens(path string) ([]Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	if err := yaml.Unmarshal(data, &tokens); err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	names := make(map[string]bool)
+	for i := range tokens {
+		t := &tokens[i]
+		if match := envReference.FindStringSubmatch(t.Token); match != nil {
+			value, ok := os.LookupEnv(match[1])
+			if !ok {
+				return nil, errors.New(path + ": token " + t.Name + ": environment variable " + match[1] + " is not set")
+			}
+			t.Token = value
+		}
+		switch {
+		case t.Name == "":
+			return nil, errors.New(path + ": token without a name")
+		case names[t.Name]:
+			return nil, errors.New(path + ": duplicate token name " + t.Name)
+		case t.Token == "":
+			return nil, errors.New(path + ": token " + t.Name + " is empty")
+		}
+		names[t.Name] = true
+	}
+	return tokens, nil
+}
+
+// Server is an http.Handler serving the model definitions of a client.
+// It is safe for concurrent use. Every request is answered with a chat of its
+// own, so the chat of the client is not used.
+type Server struct {
+	Client *mac.Client
+	// Tokens are the tokens that are accepted, as "Authorization: Bearer"
+	// header. If there are none, every request is rejected, unless
+	// AllowUnauthenticated is set.
+	Tokens []Token
+	// AllowUnauthenticated makes a server without tokens accept every
+	// request. It has no effect if there are tokens.
+	AllowUnauthenticated bool
+	// Logger receives a log line for every request. If it is nil, nothing is
+	// logged.
+	Logger *slog.Logger
+
+	// AllowedOrigins are the web pages, such as "https://example.com", that
+	// may send requests and open WebSocket connections. If there are none,
+	// only pages served from the host of the server may.
+	AllowedOrigins []string
+	// MaxConcurrentRequests is the amount of requests a WebSocket connection
+	// may have in progress at once. If it is 0,
+	// DefaultMaxConcurrentRequests is used.
+	MaxConcurrentRequests int
+
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+// ErrNoTokens is returned by NewServer when it is given no tokens.
+var ErrNoTokens = errors.New("no tokens given")
+
+// NewServer creates a new Server for the model definitions of the client,
+// accepting the given tokens. It returns ErrNoTokens if there are none; a
+// server that accepts every request must be created with
+// AllowUnauthenticated set.
+func NewServer(client *mac.Client, tokens ...Token) (*Server, error) {
+	if len(tokens) == 0 {
+		return nil, ErrNoTokens
+	}
+	return &Server{Client: client, Tokens: tokens}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(s.routes)
+	s.mux.ServeHTTP(w, r)
+}
+
+// routes registers the endpoints of the server.
+func (s *Server) routes() {
+	s.mux = http.NewServeMux()
+	s.mux.Handle("GET /v1/models", s.authenticated(openAIErrors, s.handleModels))
+	s.mux.Handle("POST /v1/chat/completions", s.authenticated(openAIErrors, s.handleChatCompletions))
+	s.mux.Handle("POST /v1/messages", s.authenticated(anthropicErrors, s.handleMessages))
+	s.mux.Handle("GET /v1/ws", s.authenticated(openAIErrors, s.handleWebSocket))
+}
+
+type contextKey int
+
+const tokenKey contextKey = 0
+
+// ClientName returns the name of the token the request being served was
+// authenticated with, or an empty string if the server accepts unauthenticated
+// requests.
+// Middleware and observers of the client receive it through the context of
+// the exchange.
+func ClientName(ctx context.Context) string {
+	if t, ok := ctx.Value(tokenKey).(*Token); ok {
+		return t.Name
+	}
+	return ""
+}
+
+// tokenOf returns the token the request was authenticated with.
+func tokenOf(ctx context.Context) *Token {
+	if t, ok := ctx.Value(tokenKey).(*Token); ok {
+		return t
+	}
+	return &Token{}
+}
+
+// errorWriter writes an error response in the format of an API.
+type errorWriter func(w http.ResponseWriter, status int, message string)
+
+// authenticated wraps a handler so it is only called for requests with a
+// valid token from an allowed origin, and logs the requests. Request bodies
+// must be JSON.
+func (s *Server) authenticated(writeError errorWriter, next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		token, ok := s.authenticate(r)
+		_, originErr := s.origin(r)
+		switch {
+		case originErr != nil:
+			writeError(recorder, http.StatusForbidden, originErr.Error())
+		case !ok:
+			writeError(recorder, http.StatusUnauthorized, "invalid API key")
+		case r.Method == http.MethodPost && !isJSON(r):
+			writeError(recorder, http.StatusUnsupportedMediaType, "the Content-Type must be application/json")
+		default:
+			r.Body = http.MaxBytesReader(recorder, r.Body, maxBodySize)
+			next(recorder, r.WithContext(context.WithValue(r.Context(), tokenKey, token)))
+		}
+		if s.Logger != nil {
+			attrs := []any{
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", recorder.status),
+				slog.Duration("duration", time.Since(start)),
+			}
+			if token != nil && token.Name != "" {
+				attrs = append(attrs, slog.String("client", token.Name))
+			}
+			s.Logger.InfoContext(r.Context(), "gateway request", attrs...)
+		}
+	})
+}
+
+// origin returns the origin of the request, or nil if it has none. It returns
+// an error if the origin is not allowed, so other web sites can not use the
+// server through the browsers of its users. Requests without an origin do not
+// come from a browser and are accepted.
+func (s *Server) origin(r *http.Request) (*url.URL, error) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, errors.New("invalid origin: " + origin)
+	}
+	if slices.Contains(s.AllowedOrigins, origin) || (len(s.AllowedOrigins) == 0 && strings.EqualFold(u.Host, r.Host)) {
+		return u, nil
+	}
+	return nil, errors.New("origin not allowed: " + origin)
+}
+
+// isJSON returns whether the body of the request is declared as JSON.
+func isJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// authenticate returns the token of the request. The API key may be sent as
+// bearer token or, as the Anthropic API expects, in the X-Api-Key header.
+// Browsers can not set headers on WebSocket connections, so those may send it
+// as the token query parameter. A server without tokens rejects every
+// request, unless AllowUnauthenticated is set.
+func (s *Server) authenticate(r *http.Request) (*Token, bool) {
+	if len(s.Tokens) == 0 {
+		if s.AllowUnauthenticated {
+			return &Token{}, true
+		}
+		return nil, false
+	}
+	key := r.Header.Get("X-Api-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		key = strings.TrimSpace(bearer)
+	}
+	if key == "" && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		key = r.URL.Query().Get("token")
+	}
+	if key == "" {
+		return nil, false
+	}
+	var found *Token
+	for i := range s.Tokens {
+		// Every token is compared, so the time taken does not tell which
+		// token matched.
+		if subtle.ConstantTimeCompare([]byte(s.Tokens[i].Token), []byte(key)) == 1 && found == nil {
+			found = &s.Tokens[i]
+		}
+	}
+	return found, found != nil
+}
+
+// statusRecorder remembers the status code of a response for the logs.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection can not be hijacked")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return h.Hijack()
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// models returns the model definitions the client of the request may use.
+func (s *Server) models(ctx context.Context) []mac.ModelDefinition {
+	token := tokenOf(ctx)
+	models := make([]mac.ModelDefinition, 0)
+	for _, definition := range s.Client.GetModelDefinitions() {
+		if token.allows(definition.Name) {
+			models = append(models, definition)
+		}
+	}
+	return models
+}
+
+// model returns a copy of the model definition with the given name, which
+// the settings of the request can be applied to.
+func (s *Server) model(ctx context.Context, name string) (mac.ModelDefinition, bool) {
+	if !tokenOf(ctx).allows(name) {
+		return mac.ModelDefinition{}, false
+	}
+	definition, ok := s.Client.GetModelDefinition(name)
+	if !ok {
+		return mac.ModelDefinition{}, false
+	}
+	return definition.Clone(), true
+}
+
+// message is a message of a request, in any of the API formats.
+type message struct {
+	Type mac.MessageType
+	Text string
+}
+
+// newChat creates the chat of a request. All system messages are joined into
+// the system message of the chat, wherever they appear.
+func newChat(system []string, messages []message) (*mac.Chat, error) {
+	chat := &mac.Chat{}
+	for _, m := range messages {
+		switch m.Type {
+		case mac.SystemMessage:
+			system = append(system, m.Text)
+		case mac.UserMessage:
+			chat.AddUserMessage(m.Text)
+		case mac.AssistantMessage:
+			chat.AddAssistantMessage(m.Text)
+		}
+	}
+	if len(chat.GetMessagesWithoutSystemMessage()) == 0 {
+		return nil, errors.New("messages must contain at least one user or assistant message")
+	}
+	chat.SetSystemMessage(strings.Join(system, "\n\n"))
+	chat.ClearUndo()
+	return chat, nil
+}
+
+// options are the settings of a request that override those of the model
+// definition. They are translated to the setting each API type uses, and
+// ignored by API types that do not support them.
+type options struct {
+	Temperature *float64
+	TopP        *float64
+	TopK        *int
+	MaxTokens   *int
+	Stop        []string
+	Seed        *int
+	User        string
+}
+
+// apply sets the options on the settings of the model definition.
+func (o options) apply(definition *mac.ModelDefinition) error {
+	apiType := definition.APISettings.APIType
+	settings := map[string]interface{}{}
+	if o.Temperature != nil {
+		settings["temperature"] = *o.Temperature
+	}
+	if o.TopP != nil {
+		settings["top_p"] = *o.TopP
+	}
+	if o.TopK != nil && apiType == mac.Anthropic {
+		settings["top_k"] = *o.TopK
+	}
+	if o.MaxTokens != nil {
+		if *o.MaxTokens <= 0 {
+			return errors.New("max_tokens must be positive")
+		}
+		settings["max_tokens"] = *o.MaxTokens
+	}
+	if len(o.Stop) > 0 {
+		switch apiType {
+		case mac.OpenAI:
+			settings["stop"] = o.Stop
+		case mac.Anthropic:
+			settings["stop_sequences"] = o.Stop
+		}
+	}
+	if o.Seed != nil {
+		switch apiType {
+		case mac.OpenAI:
+			settings["seed"] = *o.Seed
+		case mac.Mistral:
+			settings["random_seed"] = *o.Seed
+		}
+	}
+	if o.User != "" {
+		switch apiType {
+		case mac.OpenAI:
+			settings["user"] = o.User
+		case mac.Anthropic:
+			settings["metadata"] = o.User
+		}
+	}
+	for key, value := range settings {
+		if err := definition.ModelSettings.Set(key, value); err != nil {
+			return errors.New(key + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// respond creates the response of a single model definition to a chat.
+func (s *Server) respond(ctx context.Context, chat *mac.Chat, definition mac.ModelDefinition) (<-chan mac.MessageChunk, error) {
+	_, ch, err := s.Client.CreateResponseForChat(ctx, chat, []mac.ModelDefinition{definition})
+	return ch, err
+}
+
+// statusOf returns the HTTP status code to answer a failed response with.
+func statusOf(err error) int {
+	var apiError *mac.APIError
+	var budgetError *mac.BudgetExceededError
+	var validationError *mac.ValidationError
+	switch {
+	case errors.As(err, &apiError):
+		// A rejected key is the key of the gateway, not of the client.
+		if apiError.StatusCode == http.StatusUnauthorized || apiError.StatusCode == http.StatusForbidden || apiError.StatusCode >= 500 {
+			return http.StatusBadGateway
+		}
+		return apiError.StatusCode
+	case errors.As(err, &budgetError):
+		return http.StatusTooManyRequests
+	case errors.As(err, &validationError):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// errorMessage returns the message to answer a failed response with. The
+// errors of the upstream APIs may describe the account of the gateway, so
+// they are logged, and the client only receives a generic message.
+func (s *Server) errorMessage(ctx context.Context, err error) string {
+	var apiError *mac.APIError
+	var streamError *mac.StreamError
+	switch {
+	case errors.As(err, &apiError):
+		s.logUpstreamError(ctx, apiError.Model, err, slog.Int("upstream_status", apiError.StatusCode))
+		return apiError.Model + ": the upstream API failed: " + http.StatusText(statusOf(err))
+	case errors.As(err, &streamError):
+		s.logUpstreamError(ctx, streamError.Model, err)
+		if streamError.Type != "" {
+			return streamError.Model + ": the upstream API failed: " + streamError.Type
+		}
+		return streamError.Model + ": the upstream API failed"
+	default:
+		return err.Error()
+	}
+}
+
+// logUpstreamError logs an error of an upstream API.
+func (s *Server) logUpstreamError(ctx context.Context, model string, err error, attrs ...any) {
+	if s.Logger == nil {
+		return
+	}
+	attrs = append(attrs, slog.String("model", model), slog.String("error", err.Error()))
+	s.Logger.WarnContext(ctx, "upstream request failed", attrs...)
+}
+
+// newID returns a random identifier with the given prefix.
+func newID(prefix string) string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return prefix + hex.EncodeToString(b)
+}
@@ -0,0 +1,257 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	mac "github.com/villadelfia/multi-ai-client"
+	"github.com/villadelfia/multi-ai-client/fake"
+)
+
+const testToken = "secret"
+
+// newTestServer returns a server for a client with the model definition
+// "small", which answers "Hello there." through a fake provider.
+func newTestServer(t *testing.T) (*Server, *fake.Provider) {
+	t.Helper()
+	provider := fake.NewProvider()
+	response := fake.Text("Hello there.")
+	response.Usage = &mac.Usage{InputTokens: 5, OutputTokens: 2}
+	provider.Script("small-model", response)
+	client := &mac.Client{}
+	client.AddModelDefinition(provider.NewModelDefinition("small", "small-model"))
+	server, err := NewServer(client, Token{Name: "ci", Token: testToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server, provider
+}
+
+// serve sends a request to the server and returns the response.
+func serve(server http.Handler, method string, path string, body string, header map[string]string) *http.Response {
+	r := httptest.NewRequest(method, "http://gateway.test"+path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		if v == "" {
+			r.Header.Del(k)
+		} else {
+			r.Header.Set(k, v)
+		}
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, r)
+	return w.Result()
+}
+
+// readAll returns the body of a response.
+func readAll(t *testing.T, response *http.Response) string {
+	t.Helper()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+const chatBody = `{"model": "small", "messages": [{"role": "user", "content": "Hi!"}]}`
+
+func TestOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		status  int
+	}{
+		{"no origin", nil, "", http.StatusOK},
+		{"same host", nil, "https://gateway.test", http.StatusOK},
+		{"other host", nil, "https://evil.test", http.StatusForbidden},
+		{"allowed", []string{"https://app.test"}, "https://app.test", http.StatusOK},
+		{"not allowed", []string{"https://app.test"}, "https://gateway.test", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server, _ := newTestServer(t)
+			server.AllowedOrigins = test.allowed
+			for _, path := range []string{"/v1/chat/completions", "/v1/messages"} {
+				body := chatBody
+				if path == "/v1/messages" {
+					body = `{"model": "small", "max_tokens": 16, "messages": [{"role": "user", "content": "Hi!"}]}`
+				}
+				response := serve(server, http.MethodPost, path, body, map[string]string{"Origin": test.origin})
+				if response.StatusCode != test.status {
+					t.Errorf("%s: status %d, want %d: %s", path, response.StatusCode, test.status, readAll(t, response))
+				}
+			}
+		})
+	}
+}
+
+func TestContentType(t *testing.T) {
+	tests := map[string]int{
+		"application/json":                  http.StatusOK,
+		"application/json; charset=utf-8":   http.StatusOK,
+		"text/plain":                        http.StatusUnsupportedMediaType,
+		"application/x-www-form-urlencoded": http.StatusUnsupportedMediaType,
+	}
+	for contentType, status := range tests {
+		server, _ := newTestServer(t)
+		response := serve(server, http.MethodPost, "/v1/chat/completions", chatBody, map[string]string{"Content-Type": contentType})
+		if response.StatusCode != status {
+			t.Errorf("%s: status %d, want %d", contentType, response.StatusCode, status)
+		}
+	}
+
+	server, _ := newTestServer(t)
+	response := serve(server, http.MethodPost, "/v1/chat/completions", chatBody, map[string]string{"Content-Type": ""})
+	if response.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("no Content-Type: status %d, want %d", response.StatusCode, http.StatusUnsupportedMediaType)
+	}
+}
+
+// sseEvent is a server-sent event of a streamed response.
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+// readEvents returns the server-sent events of a streamed response.
+func readEvents(t *testing.T, response *http.Response) []sseEvent {
+	t.Helper()
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("Content-Type %q, want text/event-stream", contentType)
+	}
+	events := make([]sseEvent, 0)
+	var event sseEvent
+	for _, line := range strings.Split(readAll(t, response), "\n") {
+		switch {
+		case line == "":
+			if event.Data != "" {
+				events = append(events, event)
+			}
+			event = sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			event.Name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			event.Data = strings.TrimPrefix(line, "data: ")
+		default:
+			t.Fatalf("unexpected line %q", line)
+		}
+	}
+	return events
+}
+
+// decode decodes JSON data into v.
+func decode(t *testing.T, data string, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(data), v); err != nil {
+		t.Fatalf("%v: %s", err, data)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	server, provider := newTestServer(t)
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"bearer", nil, http.StatusOK},
+		{"x-api-key", map[string]string{"Authorization": "", "X-Api-Key": testToken}, http.StatusOK},
+		{"missing", map[string]string{"Authorization": ""}, http.StatusUnauthorized},
+		{"wrong", map[string]string{"Authorization": "Bearer wrong"}, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		response := serve(server, http.MethodGet, "/v1/models", "", test.header)
+		if response.StatusCode != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.StatusCode, test.status)
+		}
+	}
+
+	response := serve(server, http.MethodPost, "/v1/chat/completions", chatBody, map[string]string{"Authorization": ""})
+	var openAIBody struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	decode(t, readAll(t, response), &openAIBody)
+	if openAIBody.Error.Type != "authentication_error" {
+		t.Errorf("chat completions: error type %q, want authentication_error", openAIBody.Error.Type)
+	}
+
+	response = serve(server, http.MethodPost, "/v1/messages", chatBody, map[string]string{"Authorization": ""})
+	var anthropicBody struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	decode(t, readAll(t, response), &anthropicBody)
+	if anthropicBody.Type != "error" || anthropicBody.Error.Type != "authentication_error" {
+		t.Errorf("messages: error %+v, want an authentication_error", anthropicBody)
+	}
+	if requests := provider.Requests(""); len(requests) != 0 {
+		t.Errorf("unauthenticated requests reached the provider: %d", len(requests))
+	}
+}
+
+func TestWithoutTokens(t *testing.T) {
+	server, _ := newTestServer(t)
+	if _, err := NewServer(server.Client); !errors.Is(err, ErrNoTokens) {
+		t.Errorf("NewServer without tokens: error %v, want ErrNoTokens", err)
+	}
+
+	// A server without tokens fails closed, whatever the request sends.
+	closed := &Server{Client: server.Client}
+	for _, header := range []map[string]string{nil, {"Authorization": ""}} {
+		if response := serve(closed, http.MethodGet, "/v1/models", "", header); response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("without tokens: status %d, want %d", response.StatusCode, http.StatusUnauthorized)
+		}
+	}
+
+	open := &Server{Client: server.Client, AllowUnauthenticated: true}
+	response := serve(open, http.MethodGet, "/v1/models", "", map[string]string{"Authorization": ""})
+	if response.StatusCode != http.StatusOK {
+		t.Errorf("AllowUnauthenticated: status %d, want %d", response.StatusCode, http.StatusOK)
+	}
+}
+
+func TestUpstreamErrorsHidden(t *testing.T) {
+	const secret = "organization org-1234 exceeded its quota"
+	server, provider := newTestServer(t)
+	provider.Script("failing-model", fake.Status(http.StatusPaymentRequired, `{"error": {"message": "`+secret+`"}}`))
+	server.Client.AddModelDefinition(provider.NewModelDefinition("failing", "failing-model"))
+	var logs strings.Builder
+	server.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	requests := []struct {
+		path string
+		body string
+	}{
+		{"/v1/chat/completions", `{"model": "failing", "messages": [{"role": "user", "content": "Hi!"}]}`},
+		{"/v1/chat/completions", `{"model": "failing", "stream": true, "messages": [{"role": "user", "content": "Hi!"}]}`},
+		{"/v1/messages", `{"model": "failing", "max_tokens": 16, "messages": [{"role": "user", "content": "Hi!"}]}`},
+		{"/v1/messages", `{"model": "failing", "max_tokens": 16, "stream": true, "messages": [{"role": "user", "content": "Hi!"}]}`},
+	}
+	for _, request := range requests {
+		response := serve(server, http.MethodPost, request.path, request.body, nil)
+		body := readAll(t, response)
+		if response.StatusCode != http.StatusPaymentRequired {
+			t.Errorf("%s: status %d, want %d", request.body, response.StatusCode, http.StatusPaymentRequired)
+		}
+		if strings.Contains(body, secret) || !strings.Contains(body, "the upstream API failed") {
+			t.Errorf("%s: the response does not hide the upstream error: %s", request.body, body)
+		}
+	}
+	if !strings.Contains(logs.String(), "org-1234") {
+		t.Errorf("the upstream error was not logged:\n%s", logs.String())
+	}
+}
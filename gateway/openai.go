@@ -0,0 +1,326 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+// openAIMessage is a message of a chat completion request. The content is
+// either a string or a list of parts.
+type openAIMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// openAIStop is the stop setting, which is either a string or a list of
+// strings.
+type openAIStop []string
+
+func (s *openAIStop) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*s = openAIStop{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("stop must be a string or a list of strings")
+	}
+	*s = many
+	return nil
+}
+
+type chatCompletionRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	Stream        bool            `json:"stream"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Temperature         *float64   `json:"temperature"`
+	TopP                *float64   `json:"top_p"`
+	MaxTokens           *int       `json:"max_tokens"`
+	MaxCompletionTokens *int       `json:"max_completion_tokens"`
+	Stop                openAIStop `json:"stop"`
+	Seed                *int       `json:"seed"`
+	User                string     `json:"user"`
+	N                   *int       `json:"n"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+func newOpenAIUsage(usage *mac.Usage) *openAIUsage {
+	if usage == nil {
+		return nil
+	}
+	return &openAIUsage{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      usage.InputTokens + usage.OutputTokens,
+	}
+}
+
+type openAIChoice struct {
+	Index        int               `json:"index"`
+	Message      *openAIReplyDelta `json:"message,omitempty"`
+	Delta        *openAIReplyDelta `json:"delta,omitempty"`
+	FinishReason *string           `json:"finish_reason"`
+	Logprobs     *struct{}         `json:"logprobs"`
+}
+
+type openAIReplyDelta struct {
+	Role    string  `json:"role,omitempty"`
+	Content *string `json:"content,omitempty"`
+}
+
+type chatCompletion struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []openAIChoice `json:"choices"`
+	Usage   *openAIUsage   `json:"usage,omitempty"`
+}
+
+// openAIErrors writes an error in the format of the OpenAI API.
+func openAIErrors(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(openAIError(status, message))
+}
+
+// openAIError returns the body of an error in the format of the OpenAI API.
+func openAIError(status int, message string) interface{} {
+	errorType := "api_error"
+	switch {
+	case status == http.StatusUnauthorized:
+		errorType = "authentication_error"
+	case status == http.StatusNotFound:
+		errorType = "not_found_error"
+	case status == http.StatusTooManyRequests:
+		errorType = "rate_limit_error"
+	case status < 500:
+		errorType = "invalid_request_error"
+	}
+	return map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errorType,
+			"param":   nil,
+			"code":    nil,
+		},
+	}
+}
+
+// openAIFinishReason translates the finish reason of any API to the one the
+// OpenAI API would report.
+func openAIFinishReason(reason string) string {
+	switch reason {
+	case "", "end_turn", "stop_sequence":
+		return "stop"
+	case "max_tokens", "model_length":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return reason
+	}
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, r *http.Request) {
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	}
+	data := make([]model, 0)
+	for _, definition := range s.models(r.Context()) {
+		data = append(data, model{
+			ID:      definition.Name,
+			Object:  "model",
+			OwnedBy: definition.APISettings.APIType.String(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"object": "list", "data": data})
+}
+
+// openAIText returns the text of the content of a message.
+func openAIText(content json.RawMessage) (string, error) {
+	if len(content) == 0 || string(content) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return "", errors.New("content must be a string or a list of parts")
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", errors.New("content parts of type " + part.Type + " are not supported")
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, ""), nil
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var request chatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		openAIErrors(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if request.N != nil && *request.N != 1 {
+		openAIErrors(w, http.StatusBadRequest, "n must be 1")
+		return
+	}
+	messages := make([]message, 0, len(request.Messages))
+	for i, m := range request.Messages {
+		var messageType mac.MessageType
+		switch m.Role {
+		case "system", "developer":
+			messageType = mac.SystemMessage
+		case "user":
+			messageType = mac.UserMessage
+		case "assistant":
+			messageType = mac.AssistantMessage
+		default:
+			openAIErrors(w, http.StatusBadRequest, fmt.Sprintf("messages[%d]: role %q is not supported", i, m.Role))
+			return
+		}
+		text, err := openAIText(m.Content)
+		if err != nil {
+			openAIErrors(w, http.StatusBadRequest, fmt.Sprintf("messages[%d]: %s", i, err))
+			return
+		}
+		messages = append(messages, message{Type: messageType, Text: text})
+	}
+	chat, err := newChat(nil, messages)
+	if err != nil {
+		openAIErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	definition, ok := s.model(r.Context(), request.Model)
+	if !ok {
+		openAIErrors(w, http.StatusNotFound, fmt.Sprintf("the model %q does not exist", request.Model))
+		return
+	}
+	maxTokens := request.MaxCompletionTokens
+	if maxTokens == nil {
+		maxTokens = request.MaxTokens
+	}
+	err = options{
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		MaxTokens:   maxTokens,
+		Stop:        request.Stop,
+		Seed:        request.Seed,
+		User:        request.User,
+	}.apply(&definition)
+	if err != nil {
+		openAIErrors(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ch, err := s.respond(r.Context(), chat, definition)
+	if err != nil {
+		openAIErrors(w, statusOf(err), s.errorMessage(r.Context(), err))
+		return
+	}
+	completion := chatCompletion{
+		ID:      newID("chatcmpl-"),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   request.Model,
+	}
+	if request.Stream {
+		s.streamChatCompletion(r.Context(), w, ch, completion, request.StreamOptions != nil && request.StreamOptions.IncludeUsage)
+		return
+	}
+
+	var text strings.Builder
+	var last mac.MessageChunk
+	for chunk := range ch {
+		text.WriteString(chunk.Delta)
+		last = chunk
+	}
+	if last.Err != nil {
+		openAIErrors(w, statusOf(last.Err), s.errorMessage(r.Context(), last.Err))
+		return
+	}
+	content := text.String()
+	finishReason := openAIFinishReason(last.FinishReason)
+	completion.Choices = []openAIChoice{{
+		Message:      &openAIReplyDelta{Role: "assistant", Content: &content},
+		FinishReason: &finishReason,
+	}}
+	completion.Usage = newOpenAIUsage(last.Usage)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(completion)
+}
+
+// streamChatCompletion writes the response as chat completion chunks. The
+// status code is only sent with the first delta, so a request that fails
+// before any text arrives is still answered with an error status.
+func (s *Server) streamChatCompletion(ctx context.Context, w http.ResponseWriter, ch <-chan mac.MessageChunk, completion chatCompletion, includeUsage bool) {
+	completion.Object = "chat.completion.chunk"
+	sse := newEventWriter(w)
+	write := func(choice *openAIChoice, usage *openAIUsage) {
+		completion.Choices = []openAIChoice{}
+		if choice != nil {
+			completion.Choices = append(completion.Choices, *choice)
+		}
+		completion.Usage = usage
+		sse.data(completion)
+	}
+
+	started := false
+	for chunk := range ch {
+		if !started && (chunk.Delta != "" || (chunk.Done && chunk.Err == nil)) {
+			started = true
+			empty := ""
+			write(&openAIChoice{Delta: &openAIReplyDelta{Role: "assistant", Content: &empty}}, nil)
+		}
+		if chunk.Delta != "" {
+			delta := chunk.Delta
+			write(&openAIChoice{Delta: &openAIReplyDelta{Content: &delta}}, nil)
+		}
+		if !chunk.Done {
+			continue
+		}
+		if chunk.Err != nil {
+			if !started {
+				openAIErrors(w, statusOf(chunk.Err), s.errorMessage(ctx, chunk.Err))
+				return
+			}
+			sse.data(openAIError(statusOf(chunk.Err), s.errorMessage(ctx, chunk.Err)))
+			continue
+		}
+		finishReason := openAIFinishReason(chunk.FinishReason)
+		write(&openAIChoice{Delta: &openAIReplyDelta{}, FinishReason: &finishReason}, nil)
+		if includeUsage {
+			write(nil, newOpenAIUsage(chunk.Usage))
+		}
+		sse.raw("[DONE]")
+	}
+}
@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/villadelfia/multi-ai-client/fake"
+)
+
+func TestModels(t *testing.T) {
+	server, _ := newTestServer(t)
+	response := serve(server, http.MethodGet, "/v1/models", "", nil)
+	var body struct {
+		Object string `json:"object"`
+		Data   []struct {
+			ID     string `json:"id"`
+			Object string `json:"object"`
+		} `json:"data"`
+	}
+	decode(t, readAll(t, response), &body)
+	if body.Object != "list" || len(body.Data) != 1 || body.Data[0].ID != "small" || body.Data[0].Object != "model" {
+		t.Errorf("models %+v, want a list of small", body)
+	}
+
+	server.Tokens = []Token{{Token: testToken, Models: []string{"large"}}}
+	response = serve(server, http.MethodGet, "/v1/models", "", nil)
+	decode(t, readAll(t, response), &body)
+	if len(body.Data) != 0 {
+		t.Errorf("models %+v, want none the token may use", body.Data)
+	}
+}
+
+// testCompletion is the part of a chat completion the tests check.
+type testCompletion struct {
+	Object  string `json:"object"`
+	Model   string `json:"model"`
+	Choices []struct {
+		Message *struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"message"`
+		Delta *struct {
+			Role    string  `json:"role"`
+			Content *string `json:"content"`
+		} `json:"delta"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *openAIUsage `json:"usage"`
+}
+
+func TestChatCompletions(t *testing.T) {
+	server, provider := newTestServer(t)
+	body := `{"model": "small", "max_tokens": 16, "messages": [
+		{"role": "system", "content": "Be brief."},
+		{"role": "user", "content": [{"type": "text", "text": "Hi!"}]}]}`
+	response := serve(server, http.MethodPost, "/v1/chat/completions", body, nil)
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("status %d: %s", response.StatusCode, readAll(t, response))
+	}
+	var completion testCompletion
+	decode(t, readAll(t, response), &completion)
+	if completion.Object != "chat.completion" || completion.Model != "small" || len(completion.Choices) != 1 {
+		t.Fatalf("completion %+v", completion)
+	}
+	choice := completion.Choices[0]
+	if choice.Message == nil || choice.Message.Role != "assistant" || choice.Message.Content != "Hello there." {
+		t.Errorf("message %+v, want the answer of the assistant", choice.Message)
+	}
+	if choice.FinishReason == nil || *choice.FinishReason != "stop" {
+		t.Errorf("finish reason %v, want stop", choice.FinishReason)
+	}
+	if completion.Usage == nil || *completion.Usage != (openAIUsage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7}) {
+		t.Errorf("usage %+v", completion.Usage)
+	}
+
+	chat := provider.LastChat("small-model")
+	if chat == nil || chat.String() == "" || !strings.Contains(chat.String(), "Be brief.") {
+		t.Errorf("the provider received %v", chat)
+	}
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	server, _ := newTestServer(t)
+	body := `{"model": "small", "stream": true, "stream_options": {"include_usage": true},
+		"messages": [{"role": "user", "content": "Hi!"}]}`
+	events := readEvents(t, serve(server, http.MethodPost, "/v1/chat/completions", body, nil))
+	if len(events) < 4 || events[len(events)-1].Data != "[DONE]" {
+		t.Fatalf("events %+v, want chunks ending with [DONE]", events)
+	}
+
+	var text strings.Builder
+	finishReason := ""
+	var usage *openAIUsage
+	for i, event := range events[:len(events)-1] {
+		var chunk testCompletion
+		decode(t, event.Data, &chunk)
+		if chunk.Object != "chat.completion.chunk" {
+			t.Errorf("event %d: object %q", i, chunk.Object)
+		}
+		if chunk.Usage != nil {
+			if len(chunk.Choices) != 0 {
+				t.Errorf("event %d: the usage chunk has choices", i)
+			}
+			usage = chunk.Usage
+			continue
+		}
+		if len(chunk.Choices) != 1 || chunk.Choices[0].Delta == nil {
+			t.Fatalf("event %d: %s", i, event.Data)
+		}
+		if i == 0 && chunk.Choices[0].Delta.Role != "assistant" {
+			t.Errorf("the first chunk has role %q", chunk.Choices[0].Delta.Role)
+		}
+		if content := chunk.Choices[0].Delta.Content; content != nil {
+			text.WriteString(*content)
+		}
+		if chunk.Choices[0].FinishReason != nil {
+			finishReason = *chunk.Choices[0].FinishReason
+		}
+	}
+	if text.String() != "Hello there." {
+		t.Errorf("text %q", text.String())
+	}
+	if finishReason != "stop" {
+		t.Errorf("finish reason %q, want stop", finishReason)
+	}
+	if usage == nil || usage.TotalTokens != 7 {
+		t.Errorf("usage %+v", usage)
+	}
+}
+
+func TestChatCompletionsErrors(t *testing.T) {
+	server, provider := newTestServer(t)
+	provider.Script("limited-model", fake.Status(http.StatusTooManyRequests, `{"error": {"message": "slow down"}}`))
+	server.Client.AddModelDefinition(provider.NewModelDefinition("limited", "limited-model"))
+
+	tests := []struct {
+		name      string
+		body      string
+		status    int
+		errorType string
+	}{
+		{"unknown model", `{"model": "large", "messages": [{"role": "user", "content": "Hi!"}]}`, http.StatusNotFound, "not_found_error"},
+		{"invalid body", `{"model": `, http.StatusBadRequest, "invalid_request_error"},
+		{"unknown role", `{"model": "small", "messages": [{"role": "tool", "content": "Hi!"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"n", `{"model": "small", "n": 2, "messages": [{"role": "user", "content": "Hi!"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"rate limited", `{"model": "limited", "messages": [{"role": "user", "content": "Hi!"}]}`, http.StatusTooManyRequests, "rate_limit_error"},
+		{"rate limited stream", `{"model": "limited", "stream": true, "messages": [{"role": "user", "content": "Hi!"}]}`, http.StatusTooManyRequests, "rate_limit_error"},
+	}
+	for _, test := range tests {
+		response := serve(server, http.MethodPost, "/v1/chat/completions", test.body, nil)
+		var body struct {
+			Error struct {
+				Type    string `json:"type"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		decode(t, readAll(t, response), &body)
+		if response.StatusCode != test.status || body.Error.Type != test.errorType || body.Error.Message == "" {
+			t.Errorf("%s: status %d, error %+v, want %d and %s", test.name, response.StatusCode, body.Error, test.status, test.errorType)
+		}
+	}
+}
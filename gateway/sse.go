@@ -0,0 +1,58 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// eventWriter writes server-sent events, flushing every event so it reaches
+// the client at once. The headers are sent with the first event.
+type eventWriter struct {
+	w       http.ResponseWriter
+	started bool
+}
+
+func newEventWriter(w http.ResponseWriter) *eventWriter {
+	return &eventWriter{w: w}
+}
+
+// start sends the headers of the stream, if they were not sent yet.
+func (e *eventWriter) start() {
+	if e.started {
+		return
+	}
+	e.started = true
+	e.w.Header().Set("Content-Type", "text/event-stream")
+	e.w.Header().Set("Cache-Control", "no-cache")
+	e.w.Header().Set("Connection", "keep-alive")
+	e.w.WriteHeader(http.StatusOK)
+}
+
+// data writes an event with v encoded as JSON as data.
+func (e *eventWriter) data(v interface{}) {
+	e.event("", v)
+}
+
+// event writes an event with the given name and v encoded as JSON as data.
+// Events without a name have no event field.
+func (e *eventWriter) event(name string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	e.start()
+	if name != "" {
+		_, _ = io.WriteString(e.w, "event: "+name+"\n")
+	}
+	e.raw(string(data))
+}
+
+// raw writes an event with the given data.
+func (e *eventWriter) raw(data string) {
+	e.start()
+	_, _ = io.WriteString(e.w, "data: "+data+"\n\n")
+	if f, ok := e.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,293 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	mac "github.com/villadelfia/multi-ai-client"
+	"golang.org/x/net/websocket"
+)
+
+// DefaultMaxConcurrentRequests is the amount of requests a WebSocket
+// connection may have in progress at once by default.
+const DefaultMaxConcurrentRequests = 4
+
+// wsRequest is a frame sent by the browser over the WebSocket.
+//
+// A chat frame asks all listed models to answer the messages:
+//
+//	{"type": "chat", "id": "1", "models": ["GPT", "Claude"],
+//	 "messages": [{"role": "user", "content": "Hi!"}],
+//	 "options": {"temperature": 0.7}}
+//
+// A cancel frame stops the responses of an earlier chat frame:
+//
+//	{"type": "cancel", "id": "1"}
+//
+// A preference frame records which of the answers to the messages the user
+// picked, if the client has a preference store:
+//
+//	{"type": "preference", "id": "1", "models": ["GPT", "Claude"],
+//	 "messages": [{"role": "user", "content": "Hi!"}],
+//	 "candidates": ["Hello!", "Hi there!"], "winner": 1}
+type wsRequest struct {
+	Type     string   `json:"type"`
+	ID       string   `json:"id"`
+	Models   []string `json:"models"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	Options struct {
+		Temperature *float64 `json:"temperature"`
+		TopP        *float64 `json:"top_p"`
+		MaxTokens   *int     `json:"max_tokens"`
+	} `json:"options"`
+	Candidates []string `json:"candidates"`
+	Winner     int      `json:"winner"`
+}
+
+// chat returns the messages of the request as a chat.
+func (r wsRequest) chat() (*mac.Chat, error) {
+	messages := make([]message, 0, len(r.Messages))
+	for _, m := range r.Messages {
+		messageType, err := mac.ParseMessageType(m.Role)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message{Type: messageType, Text: m.Content})
+	}
+	return newChat(nil, messages)
+}
+
+// wsFrame is a frame sent to the browser over the WebSocket. The responses of
+// all models of a request arrive interleaved, as delta frames followed by a
+// done frame per model, and a finished frame once all models are done. A
+// request that can not be answered at all gets an error frame instead.
+type wsFrame struct {
+	// Type is delta, done, finished or error.
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Model string `json:"model,omitempty"`
+	// Index is the index of the model in the models of the request.
+	Index *int   `json:"index,omitempty"`
+	Delta string `json:"delta,omitempty"`
+	// Status is the outcome of a done frame: ok, error or cancelled.
+	Status       string   `json:"status,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	FinishReason string   `json:"finish_reason,omitempty"`
+	Usage        *wsUsage `json:"usage,omitempty"`
+	Cost         *float64 `json:"cost,omitempty"`
+	Cached       bool     `json:"cached,omitempty"`
+}
+
+type wsUsage struct {
+	InputTokens  int  `json:"input_tokens"`
+	OutputTokens int  `json:"output_tokens"`
+	Estimated    bool `json:"estimated,omitempty"`
+}
+
+// wsConnection is the state of a WebSocket connection.
+type wsConnection struct {
+	server *Server
+	conn   *websocket.Conn
+	ctx    context.Context
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	requests map[string]context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// send writes a frame to the browser. Frames of concurrent requests are
+// written one at a time.
+func (c *wsConnection) send(frame wsFrame) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = websocket.JSON.Send(c.conn, frame)
+}
+
+// checkOrigin only accepts WebSocket connections from the allowed origins.
+func (s *Server) checkOrigin(config *websocket.Config, r *http.Request) error {
+	u, err := s.origin(r)
+	if err != nil {
+		return err
+	}
+	config.Origin = u
+	return nil
+}
+
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	websocket.Server{
+		Handshake: s.checkOrigin,
+		Handler: func(conn *websocket.Conn) {
+			conn.MaxPayloadBytes = maxBodySize
+			ctx, cancel := context.WithCancel(ctx)
+			c := &wsConnection{server: s, conn: conn, ctx: ctx, requests: make(map[string]context.CancelFunc)}
+			c.serve()
+			// Closing the socket stops all responses of the connection.
+			cancel()
+			c.wg.Wait()
+		},
+	}.ServeHTTP(w, r)
+}
+
+// serve reads frames until the socket is closed.
+func (c *wsConnection) serve() {
+	limit := c.server.MaxConcurrentRequests
+	if limit <= 0 {
+		limit = DefaultMaxConcurrentRequests
+	}
+	for {
+		var request wsRequest
+		if err := websocket.JSON.Receive(c.conn, &request); err != nil {
+			var syntaxError *json.SyntaxError
+			var typeError *json.UnmarshalTypeError
+			if errors.As(err, &syntaxError) || errors.As(err, &typeError) {
+				c.send(wsFrame{Type: "error", Error: "invalid frame: " + err.Error()})
+				continue
+			}
+			return
+		}
+
+		switch request.Type {
+		case "cancel":
+			c.mu.Lock()
+			if cancel, ok := c.requests[request.ID]; ok {
+				cancel()
+			}
+			c.mu.Unlock()
+		case "chat":
+			c.mu.Lock()
+			_, duplicate := c.requests[request.ID]
+			busy := len(c.requests) >= limit
+			var ctx context.Context
+			if !duplicate && !busy {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(c.ctx)
+				c.requests[request.ID] = cancel
+				c.wg.Add(1)
+			}
+			c.mu.Unlock()
+			switch {
+			case duplicate:
+				c.send(wsFrame{Type: "error", ID: request.ID, Error: "a request with this id is in progress"})
+			case busy:
+				c.send(wsFrame{Type: "error", ID: request.ID, Error: "too many concurrent requests"})
+			default:
+				go c.chat(ctx, request)
+			}
+		case "preference":
+			if err := c.recordPreference(request); err != nil {
+				c.send(wsFrame{Type: "error", ID: request.ID, Error: "could not record the preference: " + err.Error()})
+			}
+		default:
+			c.send(wsFrame{Type: "error", ID: request.ID, Error: "unknown frame type " + request.Type})
+		}
+	}
+}
+
+// chat answers a chat frame with all requested models.
+func (c *wsConnection) chat(ctx context.Context, request wsRequest) {
+	defer c.wg.Done()
+	defer func() {
+		c.mu.Lock()
+		if cancel, ok := c.requests[request.ID]; ok {
+			cancel()
+			delete(c.requests, request.ID)
+		}
+		c.mu.Unlock()
+	}()
+	fail := func(message string) {
+		c.send(wsFrame{Type: "error", ID: request.ID, Error: message})
+	}
+
+	chat, err := request.chat()
+	if err != nil {
+		fail(err.Error())
+		return
+	}
+	if len(request.Models) == 0 {
+		fail("no models requested")
+		return
+	}
+	definitions := make([]mac.ModelDefinition, 0, len(request.Models))
+	for _, name := range request.Models {
+		definition, ok := c.server.model(ctx, name)
+		if !ok {
+			fail("unknown model " + name)
+			return
+		}
+		err := options{
+			Temperature: request.Options.Temperature,
+			TopP:        request.Options.TopP,
+			MaxTokens:   request.Options.MaxTokens,
+		}.apply(&definition)
+		if err != nil {
+			fail(name + ": " + err.Error())
+			return
+		}
+		definitions = append(definitions, definition)
+	}
+
+	_, ch, err := c.server.Client.CreateResponseForChat(ctx, chat, definitions)
+	if err != nil {
+		fail(c.server.errorMessage(ctx, err))
+		return
+	}
+	for chunk := range ch {
+		index := chunk.Index
+		frame := wsFrame{ID: request.ID, Model: definitions[index].Name, Index: &index}
+		if !chunk.Done {
+			frame.Type = "delta"
+			frame.Delta = chunk.Delta
+			c.send(frame)
+			continue
+		}
+		frame.Type = "done"
+		switch {
+		case chunk.Err == nil:
+			frame.Status = "ok"
+			frame.FinishReason = chunk.FinishReason
+			frame.Cost = &chunk.Cost
+			frame.Cached = chunk.Cached
+			if chunk.Usage != nil {
+				frame.Usage = &wsUsage{InputTokens: chunk.Usage.InputTokens, OutputTokens: chunk.Usage.OutputTokens, Estimated: chunk.Usage.Estimated}
+			}
+		case errors.Is(chunk.Err, context.Canceled):
+			frame.Status = "cancelled"
+		default:
+			frame.Status = "error"
+			frame.Error = c.server.errorMessage(ctx, chunk.Err)
+		}
+		c.send(frame)
+	}
+	if c.ctx.Err() != nil {
+		return
+	}
+	c.send(wsFrame{Type: "finished", ID: request.ID})
+}
+
+// recordPreference records the answer the user picked in a preference frame.
+func (c *wsConnection) recordPreference(request wsRequest) error {
+	chat, err := request.chat()
+	if err != nil {
+		return err
+	}
+	for _, name := range request.Models {
+		if _, ok := c.server.model(c.ctx, name); !ok {
+			return errors.New("unknown model " + name)
+		}
+	}
+	preference, err := mac.NewPreference(*chat, request.Models, request.Candidates, request.Winner)
+	if err != nil {
+		return err
+	}
+	preference.User = ClientName(c.ctx)
+	return c.server.Client.RecordPreference(preference)
+}
@@ -0,0 +1,159 @@
+package gateway
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/villadelfia/multi-ai-client/fake"
+	"golang.org/x/net/websocket"
+)
+
+// dial opens a WebSocket connection to the server with the given origin.
+func dial(t *testing.T, server *Server, origin string) (*websocket.Conn, error) {
+	t.Helper()
+	httpServer := httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+	url := "ws" + strings.TrimPrefix(httpServer.URL, "http") + "/v1/ws?token=" + testToken
+	if origin == "" {
+		origin = httpServer.URL
+	}
+	conn, err := websocket.Dial(url, "", origin)
+	if err == nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, err
+}
+
+// receive reads frames until a frame of the given type arrives, and returns
+// all frames read.
+func receive(t *testing.T, conn *websocket.Conn, until string) []wsFrame {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	frames := make([]wsFrame, 0)
+	for {
+		var frame wsFrame
+		if err := websocket.JSON.Receive(conn, &frame); err != nil {
+			t.Fatalf("after %+v: %v", frames, err)
+		}
+		frames = append(frames, frame)
+		if frame.Type == until {
+			return frames
+		}
+	}
+}
+
+func TestWebSocketChat(t *testing.T) {
+	server, provider := newTestServer(t)
+	provider.Script("other-model", fake.Text("Hi!"))
+	server.Client.AddModelDefinition(provider.NewModelDefinition("other", "other-model"))
+	conn, err := dial(t, server, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := `{"type": "chat", "id": "1", "models": ["small", "other"], "messages": [{"role": "user", "content": "Hi!"}]}`
+	if err := websocket.Message.Send(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	texts := make([]strings.Builder, 2)
+	done := make([]*wsFrame, 2)
+	for _, frame := range receive(t, conn, "finished") {
+		if frame.ID != "1" {
+			t.Errorf("frame %+v has the wrong id", frame)
+		}
+		switch frame.Type {
+		case "delta":
+			texts[*frame.Index].WriteString(frame.Delta)
+		case "done":
+			if done[*frame.Index] != nil {
+				t.Errorf("second done frame %+v", frame)
+			}
+			done[*frame.Index] = &frame
+		case "finished":
+		default:
+			t.Errorf("unexpected frame %+v", frame)
+		}
+	}
+	for i, want := range []string{"Hello there.", "Hi!"} {
+		if texts[i].String() != want {
+			t.Errorf("response %d: text %q, want %q", i, texts[i].String(), want)
+		}
+		frame := done[i]
+		if frame == nil || frame.Status != "ok" || frame.FinishReason != "stop" || frame.Usage == nil || frame.Cost == nil {
+			t.Errorf("response %d: done frame %+v", i, frame)
+		}
+	}
+	if done[0] != nil && done[0].Model != "small" {
+		t.Errorf("done frame of model %q, want small", done[0].Model)
+	}
+}
+
+func TestWebSocketCancel(t *testing.T) {
+	server, provider := newTestServer(t)
+	provider.Script("slow-model", fake.Response{Chunks: strings.Split(strings.Repeat("word ", 100), " "), ChunkDelay: 20 * time.Millisecond})
+	server.Client.AddModelDefinition(provider.NewModelDefinition("slow", "slow-model"))
+	conn, err := dial(t, server, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := `{"type": "chat", "id": "1", "models": ["slow"], "messages": [{"role": "user", "content": "Hi!"}]}`
+	if err := websocket.Message.Send(conn, request); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, conn, "delta")
+	if err := websocket.Message.Send(conn, `{"type": "cancel", "id": "1"}`); err != nil {
+		t.Fatal(err)
+	}
+	frames := receive(t, conn, "finished")
+	done := 0
+	for _, frame := range frames {
+		if frame.Type == "done" {
+			done++
+			if frame.Status != "cancelled" {
+				t.Errorf("done frame %+v, want status cancelled", frame)
+			}
+		}
+	}
+	if done != 1 {
+		t.Errorf("got %d done frames, want 1: %+v", done, frames)
+	}
+}
+
+func TestWebSocketErrors(t *testing.T) {
+	server, _ := newTestServer(t)
+	conn, err := dial(t, server, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		`{"type": "chat", "id": "1", "models": ["large"], "messages": [{"role": "user", "content": "Hi!"}]}`: "unknown model large",
+		`{"type": "chat", "id": "2", "messages": [{"role": "user", "content": "Hi!"}]}`:                      "no models requested",
+		`{"type": "dance", "id": "3"}`: "unknown frame type dance",
+		`{"type": `:                    "invalid frame",
+	}
+	for request, want := range tests {
+		if err := websocket.Message.Send(conn, request); err != nil {
+			t.Fatal(err)
+		}
+		frames := receive(t, conn, "error")
+		if frame := frames[len(frames)-1]; !strings.Contains(frame.Error, want) {
+			t.Errorf("%s: error %q, want %q", request, frame.Error, want)
+		}
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	server, _ := newTestServer(t)
+	if _, err := dial(t, server, "https://evil.test"); err == nil {
+		t.Error("connection from another origin was accepted")
+	}
+	server.AllowedOrigins = []string{"https://app.test"}
+	if _, err := dial(t, server, "https://app.test"); err != nil {
+		t.Errorf("connection from an allowed origin failed: %v", err)
+	}
+}
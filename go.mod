@@ -4,4 +4,17 @@ go 1.22.2
 
 require github.com/guregu/null/v5 v5.0.0
 
-require github.com/icza/dyno v0.0.0-20230330125955-09f820a8d9c0
+require (
+	github.com/icza/dyno v0.0.0-20230330125955-09f820a8d9c0
+	golang.org/x/net v0.27.0
+	golang.org/x/term v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/rogpeppe/go-internal v1.10.0 // indirect
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
+)
+
+require golang.org/x/sys v0.22.0 // indirect
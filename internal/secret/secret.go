@@ -0,0 +1,18 @@
+// Package secret knows which parts of the requests to the APIs carry
+// credentials, so they can be kept out of logs and recordings.
+package secret
+
+import "strings"
+
+// Headers are the request headers that carry API keys.
+var Headers = []string{"Authorization", "X-Api-Key", "Api-Key"}
+
+// IsHeader returns whether the header with the given name carries an API key.
+func IsHeader(name string) bool {
+	for _, h := range Headers {
+		if strings.EqualFold(name, h) {
+			return true
+		}
+	}
+	return false
+}
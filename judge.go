@@ -0,0 +1,306 @@
+package multi_ai_client
+
+import (
+	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultJudgeRubric is the rubric used by a judge if no other rubric is set.
+const DefaultJudgeRubric = "Prefer the response that best answers the last user message: it is correct, " +
+	"follows all instructions of the user and the system message, and is clear and no longer than needed."
+
+// DefaultJudgePrompt is the system prompt given to a judge if no other prompt
+// is set. {rubric} is replaced by the rubric of the judge.
+const DefaultJudgePrompt = "You are an impartial judge comparing responses of assistants to the last user message of a conversation. " +
+	"Judge the responses by this rubric:\n\n{rubric}\n\n" +
+	"The responses are in random order, and neither their order nor their length may influence your judgement. " +
+	"Give every response a score from 1 to 10 and pick the best one. " +
+	"Respond with a JSON object only, in this form:\n" +
+	`{"scores": [<score of response 1>, <score of response 2>, ...], "winner": <number of the best response>, "rationale": "<a short explanation>"}`
+
+// Judge is a struct representing the settings used to pick the best of
+// several responses by asking a model.
+type Judge struct {
+	// Model is the model that judges the responses.
+	Model *ModelDefinition
+
+	// Rubric describes what makes a response good. If it is empty,
+	// DefaultJudgeRubric is used.
+	Rubric string
+
+	// Prompt is the system prompt given to the judge, in which {rubric} is
+	// replaced by the rubric. It must ask for a verdict in the JSON form of
+	// DefaultJudgePrompt. If it is empty, DefaultJudgePrompt is used.
+	Prompt string
+
+	// Swap judges the responses a second time in reverse order, so a judge
+	// that prefers the first or last response it reads can not decide the
+	// verdict on its own. It doubles the cost of judging.
+	Swap bool
+
+	// Rand shuffles the responses before they are judged. If it is nil, the
+	// global source of math/rand is used. A rand.Rand must not be used by
+	// several judgements at once.
+	Rand *rand.Rand
+}
+
+// Verdict is a struct representing the judgement of a judge.
+type Verdict struct {
+	// Winner is the index of the best candidate.
+	Winner int
+	// Scores are the scores of the candidates, in the order of the
+	// candidates. When they were judged twice, the scores are averaged. It is
+	// nil if there was a single candidate, which is not judged.
+	Scores []float64
+	// Rationale is the explanation of the judge. When the candidates were
+	// judged twice, it holds both explanations separated by a blank line.
+	Rationale string
+	// Consistent is false if the candidates were judged twice and both
+	// judgements picked a different winner. The winner is then the candidate
+	// with the highest average score.
+	Consistent bool
+}
+
+// Judge asks the judge which of the candidate responses to the chat is best.
+// If the chat ends with an assistant message, the candidates are taken to
+// continue it, as the responses to a prefill do. The request is made through
+// the client, so its budgets, costs, cache and observers apply. The chat of the
+// client is not used, so it can be called concurrently.
+func (c *Client) Judge(ctx context.Context, judge Judge, chat Chat, candidates []string) (Verdict, error) {
+	if judge.Model == nil {
+		return Verdict{}, errors.New("judge has no model")
+	}
+	if len(candidates) == 0 {
+		return Verdict{}, errors.New("no candidates to judge")
+	}
+	if len(candidates) == 1 {
+		return Verdict{Winner: 0, Consistent: true}, nil
+	}
+
+	messages := chat.requestMessages(true)
+	prefill := ""
+	if len(messages) > 0 && messages[len(messages)-1].Type == AssistantMessage {
+		prefill = messages[len(messages)-1].Text
+		messages = messages[:len(messages)-1]
+	}
+	responses := make([]string, len(candidates))
+	for i, candidate := range candidates {
+		responses[i] = prefill + candidate
+	}
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	shuffle := rand.Shuffle
+	if judge.Rand != nil {
+		shuffle = judge.Rand.Shuffle
+	}
+	shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	orders := [][]int{order}
+	if judge.Swap {
+		reversed := make([]int, len(order))
+		for i, index := range order {
+			reversed[len(order)-1-i] = index
+		}
+		orders = append(orders, reversed)
+	}
+
+	verdicts := make([]Verdict, len(orders))
+	errs := make([]error, len(orders))
+	var wg sync.WaitGroup
+	for i, order := range orders {
+		wg.Add(1)
+		go func(i int, order []int) {
+			defer wg.Done()
+			verdicts[i], errs[i] = c.judgeOnce(ctx, judge, messages, responses, order)
+		}(i, order)
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return Verdict{}, err
+	}
+	return combineVerdicts(verdicts), nil
+}
+
+// judgeOnce asks the judge to judge the responses in the given order, and
+// returns its verdict in the order of the responses.
+func (c *Client) judgeOnce(ctx context.Context, judge Judge, messages []Message, responses []string, order []int) (Verdict, error) {
+	rubric := judge.Rubric
+	if rubric == "" {
+		rubric = DefaultJudgeRubric
+	}
+	prompt := judge.Prompt
+	if prompt == "" {
+		prompt = DefaultJudgePrompt
+	}
+	boundary, err := judgeBoundary(messages, responses)
+	if err != nil {
+		return Verdict{}, err
+	}
+	request := Chat{}
+	request.SetSystemMessage(strings.ReplaceAll(prompt, "{rubric}", rubric))
+	request.AddUserMessage(judgeTranscript(messages, responses, order, boundary))
+
+	text, err := c.complete(ctx, &request, *judge.Model)
+	if err != nil {
+		return Verdict{}, err
+	}
+	return parseVerdict(text, order)
+}
+
+// judgeBoundary returns a random suffix for the tags around the conversation
+// and the responses, that appears in none of them. A response can then not
+// close its tag early and pose as another response.
+func judgeBoundary(messages []Message, responses []string) (string, error) {
+	for {
+		b := make([]byte, 8)
+		if _, err := cryptorand.Read(b); err != nil {
+			return "", err
+		}
+		boundary := hex.EncodeToString(b)
+		found := false
+		for _, m := range messages {
+			found = found || strings.Contains(m.Text, boundary)
+		}
+		for _, response := range responses {
+			found = found || strings.Contains(response, boundary)
+		}
+		if !found {
+			return boundary, nil
+		}
+	}
+}
+
+// judgeTranscript returns the conversation and the responses in the given
+// order, as presented to the judge, in tags ending in the boundary.
+func judgeTranscript(messages []Message, responses []string, order []int, boundary string) string {
+	var sb strings.Builder
+	sb.WriteString("<conversation-" + boundary + ">\n")
+	for _, m := range messages {
+		switch m.Type {
+		case SystemMessage:
+			sb.WriteString("System: ")
+		case UserMessage:
+			sb.WriteString("User: ")
+		default:
+			sb.WriteString("Assistant: ")
+		}
+		sb.WriteString(m.Text)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("</conversation-" + boundary + ">\n")
+	for i, index := range order {
+		sb.WriteString("\n<response-" + boundary + " number=\"" + strconv.Itoa(i+1) + "\">\n")
+		sb.WriteString(responses[index])
+		sb.WriteString("\n</response-" + boundary + ">\n")
+	}
+	return sb.String()
+}
+
+// parseVerdict reads the JSON verdict of a judge that saw the responses in the
+// given order. The verdict is the first JSON object in the text with a winner.
+// Text around it, such as a code fence or other braces, is ignored.
+func parseVerdict(text string, order []int) (Verdict, error) {
+	type rawVerdict struct {
+		Winner    *int      `json:"winner"`
+		Scores    []float64 `json:"scores"`
+		Rationale string    `json:"rationale"`
+	}
+	var raw rawVerdict
+	var decodeErr error
+	for start := strings.Index(text, "{"); start >= 0 && raw.Winner == nil; {
+		raw = rawVerdict{}
+		if err := json.NewDecoder(strings.NewReader(text[start:])).Decode(&raw); err != nil {
+			decodeErr = err
+			raw.Winner = nil
+		}
+		next := strings.Index(text[start+1:], "{")
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	if raw.Winner == nil {
+		if decodeErr != nil {
+			return Verdict{}, errors.New("judge returned an invalid verdict: " + decodeErr.Error())
+		}
+		return Verdict{}, errors.New("judge returned no verdict: " + text)
+	}
+	winner := *raw.Winner
+	if winner < 1 || winner > len(order) {
+		return Verdict{}, errors.New("judge picked response " + strconv.Itoa(winner) + " of " + strconv.Itoa(len(order)))
+	}
+	if len(raw.Scores) != len(order) {
+		return Verdict{}, errors.New("judge returned " + strconv.Itoa(len(raw.Scores)) + " scores for " + strconv.Itoa(len(order)) + " responses")
+	}
+	verdict := Verdict{
+		Winner:     order[winner-1],
+		Scores:     make([]float64, len(order)),
+		Rationale:  strings.TrimSpace(raw.Rationale),
+		Consistent: true,
+	}
+	for i, index := range order {
+		verdict.Scores[index] = raw.Scores[i]
+	}
+	return verdict, nil
+}
+
+// combineVerdicts combines the verdicts of judging the same candidates in
+// different orders.
+func combineVerdicts(verdicts []Verdict) Verdict {
+	if len(verdicts) == 1 {
+		return verdicts[0]
+	}
+	combined := Verdict{
+		Winner:     verdicts[0].Winner,
+		Scores:     make([]float64, len(verdicts[0].Scores)),
+		Consistent: true,
+	}
+	rationales := make([]string, 0, len(verdicts))
+	for _, verdict := range verdicts {
+		for i, score := range verdict.Scores {
+			combined.Scores[i] += score / float64(len(verdicts))
+		}
+		if verdict.Winner != combined.Winner {
+			combined.Consistent = false
+		}
+		if verdict.Rationale != "" {
+			rationales = append(rationales, verdict.Rationale)
+		}
+	}
+	combined.Rationale = strings.Join(rationales, "\n\n")
+	if !combined.Consistent {
+		for i, score := range combined.Scores {
+			if score > combined.Scores[combined.Winner] {
+				combined.Winner = i
+			}
+		}
+	}
+	return combined
+}
+
+// SelectResponse asks the judge which of the candidate responses to the chat
+// of the client is best, and adds it to the chat as an assistant message. If
+// the chat ends with an assistant message, the candidates continue it and the
+// winner is appended to it instead.
+func (c *Client) SelectResponse(ctx context.Context, judge Judge, candidates []string) (Verdict, error) {
+	verdict, err := c.Judge(ctx, judge, c.Chat, candidates)
+	if err != nil {
+		return Verdict{}, err
+	}
+	messages := c.Chat.GetMessagesWithoutSystemMessage()
+	if len(messages) > 0 && messages[len(messages)-1].Type == AssistantMessage {
+		c.Chat.ReplaceLastAssistantMessage(messages[len(messages)-1].Text + candidates[verdict.Winner])
+	} else {
+		c.Chat.AddAssistantMessage(candidates[verdict.Winner])
+	}
+	return verdict, nil
+}
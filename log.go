@@ -0,0 +1,211 @@
+package multi_ai_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/villadelfia/multi-ai-client/internal/secret"
+)
+
+// redacted replaces secrets in logs.
+const redacted = "[redacted]"
+
+// contentKeys are the keys of request bodies that hold message content.
+var contentKeys = map[string]bool{"content": true, "text": true, "system": true, "prompt": true}
+
+// knownEventTypes are the types of the events of the Anthropic API. Events of
+// the other APIs have no type.
+var knownEventTypes = map[string]bool{
+	"message_start":       true,
+	"content_block_start": true,
+	"content_block_delta": true,
+	"content_block_stop":  true,
+	"message_delta":       true,
+	"message_stop":        true,
+	"ping":                true,
+	"error":               true,
+}
+
+// redactor removes API keys, and optionally message content, from logged
+// values.
+type redactor struct {
+	apiKey  string
+	content bool
+}
+
+// redactString replaces the API key in s.
+func (r redactor) redactString(s string) string {
+	if r.apiKey == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, r.apiKey, redacted)
+}
+
+// redactText replaces s with its length if content is redacted.
+func (r redactor) redactText(s string) string {
+	if r.content {
+		return fmt.Sprintf("[redacted %d bytes]", len(s))
+	}
+	return r.redactString(s)
+}
+
+// redactHeader returns the header as a log value, without API keys.
+func (r redactor) redactHeader(header http.Header) slog.Value {
+	attrs := make([]slog.Attr, 0, len(header))
+	for k := range header {
+		v := header.Get(k)
+		if secret.IsHeader(k) {
+			v = redacted
+		}
+		attrs = append(attrs, slog.String(k, r.redactString(v)))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// redactBody returns a request body as a log value, with the content of the
+// messages replaced if content is redacted.
+func (r redactor) redactBody(body []byte) string {
+	if !r.content {
+		return r.redactString(string(body))
+	}
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return r.redactText(string(body))
+	}
+	data = r.redactValue(data)
+	redactedBody, err := json.Marshal(data)
+	if err != nil {
+		return r.redactText(string(body))
+	}
+	return r.redactString(string(redactedBody))
+}
+
+func (r redactor) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if s, ok := e.(string); ok && contentKeys[k] {
+				v[k] = r.redactText(s)
+			} else {
+				v[k] = r.redactValue(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = r.redactValue(e)
+		}
+	}
+	return v
+}
+
+// logRequest logs the request of an exchange as it is sent.
+func logRequest(ctx context.Context, logger *slog.Logger, r redactor, exchange *Exchange) {
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []any{
+		slog.String("model", exchange.ModelDefinition.Name),
+		slog.String("method", exchange.Request.Method),
+		slog.String("url", r.redactString(exchange.Request.URL.String())),
+		slog.Any("header", r.redactHeader(exchange.Request.Header)),
+	}
+	if exchange.Request.GetBody != nil {
+		if body, err := exchange.Request.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			attrs = append(attrs, slog.String("body", r.redactBody(data)))
+		}
+	}
+	logger.DebugContext(ctx, "sending request", attrs...)
+}
+
+// logObserver is an Observer that logs the start and end of every request.
+type logObserver struct {
+	logger        *slog.Logger
+	redactContent bool
+
+	mu     sync.Mutex
+	starts map[*Exchange]time.Time
+}
+
+func newLogObserver(logger *slog.Logger, redactContent bool) *logObserver {
+	return &logObserver{
+		logger:        logger,
+		redactContent: redactContent,
+		starts:        make(map[*Exchange]time.Time),
+	}
+}
+
+func (o *logObserver) ResponseStarted(ctx context.Context, chat Chat, modelDefinitions []ModelDefinition) context.Context {
+	o.logger.DebugContext(ctx, "creating responses", slog.Int("models", len(modelDefinitions)))
+	return ctx
+}
+
+func (o *logObserver) RequestStarted(ctx context.Context, exchange *Exchange) context.Context {
+	o.mu.Lock()
+	o.starts[exchange] = time.Now()
+	o.mu.Unlock()
+	o.logger.DebugContext(ctx, "request started",
+		slog.String("model", exchange.ModelDefinition.Name),
+		slog.String("api_type", exchange.ModelDefinition.APISettings.APIType.String()))
+	return ctx
+}
+
+func (o *logObserver) FirstToken(ctx context.Context, exchange *Exchange) {
+	o.mu.Lock()
+	start := o.starts[exchange]
+	o.mu.Unlock()
+	o.logger.DebugContext(ctx, "first token received",
+		slog.String("model", exchange.ModelDefinition.Name),
+		slog.Duration("time_to_first_token", time.Since(start)))
+}
+
+func (o *logObserver) RequestFinished(ctx context.Context, exchange *Exchange, chunk MessageChunk) {
+	o.mu.Lock()
+	start := o.starts[exchange]
+	delete(o.starts, exchange)
+	o.mu.Unlock()
+
+	r := redactor{apiKey: exchange.apiKey, content: o.redactContent}
+	attrs := []any{
+		slog.String("model", exchange.ModelDefinition.Name),
+		slog.Duration("duration", time.Since(start)),
+		slog.Bool("cached", exchange.Cached),
+	}
+	if exchange.StatusCode != 0 {
+		attrs = append(attrs, slog.Int("status", exchange.StatusCode))
+	}
+
+	var apiError *APIError
+	switch {
+	case chunk.Err == nil:
+		if chunk.Usage != nil {
+			attrs = append(attrs,
+				slog.Int("input_tokens", chunk.Usage.InputTokens),
+				slog.Int("output_tokens", chunk.Usage.OutputTokens),
+				slog.Bool("usage_estimated", chunk.Usage.Estimated))
+		}
+		attrs = append(attrs, slog.Float64("cost", chunk.Cost), slog.String("finish_reason", chunk.FinishReason))
+		o.logger.InfoContext(ctx, "request finished", attrs...)
+	case errors.As(chunk.Err, &apiError):
+		attrs = append(attrs, slog.String("error", r.redactString(apiError.Status)), slog.String("body", r.redactString(apiError.Body)))
+		o.logger.WarnContext(ctx, "request rejected", attrs...)
+	case errors.Is(chunk.Err, context.Canceled), errors.Is(chunk.Err, context.DeadlineExceeded):
+		attrs = append(attrs, slog.String("error", chunk.Err.Error()))
+		o.logger.InfoContext(ctx, "request cancelled", attrs...)
+	default:
+		attrs = append(attrs, slog.String("error", r.redactString(chunk.Err.Error())))
+		o.logger.ErrorContext(ctx, "request failed", attrs...)
+	}
+}
+
+func (o *logObserver) ResponseFinished(ctx context.Context) {
+	o.logger.DebugContext(ctx, "all responses finished")
+}
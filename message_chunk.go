@@ -1,6 +1,27 @@
 package multi_ai_client
 
+// MessageChunk is a struct representing a part of a response.
+// Index is the index of the model definition the response is from.
 type MessageChunk struct {
 	Index int
 	Delta string
+
+	// Done is set on the last chunk of each response, which carries no delta.
+	Done bool
+	// Usage is the token usage of the response, set on the last chunk. It is
+	// also set if the response failed or was cancelled after it started.
+	Usage *Usage
+	// Cost is the cost of the response in dollars, set on the last chunk.
+	// It is 0 if the price of the model is unknown.
+	Cost float64
+	// FinishReason is the reason the model stopped, as reported by the API,
+	// set on the last chunk.
+	FinishReason string
+	// Cached is set on the last chunk if the response was replayed from the
+	// cache of the client. Cached responses cost nothing.
+	Cached bool
+	// Err is set on the last chunk if the response failed. Requests that
+	// were answered with an error status return an *APIError, and responses
+	// that failed with an error event in their stream a *StreamError.
+	Err error
 }
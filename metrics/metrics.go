@@ -0,0 +1,184 @@
+// Package metrics records latency, time to first token, throughput and token
+// counts of the requests of a multi_ai_client.Client.
+//
+// The Observer measures every request and hands the results to a Recorder,
+// which sends them to a metrics backend. A Recorder for Prometheus is provided
+// in the prometheus subpackage:
+//
+//	collector := prometheus.NewCollector()
+//	registry.MustRegister(collector)
+//	client.Observe(metrics.NewObserver(collector))
+package metrics
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	mac "github.com/villadelfia/multi-ai-client"
+)
+
+// Outcome is the result of a request, as used in the outcome label.
+type Outcome string
+
+const (
+	// Success is the outcome of a request that streamed a full response.
+	Success Outcome = "success"
+	// APIError is the outcome of a request the API answered with an error
+	// status code.
+	APIError Outcome = "api_error"
+	// Failure is the outcome of a request that failed without a status code,
+	// or broke off while streaming.
+	Failure Outcome = "failure"
+	// Cancelled is the outcome of a request whose context was cancelled.
+	Cancelled Outcome = "cancelled"
+)
+
+// Labels are the labels every measurement is recorded with.
+type Labels struct {
+	// Model is the name of the model definition.
+	Model string
+	// APIType is the API type of the model definition.
+	APIType string
+}
+
+// Result is the measurement of a finished request.
+type Result struct {
+	Outcome Outcome
+	// StatusCode is the HTTP status code of the response, or 0 if there was
+	// none, such as for cached responses and connection errors.
+	StatusCode int
+	// Cached is whether the response was answered from the cache.
+	Cached bool
+
+	// Latency is the time from sending the request to the end of the stream.
+	Latency time.Duration
+	// TimeToFirstToken is the time from sending the request to the first text
+	// of the response, or 0 if no text arrived.
+	TimeToFirstToken time.Duration
+
+	InputTokens  int
+	OutputTokens int
+}
+
+// OutputTokensPerSecond returns the throughput of the response: the output
+// tokens divided by the time spent streaming them after the first token. It
+// returns 0 if the throughput can not be determined.
+func (r Result) OutputTokensPerSecond() float64 {
+	streaming := r.Latency - r.TimeToFirstToken
+	if r.OutputTokens == 0 || r.TimeToFirstToken == 0 || streaming <= 0 {
+		return 0
+	}
+	return float64(r.OutputTokens) / streaming.Seconds()
+}
+
+// StatusLabel returns the status code of the result as a label value, or
+// "none" if there is no status code.
+func (r Result) StatusLabel() string {
+	if r.StatusCode == 0 {
+		return "none"
+	}
+	return strconv.Itoa(r.StatusCode)
+}
+
+// Recorder is an interface representing a metrics backend.
+// Its methods must be safe for concurrent use.
+type Recorder interface {
+	// RequestStarted is called when a request is sent, and should count it
+	// as in flight.
+	RequestStarted(labels Labels)
+
+	// RequestFinished is called when a request is finished, and should count
+	// it as no longer in flight.
+	RequestFinished(labels Labels, result Result)
+}
+
+// Observer is a mac.Observer that measures requests and records them to a
+// Recorder.
+type Observer struct {
+	recorder Recorder
+
+	mu       sync.Mutex
+	requests map[*mac.Exchange]*request
+}
+
+type request struct {
+	labels     Labels
+	start      time.Time
+	firstToken time.Time
+}
+
+// NewObserver creates a new Observer recording to the given recorder.
+func NewObserver(recorder Recorder) *Observer {
+	return &Observer{
+		recorder: recorder,
+		requests: make(map[*mac.Exchange]*request),
+	}
+}
+
+func (o *Observer) ResponseStarted(ctx context.Context, chat mac.Chat, modelDefinitions []mac.ModelDefinition) context.Context {
+	return ctx
+}
+
+func (o *Observer) RequestStarted(ctx context.Context, exchange *mac.Exchange) context.Context {
+	r := &request{
+		labels: Labels{
+			Model:   exchange.ModelDefinition.Name,
+			APIType: exchange.ModelDefinition.APISettings.APIType.String(),
+		},
+		start: time.Now(),
+	}
+	o.mu.Lock()
+	o.requests[exchange] = r
+	o.mu.Unlock()
+	o.recorder.RequestStarted(r.labels)
+	return ctx
+}
+
+func (o *Observer) FirstToken(ctx context.Context, exchange *mac.Exchange) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if r, ok := o.requests[exchange]; ok {
+		r.firstToken = time.Now()
+	}
+}
+
+func (o *Observer) RequestFinished(ctx context.Context, exchange *mac.Exchange, chunk mac.MessageChunk) {
+	o.mu.Lock()
+	r, ok := o.requests[exchange]
+	delete(o.requests, exchange)
+	o.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	result := Result{
+		Outcome:    Success,
+		StatusCode: exchange.StatusCode,
+		Cached:     exchange.Cached,
+		Latency:    time.Since(r.start),
+	}
+	if !r.firstToken.IsZero() {
+		result.TimeToFirstToken = r.firstToken.Sub(r.start)
+	}
+
+	var apiError *mac.APIError
+	switch {
+	case errors.As(chunk.Err, &apiError):
+		result.Outcome = APIError
+	case errors.Is(chunk.Err, context.Canceled), errors.Is(chunk.Err, context.DeadlineExceeded):
+		result.Outcome = Cancelled
+	case chunk.Err != nil:
+		result.Outcome = Failure
+	}
+
+	if chunk.Usage != nil {
+		result.InputTokens = chunk.Usage.InputTokens
+		result.OutputTokens = chunk.Usage.OutputTokens
+	}
+	o.recorder.RequestFinished(r.labels, result)
+}
+
+func (o *Observer) ResponseFinished(ctx context.Context) {}
@@ -0,0 +1,115 @@
+// Package prometheus provides a metrics.Recorder that exposes the metrics of a
+// multi_ai_client.Client to Prometheus.
+//
+// The package is a module of its own, so only applications that use it depend
+// on the Prometheus client.
+package prometheus
+
+import (
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/villadelfia/multi-ai-client/metrics"
+)
+
+// DefaultNamespace is the namespace of the metrics if none is given.
+const DefaultNamespace = "multi_ai_client"
+
+// Collector is a metrics.Recorder and a prometheus.Collector. Register it with
+// a Prometheus registry to expose the metrics.
+type Collector struct {
+	requests         *prom.CounterVec
+	latency          *prom.HistogramVec
+	timeToFirstToken *prom.HistogramVec
+	tokensPerSecond  *prom.HistogramVec
+	tokens           *prom.CounterVec
+	inFlight         *prom.GaugeVec
+}
+
+// NewCollector creates a new Collector with metrics in the DefaultNamespace.
+func NewCollector() *Collector {
+	return NewCollectorWithNamespace(DefaultNamespace)
+}
+
+// NewCollectorWithNamespace creates a new Collector with metrics in the given
+// namespace.
+func NewCollectorWithNamespace(namespace string) *Collector {
+	labels := []string{"model", "api_type"}
+	return &Collector{
+		requests: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "Requests sent to models, by outcome and HTTP status code.",
+		}, append(labels, "outcome", "status_code", "cached")),
+		latency: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Time from sending a request to the end of its response stream.",
+			Buckets:   []float64{0.25, 0.5, 1, 2, 4, 8, 16, 32, 64, 128},
+		}, append(labels, "outcome")),
+		timeToFirstToken: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "time_to_first_token_seconds",
+			Help:      "Time from sending a request to the first text of its response.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 1, 2, 4, 8, 16, 32},
+		}, labels),
+		tokensPerSecond: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "output_tokens_per_second",
+			Help:      "Output tokens streamed per second after the first token.",
+			Buckets:   []float64{5, 10, 20, 40, 60, 80, 100, 150, 200, 400},
+		}, labels),
+		tokens: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Name:      "tokens_total",
+			Help:      "Tokens used by successful requests, by direction.",
+		}, append(labels, "direction")),
+		inFlight: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace,
+			Name:      "requests_in_flight",
+			Help:      "Requests that were sent and have not finished yet.",
+		}, labels),
+	}
+}
+
+func (c *Collector) RequestStarted(labels metrics.Labels) {
+	c.inFlight.WithLabelValues(labels.Model, labels.APIType).Inc()
+}
+
+func (c *Collector) RequestFinished(labels metrics.Labels, result metrics.Result) {
+	model, apiType := labels.Model, labels.APIType
+	c.inFlight.WithLabelValues(model, apiType).Dec()
+
+	cached := "false"
+	if result.Cached {
+		cached = "true"
+	}
+	c.requests.WithLabelValues(model, apiType, string(result.Outcome), result.StatusLabel(), cached).Inc()
+	c.latency.WithLabelValues(model, apiType, string(result.Outcome)).Observe(result.Latency.Seconds())
+	if result.Outcome != metrics.Success || result.Cached {
+		return
+	}
+
+	if result.TimeToFirstToken > 0 {
+		c.timeToFirstToken.WithLabelValues(model, apiType).Observe(result.TimeToFirstToken.Seconds())
+	}
+	if tps := result.OutputTokensPerSecond(); tps > 0 {
+		c.tokensPerSecond.WithLabelValues(model, apiType).Observe(tps)
+	}
+	c.tokens.WithLabelValues(model, apiType, "input").Add(float64(result.InputTokens))
+	c.tokens.WithLabelValues(model, apiType, "output").Add(float64(result.OutputTokens))
+}
+
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+func (c *Collector) collectors() []prom.Collector {
+	return []prom.Collector{c.requests, c.latency, c.timeToFirstToken, c.tokensPerSecond, c.tokens, c.inFlight}
+}
@@ -0,0 +1,95 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/villadelfia/multi-ai-client/metrics"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector()
+	registry := prom.NewPedanticRegistry()
+	if err := registry.Register(collector); err != nil {
+		t.Fatal(err)
+	}
+
+	labels := metrics.Labels{Model: "GPT", APIType: "OpenAI"}
+	collector.RequestStarted(labels)
+	collector.RequestStarted(labels)
+	if got := testutil.ToFloat64(collector.inFlight); got != 2 {
+		t.Errorf("%v requests in flight, want 2", got)
+	}
+	collector.RequestFinished(labels, metrics.Result{
+		Outcome:          metrics.Success,
+		StatusCode:       200,
+		Latency:          2 * time.Second,
+		TimeToFirstToken: time.Second,
+		InputTokens:      10,
+		OutputTokens:     50,
+	})
+	collector.RequestFinished(labels, metrics.Result{
+		Outcome:    metrics.APIError,
+		StatusCode: 429,
+		Latency:    100 * time.Millisecond,
+	})
+
+	want := `
+# HELP multi_ai_client_requests_in_flight Requests that were sent and have not finished yet.
+# TYPE multi_ai_client_requests_in_flight gauge
+multi_ai_client_requests_in_flight{api_type="OpenAI",model="GPT"} 0
+# HELP multi_ai_client_requests_total Requests sent to models, by outcome and HTTP status code.
+# TYPE multi_ai_client_requests_total counter
+multi_ai_client_requests_total{api_type="OpenAI",cached="false",model="GPT",outcome="api_error",status_code="429"} 1
+multi_ai_client_requests_total{api_type="OpenAI",cached="false",model="GPT",outcome="success",status_code="200"} 1
+# HELP multi_ai_client_tokens_total Tokens used by successful requests, by direction.
+# TYPE multi_ai_client_tokens_total counter
+multi_ai_client_tokens_total{api_type="OpenAI",direction="input",model="GPT"} 10
+multi_ai_client_tokens_total{api_type="OpenAI",direction="output",model="GPT"} 50
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(want),
+		"multi_ai_client_requests_in_flight", "multi_ai_client_requests_total", "multi_ai_client_tokens_total")
+	if err != nil {
+		t.Error(err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	histograms := map[string]uint64{}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			if h := metric.GetHistogram(); h != nil {
+				histograms[family.GetName()] += h.GetSampleCount()
+			}
+		}
+	}
+	wantHistograms := map[string]uint64{
+		"multi_ai_client_request_duration_seconds":    2,
+		"multi_ai_client_time_to_first_token_seconds": 1,
+		"multi_ai_client_output_tokens_per_second":    1,
+	}
+	for name, count := range wantHistograms {
+		if histograms[name] != count {
+			t.Errorf("%s has %d samples, want %d", name, histograms[name], count)
+		}
+	}
+}
+
+func TestCollectorNamespace(t *testing.T) {
+	registry := prom.NewPedanticRegistry()
+	if err := registry.Register(NewCollectorWithNamespace("gateway")); err != nil {
+		t.Fatal(err)
+	}
+	// A second collector in the same namespace collides with the first.
+	if err := registry.Register(NewCollectorWithNamespace("gateway")); err == nil {
+		t.Error("registered two collectors with the same namespace")
+	}
+	if err := registry.Register(NewCollector()); err != nil {
+		t.Errorf("could not register a collector in another namespace: %v", err)
+	}
+}
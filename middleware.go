@@ -0,0 +1,148 @@
+package multi_ai_client
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Exchange is a struct representing a single request to a model, as seen by
+// middleware.
+type Exchange struct {
+	// Index is the index of the model definition in the client, and of the
+	// chunks of this response.
+	Index int
+	// ModelDefinition is the model definition the request is for.
+	ModelDefinition *ModelDefinition
+	// Chat is the chat the request was made from.
+	Chat Chat
+	// Request is the HTTP request built by ModelDefinition.CreateRequest.
+	// Middleware may change it, or replace it, before calling the next
+	// handler.
+	Request *http.Request
+	// Cached is set by the client when the response is replayed from its
+	// cache instead of sent to the API. Middleware that answers the request
+	// without calling the next handler should set it too, so no cost is
+	// charged for the response.
+	Cached bool
+	// StatusCode is set by the client to the HTTP status code of the response
+	// of the API, and left 0 if no response was received.
+	StatusCode int
+
+	// apiKey is the API key the request is sent with.
+	apiKey string
+}
+
+// Handler is a function that sends the request of an exchange and returns the
+// stream of events of the response.
+type Handler func(ctx context.Context, exchange *Exchange) (EventStream, error)
+
+// Middleware is a function wrapping a Handler.
+// Middleware can change the request before calling next, wrap the returned
+// stream to observe or change the events, or return a stream of its own
+// without calling next at all.
+type Middleware func(next Handler) Handler
+
+// Use adds middleware to the client.
+// The first middleware added is the outermost: it sees the request first, and
+// the events of the response last.
+func (c *Client) Use(middleware ...Middleware) {
+	c.middleware = append(c.middleware, middleware...)
+}
+
+// chain wraps the handler in the middleware, the first one outermost.
+func chain(middleware []Middleware, handler Handler) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// StreamFunc is an EventStream that calls functions for Next and Close.
+// It is useful to wrap a stream in middleware.
+type StreamFunc struct {
+	NextFunc  func() (StreamEvent, error)
+	CloseFunc func() error
+}
+
+func (s StreamFunc) Next() (StreamEvent, error) {
+	return s.NextFunc()
+}
+
+func (s StreamFunc) Close() error {
+	if s.CloseFunc == nil {
+		return nil
+	}
+	return s.CloseFunc()
+}
+
+// HeaderMiddleware returns middleware that sets the given headers on every
+// request, replacing headers with the same name.
+func HeaderMiddleware(header http.Header) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, exchange *Exchange) (EventStream, error) {
+			for k, v := range header {
+				exchange.Request.Header[http.CanonicalHeaderKey(k)] = append([]string(nil), v...)
+			}
+			return next(ctx, exchange)
+		}
+	}
+}
+
+// LoggingMiddleware returns middleware that logs the start and end of every
+// request to the logger, with the model, duration, amount of events and
+// outcome. API keys are redacted, and the content of the messages is never
+// logged. Unlike Client.Logger, it logs the request as it is at its place in
+// the chain, and can be ordered among the other middleware.
+func LoggingMiddleware(logger *slog.Logger) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, exchange *Exchange) (EventStream, error) {
+			r := redactor{apiKey: exchange.apiKey, content: true}
+			attrs := []any{
+				slog.String("model", exchange.ModelDefinition.Name),
+				slog.String("url", r.redactString(exchange.Request.URL.String())),
+			}
+			start := time.Now()
+			logger.InfoContext(ctx, "request started", attrs...)
+			logRequest(ctx, logger, r, exchange)
+
+			stream, err := next(ctx, exchange)
+			// The request may have been retried with another key.
+			r.apiKey = exchange.apiKey
+			if err != nil {
+				logger.ErrorContext(ctx, "request failed", append(attrs,
+					slog.Duration("duration", time.Since(start)),
+					slog.String("error", r.redactString(err.Error())))...)
+				return nil, err
+			}
+
+			events := 0
+			logged := false
+			return StreamFunc{
+				NextFunc: func() (StreamEvent, error) {
+					event, err := stream.Next()
+					if err == nil {
+						events++
+						return event, nil
+					}
+					if !logged {
+						logged = true
+						end := append(attrs,
+							slog.Duration("duration", time.Since(start)),
+							slog.Int("events", events),
+							slog.Bool("cached", exchange.Cached))
+						if err == io.EOF {
+							logger.InfoContext(ctx, "request finished", end...)
+						} else {
+							logger.ErrorContext(ctx, "request failed", append(end, slog.String("error", r.redactString(err.Error())))...)
+						}
+					}
+					return event, err
+				},
+				CloseFunc: stream.Close,
+			}, nil
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// okTransport answers every request with the same successful stream.
+func okTransport(header *http.Header) roundTripFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		if header != nil {
+			*header = req.Header.Clone()
+		}
+		body := `data: {"choices": [{"delta": {"content": "Hello."}, "finish_reason": "stop"}]}` + "\n\ndata: [DONE]\n\n"
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	}
+}
+
+// respondOnce answers a chat with the model definition, and returns the text
+// and the last chunk of the response.
+func respondOnce(t *testing.T, client *Client, m ModelDefinition) (string, MessageChunk) {
+	t.Helper()
+	chat := Chat{}
+	chat.AddUserMessage("Say hello.")
+	_, ch, err := client.CreateResponseForChat(context.Background(), &chat, []ModelDefinition{m})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sb strings.Builder
+	var last MessageChunk
+	for chunk := range ch {
+		sb.WriteString(chunk.Delta)
+		last = chunk
+	}
+	return sb.String(), last
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	var mu sync.Mutex
+	calls := make([]string, 0)
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	named := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, exchange *Exchange) (EventStream, error) {
+				record(name + " request")
+				stream, err := next(ctx, exchange)
+				if err != nil {
+					return nil, err
+				}
+				return StreamFunc{
+					NextFunc: func() (StreamEvent, error) {
+						event, err := stream.Next()
+						if err == nil {
+							record(name + " event")
+							event.Delta = strings.ToLower(name) + event.Delta
+						}
+						return event, err
+					},
+					CloseFunc: stream.Close,
+				}, nil
+			}
+		}
+	}
+
+	client := &Client{HTTPClient: &http.Client{Transport: okTransport(nil)}}
+	client.Use(named("A"), named("B"))
+	text, last := respondOnce(t, client, NewModelDefinition("GPT", OpenAI, "key", "gpt-4o"))
+	if last.Err != nil {
+		t.Fatal(last.Err)
+	}
+	want := []string{"A request", "B request", "B event", "A event"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls %v, want %v", calls, want)
+	}
+	// The outermost middleware changes the events last.
+	if text != "abHello." {
+		t.Errorf("text %q, want the changes of B and then A", text)
+	}
+}
+
+func TestMiddlewareShortCircuit(t *testing.T) {
+	requests := 0
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return okTransport(nil)(req)
+	})
+	client := &Client{HTTPClient: &http.Client{Transport: transport}}
+	client.Use(func(next Handler) Handler {
+		return func(ctx context.Context, exchange *Exchange) (EventStream, error) {
+			exchange.Cached = true
+			return NewEventStream(
+				StreamEvent{Delta: "Canned "},
+				StreamEvent{Delta: "answer.", FinishReason: "stop", Usage: &Usage{InputTokens: 3, OutputTokens: 2}},
+			), nil
+		}
+	})
+	text, last := respondOnce(t, client, NewModelDefinition("GPT", OpenAI, "key", "gpt-4o"))
+	if last.Err != nil {
+		t.Fatal(last.Err)
+	}
+	if requests != 0 {
+		t.Errorf("sent %d requests, want none", requests)
+	}
+	if text != "Canned answer." || last.FinishReason != "stop" {
+		t.Errorf("text %q and finish reason %q", text, last.FinishReason)
+	}
+	if last.Cost != 0 || !last.Cached {
+		t.Errorf("cost %v and cached %v, want a free cached response", last.Cost, last.Cached)
+	}
+}
+
+func TestHeaderMiddleware(t *testing.T) {
+	var received http.Header
+	client := &Client{HTTPClient: &http.Client{Transport: okTransport(&received)}}
+	client.Use(HeaderMiddleware(http.Header{
+		"x-trace-id":   {"abc"},
+		"Content-Type": {"application/json; charset=utf-8"},
+	}))
+	if _, last := respondOnce(t, client, NewModelDefinition("GPT", OpenAI, "key", "gpt-4o")); last.Err != nil {
+		t.Fatal(last.Err)
+	}
+	if got := received.Get("X-Trace-Id"); got != "abc" {
+		t.Errorf("X-Trace-Id %q, want abc", got)
+	}
+	if got := received.Values("Content-Type"); len(got) != 1 || got[0] != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type %q, want only the header of the middleware", got)
+	}
+	if received.Get("Authorization") != "Bearer key" {
+		t.Error("the middleware removed the other headers")
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	const key = "sk-secret-key"
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := &Client{HTTPClient: &http.Client{Transport: okTransport(nil)}}
+	client.Use(LoggingMiddleware(logger))
+	m := NewModelDefinition("GPT", OpenAI, key, "gpt-4o")
+	m.APISettings.APIEndpoint = "https://example.com/v1/chat/completions?key=" + key
+	if _, last := respondOnce(t, client, m); last.Err != nil {
+		t.Fatal(last.Err)
+	}
+
+	logged := buf.String()
+	for _, want := range []string{"request started", "sending request", "request finished", "model=GPT", "events=1"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("the log does not contain %q:\n%s", want, logged)
+		}
+	}
+	for _, secret := range []string{key, "Say hello."} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("the log contains %q:\n%s", secret, logged)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package multi_ai_client
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"net/http"
 )
 
@@ -13,6 +14,33 @@ type ModelDefinition struct {
 	Name          string
 	APISettings   APISettings
 	ModelSettings ModelSettings
+
+	// ContextWindow is the maximum amount of tokens the model accepts, prompt
+	// and response combined. If it is set, the oldest messages of the chat are
+	// dropped when the chat would not fit otherwise.
+	ContextWindow int
+
+	// ReservedOutputTokens is the amount of tokens of the context window kept
+	// free for the response, also used as the estimated output when checking
+	// budgets. If it is 0, the max_tokens setting is used.
+	ReservedOutputTokens int
+
+	// Tokenizer is used to estimate the size of the chat. If it is nil, one is
+	// picked based on the API type and model name.
+	Tokenizer Tokenizer
+
+	// Pricing is the price of the model. If it is nil, the price is looked up
+	// by model name in the pricing table of the client.
+	Pricing *Pricing
+
+	// Transport is used to send the requests of this model instead of the
+	// transport of the HTTP client of the client, for example to answer them
+	// with the provider of the fake package.
+	Transport http.RoundTripper
+
+	// Tags are free-form labels of the model definition, such as "fast" or
+	// "local", for applications to select model definitions by.
+	Tags []string
 }
 
 // NewModelDefinition creates a new ModelDefinition with the given name, API type, API key, and model name.
@@ -29,7 +57,16 @@ func NewModelDefinition(name string, apiType APIType, apiKey string, modelName s
 	}
 }
 
+// CreateRequest creates the request sending the chat to the model.
+// The API key is resolved from the API settings when the request is created.
 func (m *ModelDefinition) CreateRequest(chat Chat) (*http.Request, error) {
+	request, _, err := m.createRequest(chat)
+	return request, err
+}
+
+// createRequest functions like CreateRequest, but also returns the API key the
+// request is sent with.
+func (m *ModelDefinition) createRequest(chat Chat) (*http.Request, string, error) {
 	url := ""
 	if m.APISettings.APIEndpoint != "" {
 		url = m.APISettings.APIEndpoint
@@ -42,24 +79,46 @@ func (m *ModelDefinition) CreateRequest(chat Chat) (*http.Request, error) {
 		case Anthropic:
 			url = "https://api.anthropic.com/v1/messages"
 		default:
-			return nil, errors.New("invalid API type")
+			return nil, "", errors.New("invalid API type")
 		}
 	}
-	request, err := http.NewRequest("POST", url, bytes.NewBuffer(m.ModelSettings.MakeBody(chat)))
+	key, err := m.APISettings.GetAPIKey()
 	if err != nil {
-		return nil, err
+		return nil, "", fmt.Errorf("%s: could not get API key: %w", m.Name, err)
+	}
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(m.ModelSettings.MakeBody(m.FitChat(chat))))
+	if err != nil {
+		return nil, "", err
 	}
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	if m.APISettings.APIType == Anthropic {
 		request.Header.Set("anthropic-version", "2023-06-01")
 	}
-	if m.APISettings.APIKey != "" {
-		if m.APISettings.APIType == Anthropic {
-			request.Header.Set("x-api-key", m.APISettings.APIKey)
-		} else {
-			request.Header.Set("Authorization", "Bearer "+m.APISettings.APIKey)
-		}
+	setAPIKey(request, m.APISettings.APIType, key)
+	return request, key, nil
+}
+
+// Clone returns a copy of the model definition whose settings can be changed
+// without changing the original.
+func (m ModelDefinition) Clone() ModelDefinition {
+	m.ModelSettings = CloneModelSettings(m.ModelSettings)
+	m.Tags = append([]string(nil), m.Tags...)
+	if m.Pricing != nil {
+		pricing := *m.Pricing
+		m.Pricing = &pricing
+	}
+	return m
+}
+
+// GetPricing returns the price of the model, from its own pricing if set, or
+// else from the given pricing table.
+func (m *ModelDefinition) GetPricing(table *PricingTable) (Pricing, bool) {
+	if m.Pricing != nil {
+		return *m.Pricing, true
+	}
+	if table == nil {
+		return Pricing{}, false
 	}
-	return request, nil
+	return table.Get(modelNameOf(m.ModelSettings))
 }
@@ -5,11 +5,13 @@ import (
 	"errors"
 	"github.com/guregu/null/v5"
 	"github.com/icza/dyno"
+	"math"
 	"slices"
 )
 
 // ModelSettings is an interface representing the settings needed to interact
-// with a model.
+// with a model. It is implemented by pointers to the settings structs, so Set
+// changes the settings in place.
 type ModelSettings interface {
 	// MakeBody creates the body of the request to the model API.
 	MakeBody(chat Chat) []byte
@@ -18,6 +20,9 @@ type ModelSettings interface {
 	// The available keys are specific to the model settings implementation.
 	// If the key is not valid, an error is returned.
 	// If the value may not be nilled and a nil value is passed, an error is returned.
+	// If the value does not have the listed type, an error is returned. Numbers
+	// may be given as any integer or float type, as long as integers have no
+	// fraction, and lists and maps as decoded from JSON.
 	//
 	// For OpenAI, the valid keys are:
 	//  - model                 (required, any valid model name as string)
@@ -33,6 +38,8 @@ type ModelSettings interface {
 	//  - temperature			(float64, [0.0; 2.0])
 	//  - top_p					(float64, [0.0; 1.0])
 	//  - user					(string)
+	//  - prefill_strategy		(string, "system_instruction", "user_instruction" or "as_is")
+	//  - prefill_instruction	(string, {prefill} is replaced by the final assistant message)
 	//
 	// For Mistral, the valid keys are:
 	//  - model                 (required, any valid model name as string)
@@ -69,16 +76,24 @@ type ModelSettingsOpenAI struct {
 	Seed             null.Int              `json:"seed,omitempty"`
 	Stop             []string              `json:"stop,omitempty"`
 	Stream           null.Bool             `json:"stream,omitempty"`
+	StreamOptions    *OpenAIStreamOptions  `json:"stream_options,omitempty"`
 	Temperature      null.Float            `json:"temperature,omitempty"`
 	TopP             null.Float            `json:"top_p,omitempty"`
 	User             null.String           `json:"user,omitempty"`
 	Messages         []JsonMessage         `json:"messages"`
+
+	// PrefillStrategy determines how a final assistant message is sent.
+	PrefillStrategy OpenAIPrefillStrategy `json:"-"`
+	// PrefillInstruction is the instruction used to emulate prefill. Any
+	// occurrence of {prefill} is replaced by the text of the final assistant
+	// message. If it is empty, DefaultPrefillInstruction is used.
+	PrefillInstruction string `json:"-"`
 	// Tools            []OpenAITool          `json:"tools,omitempty"`
 	// ToolChoice       *OpenAIToolChoice     `json:"tool_choice,omitempty"`
 }
 
 func (m ModelSettingsOpenAI) MakeBody(chat Chat) []byte {
-	messages := chat.GetMessages()
+	messages := emulatePrefill(chat.normalizedMessages(OpenAI, true), m.PrefillStrategy, m.PrefillInstruction)
 	if len(messages) > 0 {
 		m.Messages = make([]JsonMessage, 0, len(messages))
 		for _, message := range messages {
@@ -88,12 +103,13 @@ func (m ModelSettingsOpenAI) MakeBody(chat Chat) []byte {
 		m.Messages = nil
 	}
 	m.Stream = null.BoolFrom(true)
+	m.StreamOptions = &OpenAIStreamOptions{IncludeUsage: true}
 	body, _ := json.Marshal(m)
 	return body
 }
 
-func (m ModelSettingsOpenAI) Set(key string, value interface{}) error {
-	keys := []string{"model", "frequency_penalty", "logit_bias", "logprobs", "top_logprobs", "max_tokens", "presence_penalty", "response_format", "seed", "stop", "temperature", "top_p", "user"}
+func (m *ModelSettingsOpenAI) Set(key string, value interface{}) error {
+	keys := []string{"model", "frequency_penalty", "logit_bias", "logprobs", "top_logprobs", "max_tokens", "presence_penalty", "response_format", "seed", "stop", "temperature", "top_p", "user", "prefill_strategy", "prefill_instruction"}
 	if !slices.Contains(keys, key) {
 		return errors.New("invalid key")
 	}
@@ -102,78 +118,154 @@ func (m ModelSettingsOpenAI) Set(key string, value interface{}) error {
 		if value == nil {
 			return errors.New("value may not be nil")
 		}
-		m.Model = value.(string)
+		v, err := toString(value)
+		if err != nil {
+			return err
+		}
+		m.Model = v
 	case "frequency_penalty":
 		if value == nil {
 			m.FrequencyPenalty = null.FloatFromPtr(nil)
 		} else {
-			m.FrequencyPenalty = null.FloatFrom(value.(float64))
+			v, err := toFloat(value)
+			if err != nil {
+				return err
+			}
+			m.FrequencyPenalty = null.FloatFrom(v)
 		}
 	case "logit_bias":
 		if value == nil {
 			m.LogitBias = nil
 		} else {
-			m.LogitBias = value.(map[string]int)
+			v, err := toIntMap(value)
+			if err != nil {
+				return err
+			}
+			m.LogitBias = v
 		}
 	case "logprobs":
 		if value == nil {
 			m.Logprobs = null.BoolFromPtr(nil)
 		} else {
-			m.Logprobs = null.BoolFrom(value.(bool))
+			v, err := toBool(value)
+			if err != nil {
+				return err
+			}
+			m.Logprobs = null.BoolFrom(v)
 		}
 	case "top_logprobs":
 		if value == nil {
 			m.TopLogprobs = null.IntFromPtr(nil)
 		} else {
-			m.TopLogprobs = null.IntFrom(int64(value.(int)))
+			v, err := toInt(value)
+			if err != nil {
+				return err
+			}
+			m.TopLogprobs = null.IntFrom(int64(v))
 		}
 	case "max_tokens":
 		if value == nil {
 			m.MaxTokens = null.IntFromPtr(nil)
 		} else {
-			m.MaxTokens = null.IntFrom(int64(value.(int)))
+			v, err := toInt(value)
+			if err != nil {
+				return err
+			}
+			m.MaxTokens = null.IntFrom(int64(v))
 		}
 	case "presence_penalty":
 		if value == nil {
 			m.PresencePenalty = null.FloatFromPtr(nil)
 		} else {
-			m.PresencePenalty = null.FloatFrom(value.(float64))
+			v, err := toFloat(value)
+			if err != nil {
+				return err
+			}
+			m.PresencePenalty = null.FloatFrom(v)
 		}
 	case "seed":
 		if value == nil {
 			m.Seed = null.IntFromPtr(nil)
 		} else {
-			m.Seed = null.IntFrom(int64(value.(int)))
+			v, err := toInt(value)
+			if err != nil {
+				return err
+			}
+			m.Seed = null.IntFrom(int64(v))
 		}
 	case "stop":
 		if value == nil {
 			m.Stop = nil
 		} else {
-			m.Stop = value.([]string)
+			v, err := toStrings(value)
+			if err != nil {
+				return err
+			}
+			m.Stop = v
 		}
 	case "temperature":
 		if value == nil {
 			m.Temperature = null.FloatFromPtr(nil)
 		} else {
-			m.Temperature = null.FloatFrom(value.(float64))
+			v, err := toFloat(value)
+			if err != nil {
+				return err
+			}
+			m.Temperature = null.FloatFrom(v)
 		}
 	case "top_p":
 		if value == nil {
 			m.TopP = null.FloatFromPtr(nil)
 		} else {
-			m.TopP = null.FloatFrom(value.(float64))
+			v, err := toFloat(value)
+			if err != nil {
+				return err
+			}
+			m.TopP = null.FloatFrom(v)
 		}
 	case "user":
 		if value == nil {
 			m.User = null.StringFromPtr(nil)
 		} else {
-			m.User = null.StringFrom(value.(string))
+			v, err := toString(value)
+			if err != nil {
+				return err
+			}
+			m.User = null.StringFrom(v)
 		}
 	case "response_format":
 		if value == nil {
 			m.ResponseFormat = nil
 		} else {
-			m.ResponseFormat = &OpenAIResponseFormat{Type: value.(string)}
+			v, err := toString(value)
+			if err != nil {
+				return err
+			}
+			m.ResponseFormat = &OpenAIResponseFormat{Type: v}
+		}
+	case "prefill_strategy":
+		if value == nil {
+			m.PrefillStrategy = PrefillAsSystemInstruction
+		} else {
+			v, err := toString(value)
+			if err != nil {
+				return err
+			}
+			strategy, err := ParseOpenAIPrefillStrategy(v)
+			if err != nil {
+				return err
+			}
+			m.PrefillStrategy = strategy
+		}
+	case "prefill_instruction":
+		if value == nil {
+			m.PrefillInstruction = ""
+		} else {
+			v, err := toString(value)
+			if err != nil {
+				return err
+			}
+			m.PrefillInstruction = v
 		}
 	}
 	return nil
@@ -195,12 +287,16 @@ type ModelSettingsMistral struct {
 }
 
 func (m ModelSettingsMistral) MakeBody(chat Chat) []byte {
-	messages := chat.GetMessages()
+	messages := chat.normalizedMessages(Mistral, true)
 	if len(messages) > 0 {
 		m.Messages = make([]JsonMessage, 0, len(messages))
 		for _, message := range messages {
 			m.Messages = append(m.Messages, NewJsonMessageFromMessage(message))
 		}
+		// A final assistant message is a prefix the response has to start with.
+		if last := &m.Messages[len(m.Messages)-1]; last.Role == "assistant" {
+			last.Prefix = true
+		}
 	} else {
 		m.Messages = nil
 	}
@@ -218,7 +314,7 @@ func (m ModelSettingsMistral) MakeBody(chat Chat) []byte {
 	if err != nil {
 		_ = dyno.Delete(altered, "top_p")
 	}
-	_, err = dyno.GetInt(altered, "max_tokens")
+	_, err = dyno.GetInteger(altered, "max_tokens")
 	if err != nil {
 		_ = dyno.Delete(altered, "max_tokens")
 	}
@@ -226,7 +322,7 @@ func (m ModelSettingsMistral) MakeBody(chat Chat) []byte {
 	if err != nil {
 		_ = dyno.Delete(altered, "safe_prompt")
 	}
-	_, err = dyno.GetInt(altered, "random_seed")
+	_, err = dyno.GetInteger(altered, "random_seed")
 	if err != nil {
 		_ = dyno.Delete(altered, "random_seed")
 	}
@@ -234,7 +330,7 @@ func (m ModelSettingsMistral) MakeBody(chat Chat) []byte {
 	return body
 }
 
-func (m ModelSettingsMistral) Set(key string, value interface{}) error {
+func (m *ModelSettingsMistral) Set(key string, value interface{}) error {
 	keys := []string{"model", "response_format", "temperature", "top_p", "max_tokens", "safe_prompt", "random_seed"}
 	if !slices.Contains(keys, key) {
 		return errors.New("invalid key")
@@ -244,42 +340,70 @@ func (m ModelSettingsMistral) Set(key string, value interface{}) error {
 		if value == nil {
 			return errors.New("value may not be nil")
 		}
-		m.Model = value.(string)
+		v, err := toString(value)
+		if err != nil {
+			return err
+		}
+		m.Model = v
 	case "response_format":
 		if value == nil {
 			m.ResponseFormat = nil
 		} else {
-			m.ResponseFormat = &MistralResponseFormat{Type: value.(string)}
+			v, err := toString(value)
+			if err != nil {
+				return err
+			}
+			m.ResponseFormat = &MistralResponseFormat{Type: v}
 		}
 	case "temperature":
 		if value == nil {
 			m.Temperature = null.FloatFromPtr(nil)
 		} else {
-			m.Temperature = null.FloatFrom(value.(float64))
+			v, err := toFloat(value)
+			if err != nil {
+				return err
+			}
+			m.Temperature = null.FloatFrom(v)
 		}
 	case "top_p":
 		if value == nil {
 			m.TopP = null.FloatFromPtr(nil)
 		} else {
-			m.TopP = null.FloatFrom(value.(float64))
+			v, err := toFloat(value)
+			if err != nil {
+				return err
+			}
+			m.TopP = null.FloatFrom(v)
 		}
 	case "max_tokens":
 		if value == nil {
 			m.MaxTokens = null.IntFromPtr(nil)
 		} else {
-			m.MaxTokens = null.IntFrom(int64(value.(int)))
+			v, err := toInt(value)
+			if err != nil {
+				return err
+			}
+			m.MaxTokens = null.IntFrom(int64(v))
 		}
 	case "safe_prompt":
 		if value == nil {
 			m.SafePrompt = null.BoolFromPtr(nil)
 		} else {
-			m.SafePrompt = null.BoolFrom(value.(bool))
+			v, err := toBool(value)
+			if err != nil {
+				return err
+			}
+			m.SafePrompt = null.BoolFrom(v)
 		}
 	case "random_seed":
 		if value == nil {
 			m.RandomSeed = null.IntFromPtr(nil)
 		} else {
-			m.RandomSeed = null.IntFrom(int64(value.(int)))
+			v, err := toInt(value)
+			if err != nil {
+				return err
+			}
+			m.RandomSeed = null.IntFrom(int64(v))
 		}
 	}
 	return nil
@@ -301,13 +425,13 @@ type ModelSettingsAnthropic struct {
 }
 
 func (m ModelSettingsAnthropic) MakeBody(chat Chat) []byte {
-	if chat.GetSystemMessage() != "" {
-		m.System = null.StringFrom(chat.GetSystemMessage())
+	if system := chat.effectiveSystemMessage(); system != "" {
+		m.System = null.StringFrom(system)
 	} else {
 		m.System = null.StringFromPtr(nil)
 	}
 
-	messages := chat.GetMessagesWithoutSystemMessage()
+	messages := chat.normalizedMessages(Anthropic, false)
 	if len(messages) > 0 {
 		m.Messages = make([]JsonMessage, 0, len(messages))
 		for _, message := range messages {
@@ -334,7 +458,7 @@ func (m ModelSettingsAnthropic) MakeBody(chat Chat) []byte {
 	if err != nil {
 		_ = dyno.Delete(altered, "top_p")
 	}
-	_, err = dyno.GetInt(altered, "top_k")
+	_, err = dyno.GetInteger(altered, "top_k")
 	if err != nil {
 		_ = dyno.Delete(altered, "top_k")
 	}
@@ -346,7 +470,7 @@ func (m ModelSettingsAnthropic) MakeBody(chat Chat) []byte {
 	return body
 }
 
-func (m ModelSettingsAnthropic) Set(key string, value interface{}) error {
+func (m *ModelSettingsAnthropic) Set(key string, value interface{}) error {
 	keys := []string{"model", "max_tokens", "metadata", "stop_sequences", "temperature", "top_k", "top_p"}
 	if !slices.Contains(keys, key) {
 		return errors.New("invalid key")
@@ -357,41 +481,69 @@ func (m ModelSettingsAnthropic) Set(key string, value interface{}) error {
 		if value == nil {
 			return errors.New("value may not be nil")
 		}
-		m.Model = value.(string)
+		v, err := toString(value)
+		if err != nil {
+			return err
+		}
+		m.Model = v
 	case "max_tokens":
 		if value == nil {
 			return errors.New("value may not be nil")
 		}
-		m.MaxTokens = value.(int)
+		v, err := toInt(value)
+		if err != nil {
+			return err
+		}
+		m.MaxTokens = v
 	case "metadata", "user_id":
 		if value == nil {
 			m.Metadata = nil
 		} else {
-			m.Metadata = &AnthropicMetadata{UserID: value.(string)}
+			v, err := toString(value)
+			if err != nil {
+				return err
+			}
+			m.Metadata = &AnthropicMetadata{UserID: v}
 		}
 	case "stop_sequences":
 		if value == nil {
 			m.StopSequences = nil
 		} else {
-			m.StopSequences = value.([]string)
+			v, err := toStrings(value)
+			if err != nil {
+				return err
+			}
+			m.StopSequences = v
 		}
 	case "temperature":
 		if value == nil {
 			m.Temperature = null.FloatFromPtr(nil)
 		} else {
-			m.Temperature = null.FloatFrom(value.(float64))
+			v, err := toFloat(value)
+			if err != nil {
+				return err
+			}
+			m.Temperature = null.FloatFrom(v)
 		}
 	case "top_k":
 		if value == nil {
 			m.TopK = null.IntFromPtr(nil)
 		} else {
-			m.TopK = null.IntFrom(int64(value.(int)))
+			v, err := toInt(value)
+			if err != nil {
+				return err
+			}
+			m.TopK = null.IntFrom(int64(v))
 		}
 	case "top_p":
 		if value == nil {
 			m.TopP = null.FloatFromPtr(nil)
 		} else {
-			m.TopP = null.FloatFrom(value.(float64))
+			v, err := toFloat(value)
+			if err != nil {
+				return err
+			}
+			m.TopP = null.FloatFrom(v)
 		}
 	}
 	return nil
@@ -401,6 +553,10 @@ type OpenAIResponseFormat struct {
 	Type string `json:"type"`
 }
 
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
 type MistralResponseFormat struct {
 	Type string `json:"type"`
 }
@@ -412,6 +568,7 @@ type AnthropicMetadata struct {
 type JsonMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	Prefix  bool   `json:"prefix,omitempty"`
 }
 
 func NewJsonMessageFromMessage(message Message) JsonMessage {
@@ -433,18 +590,175 @@ func NewJsonMessageFromMessage(message Message) JsonMessage {
 func NewModelSettings(apiType APIType, modelName string) ModelSettings {
 	switch apiType {
 	case OpenAI:
-		return ModelSettingsOpenAI{
+		return &ModelSettingsOpenAI{
 			Model: modelName,
 		}
 	case Mistral:
-		return ModelSettingsMistral{
+		return &ModelSettingsMistral{
 			Model: modelName,
 		}
 	case Anthropic:
-		return ModelSettingsAnthropic{
+		return &ModelSettingsAnthropic{
 			Model: modelName,
 		}
 	default:
 		return nil
 	}
 }
+
+// CloneModelSettings returns a copy of the model settings, which can be changed
+// with Set without changing the original.
+func CloneModelSettings(settings ModelSettings) ModelSettings {
+	switch s := settings.(type) {
+	case *ModelSettingsOpenAI:
+		clone := *s
+		if s.LogitBias != nil {
+			clone.LogitBias = make(map[string]int, len(s.LogitBias))
+			for k, v := range s.LogitBias {
+				clone.LogitBias[k] = v
+			}
+		}
+		if s.ResponseFormat != nil {
+			format := *s.ResponseFormat
+			clone.ResponseFormat = &format
+		}
+		if s.StreamOptions != nil {
+			options := *s.StreamOptions
+			clone.StreamOptions = &options
+		}
+		clone.Stop = slices.Clone(s.Stop)
+		clone.Messages = nil
+		return &clone
+	case *ModelSettingsMistral:
+		clone := *s
+		if s.ResponseFormat != nil {
+			format := *s.ResponseFormat
+			clone.ResponseFormat = &format
+		}
+		clone.Messages = nil
+		return &clone
+	case *ModelSettingsAnthropic:
+		clone := *s
+		if s.Metadata != nil {
+			metadata := *s.Metadata
+			clone.Metadata = &metadata
+		}
+		clone.StopSequences = slices.Clone(s.StopSequences)
+		clone.Messages = nil
+		return &clone
+	default:
+		return settings
+	}
+}
+
+// toString converts the value of a setting to a string.
+func toString(value interface{}) (string, error) {
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return "", errors.New("value must be " + settingString.String())
+}
+
+// toFloat converts the value of a setting to a float64. Integers are
+// accepted as well.
+func toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case float32:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	}
+	if i, err := toInt(value); err == nil {
+		return float64(i), nil
+	}
+	return 0, errors.New("value must be " + settingFloat.String())
+}
+
+// toInt converts the value of a setting to an int. Floats without a
+// fraction are accepted as well, as decoding JSON into interface{} produces
+// them for all numbers.
+func toInt(value interface{}) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int8:
+		return int(v), nil
+	case int16:
+		return int(v), nil
+	case int32:
+		return int(v), nil
+	case int64:
+		return int(v), nil
+	case uint:
+		return int(v), nil
+	case uint8:
+		return int(v), nil
+	case uint16:
+		return int(v), nil
+	case uint32:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
+	case float64:
+		if v == math.Trunc(v) && !math.IsInf(v, 0) {
+			return int(v), nil
+		}
+	case float32:
+		if float64(v) == math.Trunc(float64(v)) && !math.IsInf(float64(v), 0) {
+			return int(v), nil
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return int(i), nil
+		}
+	}
+	return 0, errors.New("value must be " + settingInt.String())
+}
+
+// toBool converts the value of a setting to a bool.
+func toBool(value interface{}) (bool, error) {
+	if b, ok := value.(bool); ok {
+		return b, nil
+	}
+	return false, errors.New("value must be " + settingBool.String())
+}
+
+// toStrings converts the value of a setting to a list of strings.
+func toStrings(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case []string:
+		return v, nil
+	case []interface{}:
+		list := make([]string, len(v))
+		for i, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, errors.New("value must be " + settingStrings.String())
+			}
+			list[i] = s
+		}
+		return list, nil
+	}
+	return nil, errors.New("value must be " + settingStrings.String())
+}
+
+// toIntMap converts the value of a setting to a map of integers.
+func toIntMap(value interface{}) (map[string]int, error) {
+	switch v := value.(type) {
+	case map[string]int:
+		return v, nil
+	case map[string]interface{}:
+		m := make(map[string]int, len(v))
+		for key, item := range v {
+			i, err := toInt(item)
+			if err != nil {
+				return nil, errors.New("value must be " + settingIntMap.String())
+			}
+			m[key] = i
+		}
+		return m, nil
+	}
+	return nil, errors.New("value must be " + settingIntMap.String())
+}
@@ -0,0 +1,36 @@
+package multi_ai_client
+
+import "context"
+
+// Observer is an interface for instrumentation that observes the responses
+// created by a client, such as tracing or metrics.
+//
+// The methods are called from the goroutines of the requests, so they must be
+// safe for concurrent use. Observers are called in the order they were added.
+type Observer interface {
+	// ResponseStarted is called when CreateResponseContext starts the requests
+	// for all model definitions. The returned context is passed on to the
+	// requests.
+	ResponseStarted(ctx context.Context, chat Chat, modelDefinitions []ModelDefinition) context.Context
+
+	// RequestStarted is called before the request of a single model is sent,
+	// before any middleware runs. The returned context is used for the
+	// request.
+	RequestStarted(ctx context.Context, exchange *Exchange) context.Context
+
+	// FirstToken is called when the first text of a response arrives.
+	FirstToken(ctx context.Context, exchange *Exchange)
+
+	// RequestFinished is called with the final chunk of a single model, which
+	// carries either the usage of the response or the error it failed with.
+	RequestFinished(ctx context.Context, exchange *Exchange, chunk MessageChunk)
+
+	// ResponseFinished is called once the requests of all model definitions
+	// finished.
+	ResponseFinished(ctx context.Context)
+}
+
+// Observe adds observers to the client.
+func (c *Client) Observe(observers ...Observer) {
+	c.observers = append(c.observers, observers...)
+}
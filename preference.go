@@ -0,0 +1,170 @@
+package multi_ai_client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Preference is a struct representing the choice of one response among the
+// responses of several models to the same chat. Every choice tells that the
+// winner was preferred over each of the other candidates.
+type Preference struct {
+	Time time.Time `json:"time"`
+	// PromptHash is the hash of the chat the candidates answer, as returned by
+	// PromptHash. Choices for the same prompt share it.
+	PromptHash string `json:"prompt_hash"`
+	// Models are the names of the model definitions of the candidates.
+	Models []string `json:"models"`
+	// Candidates are the texts of the responses, in the order of Models.
+	Candidates []string `json:"candidates"`
+	// Winner is the index of the chosen candidate.
+	Winner int `json:"winner"`
+	// User is who made the choice, if known.
+	User string `json:"user,omitempty"`
+}
+
+// PromptHash returns a hash of the messages of the chat as they are sent to
+// the models.
+func PromptHash(chat Chat) string {
+	h := sha256.New()
+	for _, m := range chat.requestMessages(true) {
+		h.Write([]byte(strconv.Itoa(int(m.Type))))
+		h.Write([]byte{0})
+		h.Write([]byte(m.Text))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// NewPreference creates a Preference for the choice of the candidate at index
+// winner among the candidates answering the chat, which were written by the
+// models with the given names.
+func NewPreference(chat Chat, models []string, candidates []string, winner int) (Preference, error) {
+	if len(models) != len(candidates) {
+		return Preference{}, errors.New("every candidate needs the name of its model")
+	}
+	if len(candidates) < 2 {
+		return Preference{}, errors.New("a preference needs at least two candidates")
+	}
+	if winner < 0 || winner >= len(candidates) {
+		return Preference{}, errors.New("winner is not one of the candidates")
+	}
+	return Preference{
+		Time:       time.Now().UTC(),
+		PromptHash: PromptHash(chat),
+		Models:     append([]string(nil), models...),
+		Candidates: append([]string(nil), candidates...),
+		Winner:     winner,
+	}, nil
+}
+
+// PreferenceStore is an interface representing the storage of preferences.
+type PreferenceStore interface {
+	// Add stores a preference.
+	Add(preference Preference) error
+
+	// All returns all stored preferences, in the order they were added.
+	All() ([]Preference, error)
+}
+
+// RecordPreference stores the preference in the preference store of the
+// client. It does nothing if the client has no preference store.
+func (c *Client) RecordPreference(preference Preference) error {
+	if c.Preferences == nil {
+		return nil
+	}
+	return c.Preferences.Add(preference)
+}
+
+// MemoryPreferenceStore is a PreferenceStore that keeps preferences in
+// memory. It is safe for concurrent use.
+type MemoryPreferenceStore struct {
+	mu          sync.Mutex
+	preferences []Preference
+}
+
+// NewMemoryPreferenceStore creates a new, empty MemoryPreferenceStore.
+func NewMemoryPreferenceStore() *MemoryPreferenceStore {
+	return &MemoryPreferenceStore{}
+}
+
+func (s *MemoryPreferenceStore) Add(preference Preference) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preferences = append(s.preferences, preference)
+	return nil
+}
+
+func (s *MemoryPreferenceStore) All() ([]Preference, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Preference(nil), s.preferences...), nil
+}
+
+// FilePreferenceStore is a PreferenceStore that appends preferences to a file
+// as JSON lines. Every preference is written at once to the end of the file,
+// so several processes can share it.
+type FilePreferenceStore struct {
+	// Path is the path of the file. It is created if it does not exist.
+	Path string
+
+	mu sync.Mutex
+}
+
+// NewFilePreferenceStore creates a new FilePreferenceStore using the file at
+// path.
+func NewFilePreferenceStore(path string) *FilePreferenceStore {
+	return &FilePreferenceStore{Path: path}
+}
+
+func (s *FilePreferenceStore) Add(preference Preference) error {
+	data, err := json.Marshal(preference)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *FilePreferenceStore) All() ([]Preference, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.Open(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var preferences []Preference
+	decoder := json.NewDecoder(f)
+	for {
+		var preference Preference
+		err := decoder.Decode(&preference)
+		if err == io.EOF {
+			return preferences, nil
+		}
+		if err != nil {
+			return nil, errors.New(s.Path + ": " + err.Error())
+		}
+		preferences = append(preferences, preference)
+	}
+}
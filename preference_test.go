@@ -0,0 +1,118 @@
+package multi_ai_client
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewPreference(t *testing.T) {
+	chat := Chat{}
+	chat.SetSystemMessage("Be brief.")
+	chat.AddUserMessage("Hi!")
+	models := []string{"A", "B"}
+	candidates := []string{"Hello.", "Hi."}
+	preference, err := NewPreference(chat, models, candidates, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preference.PromptHash != PromptHash(chat) || preference.Winner != 1 || preference.Time.IsZero() {
+		t.Errorf("preference %+v", preference)
+	}
+	models[0], candidates[0] = "changed", "changed"
+	if preference.Models[0] != "A" || preference.Candidates[0] != "Hello." {
+		t.Error("the preference shares the slices of the caller")
+	}
+
+	invalid := []struct {
+		name       string
+		models     []string
+		candidates []string
+		winner     int
+	}{
+		{"missing model", []string{"A"}, []string{"Hello.", "Hi."}, 0},
+		{"single candidate", []string{"A"}, []string{"Hello."}, 0},
+		{"negative winner", []string{"A", "B"}, []string{"Hello.", "Hi."}, -1},
+		{"winner past end", []string{"A", "B"}, []string{"Hello.", "Hi."}, 2},
+	}
+	for _, test := range invalid {
+		if _, err := NewPreference(chat, test.models, test.candidates, test.winner); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestPromptHash(t *testing.T) {
+	chat := Chat{}
+	chat.AddUserMessage("Hi!")
+	same := Chat{}
+	same.AddUserMessage("Hi!")
+	withSystem := Chat{}
+	withSystem.SetSystemMessage("Hi!")
+	asAssistant := Chat{}
+	asAssistant.AddAssistantMessage("Hi!")
+	if PromptHash(chat) != PromptHash(same) {
+		t.Error("the same chat has different hashes")
+	}
+	if PromptHash(chat) == PromptHash(withSystem) || PromptHash(chat) == PromptHash(asAssistant) {
+		t.Error("the hash does not depend on the roles of the messages")
+	}
+}
+
+func TestFilePreferenceStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "preferences.jsonl")
+	store := NewFilePreferenceStore(path)
+	if preferences, err := store.All(); err != nil || len(preferences) != 0 {
+		t.Fatalf("preferences %v and error %v before any was added", preferences, err)
+	}
+
+	chat := Chat{}
+	chat.AddUserMessage("Hi!")
+	first, _ := NewPreference(chat, []string{"A", "B"}, []string{"Hello.", "Hi."}, 0)
+	second, _ := NewPreference(chat, []string{"B", "C", "A"}, []string{"Hey.", "Hello\nthere.", ""}, 2)
+	second.User = "ci"
+	for _, p := range []Preference{first, second} {
+		if err := store.Add(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Another store of the same file reads what the first one wrote.
+	preferences, err := NewFilePreferenceStore(path).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(preferences, []Preference{first, second}) {
+		t.Errorf("preferences %+v, want %+v", preferences, []Preference{first, second})
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("%d lines, want one line per preference", lines)
+	}
+
+	if err := os.WriteFile(path, append(data, "{\"winner\": \n"...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.All(); err == nil {
+		t.Error("read a corrupt file without error")
+	}
+}
+
+func TestRecordPreference(t *testing.T) {
+	client := &Client{}
+	if err := client.RecordPreference(Preference{}); err != nil {
+		t.Errorf("recording without a store: %v", err)
+	}
+	store := NewMemoryPreferenceStore()
+	client.Preferences = store
+	if err := client.RecordPreference(Preference{Models: []string{"A", "B"}}); err != nil {
+		t.Fatal(err)
+	}
+	preferences, _ := store.All()
+	preferences[0].Models = nil
+	if again, _ := store.All(); len(again) != 1 || again[0].Models == nil {
+		t.Errorf("preferences %+v, want the recorded preference unchanged", again)
+	}
+}
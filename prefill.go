@@ -0,0 +1,174 @@
+package multi_ai_client
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// OpenAIPrefillStrategy is an enum representing how a final assistant message
+// is sent to OpenAI, which does not support prefill natively.
+type OpenAIPrefillStrategy int
+
+const (
+	// PrefillAsSystemInstruction replaces the final assistant message with a
+	// system message instructing the model to continue it.
+	PrefillAsSystemInstruction OpenAIPrefillStrategy = iota
+	// PrefillAsUserInstruction replaces the final assistant message with an
+	// instruction appended to the last user message.
+	PrefillAsUserInstruction
+	// PrefillAsIs sends the final assistant message unchanged. OpenAI treats it
+	// as a finished turn and writes a new response.
+	PrefillAsIs
+)
+
+// DefaultPrefillInstruction is the instruction used to emulate prefill for
+// OpenAI if no other instruction is set.
+const DefaultPrefillInstruction = "Your response must start with the text between the <prefill> tags. " +
+	"It has already been written: do not repeat it, output only what comes directly after it.\n\n" +
+	"<prefill>{prefill}</prefill>"
+
+// ParseOpenAIPrefillStrategy returns the strategy with the given name:
+// "system_instruction", "user_instruction" or "as_is".
+func ParseOpenAIPrefillStrategy(s string) (OpenAIPrefillStrategy, error) {
+	switch s {
+	case "system_instruction":
+		return PrefillAsSystemInstruction, nil
+	case "user_instruction":
+		return PrefillAsUserInstruction, nil
+	case "as_is":
+		return PrefillAsIs, nil
+	default:
+		return 0, errors.New("invalid prefill strategy")
+	}
+}
+
+func (s OpenAIPrefillStrategy) String() string {
+	switch s {
+	case PrefillAsSystemInstruction:
+		return "system_instruction"
+	case PrefillAsUserInstruction:
+		return "user_instruction"
+	case PrefillAsIs:
+		return "as_is"
+	default:
+		return "invalid"
+	}
+}
+
+// isInstruction returns whether the strategy replaces the final assistant
+// message by an instruction. Only then can the response repeat the prefill.
+func (s OpenAIPrefillStrategy) isInstruction() bool {
+	return s == PrefillAsSystemInstruction || s == PrefillAsUserInstruction
+}
+
+// emulatePrefill replaces a final assistant message by an instruction to
+// continue it, according to the given strategy.
+func emulatePrefill(messages []Message, strategy OpenAIPrefillStrategy, instruction string) []Message {
+	if !strategy.isInstruction() || len(messages) == 0 || messages[len(messages)-1].Type != AssistantMessage {
+		return messages
+	}
+
+	if instruction == "" {
+		instruction = DefaultPrefillInstruction
+	}
+	prefill := messages[len(messages)-1].Text
+	instruction = strings.ReplaceAll(instruction, "{prefill}", prefill)
+	emulated := append(make([]Message, 0, len(messages)), messages[:len(messages)-1]...)
+
+	if strategy == PrefillAsUserInstruction {
+		for i := len(emulated) - 1; i >= 0; i-- {
+			if emulated[i].Type == UserMessage {
+				emulated[i].Text += "\n\n" + instruction
+				return emulated
+			}
+		}
+		return append(emulated, *NewUserMessage(instruction))
+	}
+	return append(emulated, *NewSystemMessage(instruction))
+}
+
+// prefillFilter rewrites the deltas of a response to a prefilled chat, so that
+// the prefill followed by all deltas is the full assistant message, regardless
+// of the provider.
+type prefillFilter struct {
+	prefill string
+
+	// stripEcho is set when the response may repeat the prefill.
+	stripEcho bool
+	echoDone  bool
+	buffer    string
+
+	// trimSpace is set when the prefill ended in whitespace that was not sent,
+	// so the response may start with whitespace of its own.
+	trimSpace bool
+}
+
+// newPrefillFilter returns the filter for a response of this model to the
+// given chat, or nil if the deltas can be used as they are.
+func (m *ModelDefinition) newPrefillFilter(chat Chat) *prefillFilter {
+	messages := chat.GetMessagesWithoutSystemMessage()
+	if len(messages) == 0 || messages[len(messages)-1].Type != AssistantMessage || messages[len(messages)-1].Text == "" {
+		return nil
+	}
+
+	prefill := messages[len(messages)-1].Text
+	endsInSpace := prefill != strings.TrimRightFunc(prefill, unicode.IsSpace)
+	switch m.APISettings.APIType {
+	case Anthropic:
+		// The prefill is sent without its trailing whitespace.
+		if !endsInSpace {
+			return nil
+		}
+		return &prefillFilter{prefill: prefill, trimSpace: true}
+	case Mistral:
+		// Mistral repeats the prefix at the start of the response.
+		return &prefillFilter{prefill: prefill, stripEcho: true}
+	case OpenAI:
+		// Only a model instructed to continue the prefill may repeat it. Sent
+		// as is, the prefill is a finished turn and the response is a new one.
+		if !prefillStrategyOf(m.ModelSettings).isInstruction() {
+			return nil
+		}
+		return &prefillFilter{prefill: prefill, stripEcho: true, trimSpace: endsInSpace}
+	default:
+		return nil
+	}
+}
+
+// Write takes the next delta of the response and returns the text that should
+// be reported in its place, which may be empty.
+// While the response could still be repeating the prefill, deltas are held
+// back. A response that ends while repeating the prefill adds nothing to it.
+func (f *prefillFilter) Write(delta string) string {
+	if f == nil {
+		return delta
+	}
+
+	if f.stripEcho && !f.echoDone {
+		f.buffer += delta
+		if strings.HasPrefix(f.prefill, f.buffer) && len(f.buffer) < len(f.prefill) {
+			return ""
+		}
+		f.echoDone = true
+		delta = strings.TrimPrefix(f.buffer, f.prefill)
+		f.buffer = ""
+	}
+
+	if f.trimSpace {
+		delta = strings.TrimLeftFunc(delta, unicode.IsSpace)
+		if delta != "" {
+			f.trimSpace = false
+		}
+	}
+	return delta
+}
+
+func prefillStrategyOf(settings ModelSettings) OpenAIPrefillStrategy {
+	switch s := settings.(type) {
+	case *ModelSettingsOpenAI:
+		return s.PrefillStrategy
+	default:
+		return PrefillAsIs
+	}
+}
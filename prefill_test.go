@@ -0,0 +1,115 @@
+package multi_ai_client
+
+import (
+	"strings"
+	"testing"
+)
+
+// prefillChat returns a chat ending in an assistant message to continue.
+func prefillChat(prefill string) Chat {
+	chat := Chat{}
+	chat.SetSystemMessage("Be brief.")
+	chat.AddUserMessage("What is the answer?")
+	chat.AddAssistantMessage(prefill)
+	return chat
+}
+
+// filterDeltas runs the deltas through the prefill filter of the model
+// definition and returns the text reported for them.
+func filterDeltas(m ModelDefinition, chat Chat, deltas ...string) string {
+	filter := m.newPrefillFilter(chat)
+	var sb strings.Builder
+	for _, delta := range deltas {
+		sb.WriteString(filter.Write(delta))
+	}
+	return sb.String()
+}
+
+func TestEmulatePrefill(t *testing.T) {
+	chat := prefillChat("The answer is")
+	messages := chat.GetMessages()
+	instruction := "Continue: {prefill}"
+
+	system := emulatePrefill(messages, PrefillAsSystemInstruction, instruction)
+	if len(system) != len(messages) || system[len(system)-1].Type != SystemMessage || system[len(system)-1].Text != "Continue: The answer is" {
+		t.Errorf("system instruction: %+v", system)
+	}
+
+	user := emulatePrefill(messages, PrefillAsUserInstruction, instruction)
+	if len(user) != len(messages)-1 || user[len(user)-1].Type != UserMessage || user[len(user)-1].Text != "What is the answer?\n\nContinue: The answer is" {
+		t.Errorf("user instruction: %+v", user)
+	}
+	if messages[1].Text != "What is the answer?" {
+		t.Error("the user instruction changed the messages of the chat")
+	}
+
+	asIs := emulatePrefill(messages, PrefillAsIs, instruction)
+	if len(asIs) != len(messages) || asIs[len(asIs)-1].Type != AssistantMessage || asIs[len(asIs)-1].Text != "The answer is" {
+		t.Errorf("as is: %+v", asIs)
+	}
+
+	if got := emulatePrefill(messages, PrefillAsSystemInstruction, ""); !strings.Contains(got[len(got)-1].Text, "<prefill>The answer is</prefill>") {
+		t.Errorf("default instruction: %q", got[len(got)-1].Text)
+	}
+}
+
+func TestOpenAIPrefillFilter(t *testing.T) {
+	tests := []struct {
+		strategy OpenAIPrefillStrategy
+		prefill  string
+		deltas   []string
+		want     string
+	}{
+		// The instructed model repeats the prefill, which is stripped.
+		{PrefillAsSystemInstruction, "The answer is", []string{"The ans", "wer is", " 42."}, " 42."},
+		{PrefillAsUserInstruction, "The answer is", []string{"The answer is 42."}, " 42."},
+		// The instructed model continues the prefill right away.
+		{PrefillAsSystemInstruction, "The answer is", []string{" 42", "."}, " 42."},
+		{PrefillAsUserInstruction, "The answer is", []string{" 42."}, " 42."},
+		// Whitespace at the end of the prefill is not repeated by the response.
+		{PrefillAsSystemInstruction, "The answer is ", []string{"The answer is", " 42."}, "42."},
+		{PrefillAsUserInstruction, "The answer is ", []string{" ", "42."}, "42."},
+		// Sent as is, the response is a new turn and is never changed, even if
+		// it starts like the prefill.
+		{PrefillAsIs, "The answer is", []string{"The answer is", " 42."}, "The answer is 42."},
+		{PrefillAsIs, "The answer is ", []string{" Sure."}, " Sure."},
+	}
+	for _, test := range tests {
+		m := NewModelDefinition("GPT", OpenAI, "key", "gpt-4o-mini")
+		m.ModelSettings.(*ModelSettingsOpenAI).PrefillStrategy = test.strategy
+		if got := filterDeltas(m, prefillChat(test.prefill), test.deltas...); got != test.want {
+			t.Errorf("%v, %q, %q: got %q, want %q", test.strategy, test.prefill, test.deltas, got, test.want)
+		}
+	}
+}
+
+func TestPrefillFilter(t *testing.T) {
+	tests := []struct {
+		apiType APIType
+		prefill string
+		deltas  []string
+		want    string
+	}{
+		// Mistral repeats the prefix.
+		{Mistral, "The answer is", []string{"The answer", " is 42."}, " 42."},
+		// Anthropic continues the prefill, sent without trailing whitespace.
+		{Anthropic, "The answer is", []string{" 42."}, " 42."},
+		{Anthropic, "The answer is ", []string{" 42."}, "42."},
+	}
+	for _, test := range tests {
+		m := NewModelDefinition("Model", test.apiType, "key", "model")
+		if got := filterDeltas(m, prefillChat(test.prefill), test.deltas...); got != test.want {
+			t.Errorf("%v, %q, %q: got %q, want %q", test.apiType, test.prefill, test.deltas, got, test.want)
+		}
+	}
+
+	// Without a prefill, the deltas are never changed.
+	chat := Chat{}
+	chat.AddUserMessage("What is the answer?")
+	for _, apiType := range []APIType{OpenAI, Mistral, Anthropic} {
+		m := NewModelDefinition("Model", apiType, "key", "model")
+		if filter := m.newPrefillFilter(chat); filter != nil {
+			t.Errorf("%v: filter %+v for a chat without prefill", apiType, filter)
+		}
+	}
+}
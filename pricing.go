@@ -0,0 +1,175 @@
+package multi_ai_client
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Pricing is a struct representing the price of a model, in dollars per
+// million tokens.
+type Pricing struct {
+	Input       float64 `json:"input" yaml:"input"`
+	Output      float64 `json:"output" yaml:"output"`
+	CachedInput float64 `json:"cached_input,omitempty" yaml:"cached_input,omitempty"`
+	// CacheWrite is the price of input tokens written to the cache, which
+	// Anthropic bills at 1.25 times the input price.
+	CacheWrite float64 `json:"cache_write,omitempty" yaml:"cache_write,omitempty"`
+}
+
+// anthropicCacheWriteFactor is the factor of the input price Anthropic bills
+// cache writes at.
+const anthropicCacheWriteFactor = 1.25
+
+// Cost returns the cost in dollars of the given usage.
+// If no cached input or cache write price is set, those tokens are charged at
+// the regular input price.
+func (p Pricing) Cost(usage Usage) float64 {
+	cachedPrice := p.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = p.Input
+	}
+	writePrice := p.CacheWrite
+	if writePrice == 0 {
+		writePrice = p.Input
+	}
+	cached := min(usage.CachedInputTokens, usage.InputTokens)
+	written := min(usage.CacheWriteInputTokens, usage.InputTokens-cached)
+	return (float64(usage.InputTokens-cached-written)*p.Input +
+		float64(cached)*cachedPrice +
+		float64(written)*writePrice +
+		float64(usage.OutputTokens)*p.Output) / 1_000_000
+}
+
+// PricingTable is a registry of model prices, keyed by model name.
+// It is safe for concurrent use.
+type PricingTable struct {
+	mu     sync.RWMutex
+	prices map[string]Pricing
+}
+
+// NewPricingTable creates a new, empty PricingTable.
+func NewPricingTable() *PricingTable {
+	return &PricingTable{prices: make(map[string]Pricing)}
+}
+
+// DefaultPricingTable creates a new PricingTable filled with the list prices of
+// common models at the time of writing. Prices change, so check them against
+// the pricing pages of the providers and override them where needed.
+func DefaultPricingTable() *PricingTable {
+	t := NewPricingTable()
+	for model, p := range map[string]Pricing{
+		"gpt-3.5-turbo":       {Input: 0.5, Output: 1.5},
+		"gpt-4":               {Input: 30, Output: 60},
+		"gpt-4-turbo":         {Input: 10, Output: 30},
+		"gpt-4-turbo-preview": {Input: 10, Output: 30},
+		"gpt-4o":              {Input: 2.5, Output: 10, CachedInput: 1.25},
+		"gpt-4o-mini":         {Input: 0.15, Output: 0.6, CachedInput: 0.075},
+		"gpt-4.1":             {Input: 2, Output: 8, CachedInput: 0.5},
+		"gpt-4.1-mini":        {Input: 0.4, Output: 1.6, CachedInput: 0.1},
+		"gpt-4.1-nano":        {Input: 0.1, Output: 0.4, CachedInput: 0.025},
+		"o1":                  {Input: 15, Output: 60, CachedInput: 7.5},
+		"o1-mini":             {Input: 1.1, Output: 4.4, CachedInput: 0.55},
+		"o3-mini":             {Input: 1.1, Output: 4.4, CachedInput: 0.55},
+		"claude-3-opus":       {Input: 15, Output: 75, CachedInput: 1.5},
+		"claude-3-sonnet":     {Input: 3, Output: 15, CachedInput: 0.3},
+		"claude-3-haiku":      {Input: 0.25, Output: 1.25, CachedInput: 0.03},
+		"claude-3-5-sonnet":   {Input: 3, Output: 15, CachedInput: 0.3},
+		"claude-3-5-haiku":    {Input: 0.8, Output: 4, CachedInput: 0.08},
+		"claude-3-7-sonnet":   {Input: 3, Output: 15, CachedInput: 0.3},
+		"claude-sonnet-4":     {Input: 3, Output: 15, CachedInput: 0.3},
+		"claude-opus-4":       {Input: 15, Output: 75, CachedInput: 1.5},
+		"mistral-large":       {Input: 2, Output: 6},
+		"mistral-medium":      {Input: 0.4, Output: 2},
+		"mistral-small":       {Input: 0.2, Output: 0.6},
+		"open-mistral-7b":     {Input: 0.25, Output: 0.25},
+		"open-mixtral-8x7b":   {Input: 0.7, Output: 0.7},
+		"open-mixtral-8x22b":  {Input: 2, Output: 6},
+		"codestral":           {Input: 0.3, Output: 0.9},
+		"open-mistral-nemo":   {Input: 0.15, Output: 0.15},
+		"ministral-8b":        {Input: 0.1, Output: 0.1},
+		"ministral-3b":        {Input: 0.04, Output: 0.04},
+		"pixtral-large":       {Input: 2, Output: 6},
+	} {
+		if strings.HasPrefix(model, "claude-") {
+			p.CacheWrite = p.Input * anthropicCacheWriteFactor
+		}
+		t.prices[model] = p
+	}
+	return t
+}
+
+// Set sets the price of a model.
+func (t *PricingTable) Set(model string, pricing Pricing) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prices[model] = pricing
+}
+
+// Delete removes the price of a model.
+func (t *PricingTable) Delete(model string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.prices, model)
+}
+
+// versionSuffix matches the suffixes that name a version of a model: a date
+// such as "-2024-08-06" or "-20240620", a four digit version such as "-2407"
+// or "-0125", "-latest", or a version after an "@".
+var versionSuffix = regexp.MustCompile(`(-\d{4}-\d{2}-\d{2}|-\d{8}|-\d{4}|-latest|@.*)$`)
+
+// Get returns the price of a model.
+// If there is no price for the exact model name, the version suffix of the
+// name is removed, so that dated versions such as "gpt-4o-2024-08-06" find the
+// price of "gpt-4o". Other model names, such as fine-tuned models, have no
+// known price.
+func (t *PricingTable) Get(model string) (Pricing, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if p, ok := t.prices[model]; ok {
+		return p, true
+	}
+	base := versionSuffix.ReplaceAllString(model, "")
+	if base == model || base == "" {
+		return Pricing{}, false
+	}
+	p, ok := t.prices[base]
+	return p, ok
+}
+
+// Load reads prices from JSON and adds them to the table, replacing the prices
+// of models that are already present.
+// The JSON must be an object mapping model names to objects with the keys
+// "input", "output" and optionally "cached_input".
+func (t *PricingTable) Load(r io.Reader) error {
+	prices := make(map[string]Pricing)
+	if err := json.NewDecoder(r).Decode(&prices); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for model, p := range prices {
+		t.prices[model] = p
+	}
+	return nil
+}
+
+// LoadFile reads prices from a JSON file. See Load for the format.
+func (t *PricingTable) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return t.Load(f)
+}
+
+// MarshalJSON encodes the table in the format read by Load.
+func (t *PricingTable) MarshalJSON() ([]byte, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return json.Marshal(t.prices)
+}
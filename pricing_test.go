@@ -0,0 +1,86 @@
+package multi_ai_client
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPricingTableGet(t *testing.T) {
+	table := DefaultPricingTable()
+	gpt4o, _ := table.Get("gpt-4o")
+	mini, _ := table.Get("gpt-4o-mini")
+	tests := []struct {
+		model string
+		want  *Pricing
+	}{
+		{"gpt-4o", &gpt4o},
+		{"gpt-4o-2024-08-06", &gpt4o},
+		{"gpt-4o-mini-2024-07-18", &mini},
+		{"claude-3-5-haiku-20241022", &Pricing{Input: 0.8, Output: 4, CachedInput: 0.08, CacheWrite: 1}},
+		{"claude-3-5-sonnet-latest", &Pricing{Input: 3, Output: 15, CachedInput: 0.3, CacheWrite: 3.75}},
+		{"claude-3-5-sonnet@20240620", &Pricing{Input: 3, Output: 15, CachedInput: 0.3, CacheWrite: 3.75}},
+		{"mistral-large-2407", &Pricing{Input: 2, Output: 6}},
+		{"mistral-small-latest", &Pricing{Input: 0.2, Output: 0.6}},
+		// Models that only share a prefix with a known model have no price.
+		{"gpt-4o-audio-preview", nil},
+		{"gpt-4o-mini-realtime", nil},
+		{"ft:gpt-4o-mini:org::abc123", nil},
+		{"claude-3-5-sonnet-v2@20241022", nil},
+		{"unknown", nil},
+		{"-latest", nil},
+	}
+	for _, test := range tests {
+		got, ok := table.Get(test.model)
+		if test.want == nil {
+			if ok {
+				t.Errorf("Get(%q) = %+v, want no price", test.model, got)
+			}
+			continue
+		}
+		if !ok || got != *test.want {
+			t.Errorf("Get(%q) = %+v, %v, want %+v", test.model, got, ok, *test.want)
+		}
+	}
+}
+
+func TestPricingCost(t *testing.T) {
+	claude, _ := DefaultPricingTable().Get("claude-3-5-sonnet-20241022")
+	tests := []struct {
+		name    string
+		pricing Pricing
+		usage   Usage
+		want    float64
+	}{
+		{"input and output", Pricing{Input: 3, Output: 15}, Usage{InputTokens: 1_000_000, OutputTokens: 100_000}, 4.5},
+		{"cached input", Pricing{Input: 3, Output: 15, CachedInput: 0.3}, Usage{InputTokens: 1_000_000, CachedInputTokens: 500_000}, 1.65},
+		{"cached input without price", Pricing{Input: 3, Output: 15}, Usage{InputTokens: 1_000_000, CachedInputTokens: 500_000}, 3},
+		// Anthropic bills cache writes at 1.25 times the input price.
+		{"cache write", claude, Usage{InputTokens: 1_000_000, CacheWriteInputTokens: 1_000_000}, 3.75},
+		{"cache read and write", claude, Usage{InputTokens: 1_000_000, CachedInputTokens: 500_000, CacheWriteInputTokens: 250_000, OutputTokens: 1000}, 0.15 + 0.9375 + 0.75 + 0.015},
+		{"cache write without price", Pricing{Input: 3, Output: 15}, Usage{InputTokens: 1_000_000, CacheWriteInputTokens: 1_000_000}, 3},
+		{"more cached than input", Pricing{Input: 3, CachedInput: 0.3, CacheWrite: 3.75}, Usage{InputTokens: 100, CachedInputTokens: 80, CacheWriteInputTokens: 80}, (80*0.3 + 20*3.75) / 1_000_000},
+	}
+	for _, test := range tests {
+		if got := test.pricing.Cost(test.usage); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: cost %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseAnthropicCacheUsage(t *testing.T) {
+	var data interface{} = map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"usage": map[string]interface{}{
+				"input_tokens":                10,
+				"cache_read_input_tokens":     200,
+				"cache_creation_input_tokens": 3000,
+				"output_tokens":               1,
+			},
+		},
+	}
+	want := Usage{InputTokens: 3210, CachedInputTokens: 200, CacheWriteInputTokens: 3000, OutputTokens: 1}
+	if usage := parseUsage(data); usage == nil || *usage != want {
+		t.Errorf("usage %+v, want %+v", usage, want)
+	}
+}
@@ -0,0 +1,244 @@
+package multi_ai_client
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// RatingOptions is a struct representing the settings used to rate models by
+// their preferences.
+type RatingOptions struct {
+	// Initial is the rating of a model before any comparison. With
+	// Bradley-Terry ratings, it is the rating of a model that wins half of its
+	// comparisons. If it is 0, 1000 is used.
+	Initial float64
+
+	// K is the Elo K-factor: the most a rating changes after one comparison.
+	// If it is 0, 32 is used.
+	K float64
+
+	// Bootstrap is the amount of times the comparisons are resampled to
+	// estimate the confidence intervals. If it is 0, 200 is used. If it is
+	// negative, no intervals are estimated.
+	Bootstrap int
+
+	// Confidence is the confidence level of the intervals. If it is 0, 0.95
+	// is used.
+	Confidence float64
+
+	// Rand resamples the comparisons. If it is nil, a source with a fixed seed
+	// is used, so the same preferences always give the same intervals.
+	Rand *rand.Rand
+}
+
+// Rating is a struct representing the estimated strength of a model, on the
+// scale of Elo ratings: a model rated 400 points higher than another is
+// expected to be preferred 10 times as often.
+type Rating struct {
+	// Model is the name of the model definition.
+	Model  string  `json:"model"`
+	Rating float64 `json:"rating"`
+	// Lower and Upper are the bounds of the confidence interval of the
+	// rating. They equal the rating if no interval was estimated.
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+	// Wins and Losses are the amounts of comparisons the model won and lost.
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+}
+
+// comparison is a pairwise comparison of two models, derived from a
+// preference.
+type comparison struct {
+	winner, loser string
+}
+
+// comparisons returns the pairwise comparisons of the preferences: the winner
+// of a preference beat every other candidate. Candidates of the same model as
+// the winner tell nothing about the model and are skipped.
+func comparisons(preferences []Preference) []comparison {
+	var result []comparison
+	for _, p := range preferences {
+		if p.Winner < 0 || p.Winner >= len(p.Models) {
+			continue
+		}
+		winner := p.Models[p.Winner]
+		for i, loser := range p.Models {
+			if i != p.Winner && loser != winner {
+				result = append(result, comparison{winner: winner, loser: loser})
+			}
+		}
+	}
+	return result
+}
+
+// EloRatings rates the models of the preferences with the Elo system. As Elo
+// ratings depend on the order of the comparisons, the rating is the median
+// rating over the resampled comparisons. Only if no intervals are estimated,
+// the comparisons are applied in the order the preferences were made. The
+// ratings are sorted from best to worst.
+func EloRatings(preferences []Preference, options RatingOptions) []Rating {
+	k := options.K
+	if k == 0 {
+		k = 32
+	}
+	return rate(preferences, options, true, func(comparisons []comparison, models []string, initial float64) map[string]float64 {
+		ratings := make(map[string]float64, len(models))
+		for _, model := range models {
+			ratings[model] = initial
+		}
+		for _, c := range comparisons {
+			expected := 1 / (1 + math.Pow(10, (ratings[c.loser]-ratings[c.winner])/400))
+			ratings[c.winner] += k * (1 - expected)
+			ratings[c.loser] -= k * (1 - expected)
+		}
+		return ratings
+	})
+}
+
+// BradleyTerryRatings rates the models of the preferences with the
+// Bradley-Terry model, which finds the strengths that best explain all
+// comparisons at once, regardless of their order. Every model is given one
+// virtual win and loss against a model of the initial rating, so models that
+// never won or never lost still get a finite rating. The ratings are sorted
+// from best to worst.
+func BradleyTerryRatings(preferences []Preference, options RatingOptions) []Rating {
+	return rate(preferences, options, false, bradleyTerry)
+}
+
+// bradleyTerry fits the strengths of the Bradley-Terry model with the
+// minorization-maximization algorithm of Hunter (2004).
+func bradleyTerry(comparisons []comparison, models []string, initial float64) map[string]float64 {
+	index := make(map[string]int, len(models))
+	for i, model := range models {
+		index[model] = i
+	}
+	wins := make([]float64, len(models))
+	games := make([][]float64, len(models))
+	for i := range games {
+		games[i] = make([]float64, len(models))
+	}
+	for _, c := range comparisons {
+		w, l := index[c.winner], index[c.loser]
+		wins[w]++
+		games[w][l]++
+		games[l][w]++
+	}
+
+	strengths := make([]float64, len(models))
+	for i := range strengths {
+		strengths[i] = 1
+	}
+	for iteration := 0; iteration < 10000; iteration++ {
+		change := 0.0
+		for i := range strengths {
+			// The virtual win and loss against a model of strength 1.
+			denominator := 2 / (strengths[i] + 1)
+			for j, n := range games[i] {
+				if n > 0 {
+					denominator += n / (strengths[i] + strengths[j])
+				}
+			}
+			updated := (wins[i] + 1) / denominator
+			change = math.Max(change, math.Abs(math.Log(updated/strengths[i])))
+			strengths[i] = updated
+		}
+		if change < 1e-10 {
+			break
+		}
+	}
+
+	ratings := make(map[string]float64, len(models))
+	for i, model := range models {
+		ratings[model] = initial + 400*math.Log10(strengths[i])
+	}
+	return ratings
+}
+
+// rate rates the models of the preferences with the given fit, and estimates
+// confidence intervals by refitting resampled comparisons. If the fit depends
+// on the order of the comparisons, the rating is the median of the refits.
+func rate(preferences []Preference, options RatingOptions, ordered bool, fit func(comparisons []comparison, models []string, initial float64) map[string]float64) []Rating {
+	initial := options.Initial
+	if initial == 0 {
+		initial = 1000
+	}
+	rounds := options.Bootstrap
+	if rounds == 0 {
+		rounds = 200
+	}
+	confidence := options.Confidence
+	if confidence == 0 {
+		confidence = 0.95
+	}
+	r := options.Rand
+	if r == nil {
+		r = rand.New(rand.NewSource(1))
+	}
+
+	all := comparisons(preferences)
+	results := make(map[string]*Rating)
+	var models []string
+	for _, p := range preferences {
+		for _, model := range p.Models {
+			if _, ok := results[model]; !ok {
+				results[model] = &Rating{Model: model}
+				models = append(models, model)
+			}
+		}
+	}
+	for _, c := range all {
+		results[c.winner].Wins++
+		results[c.loser].Losses++
+	}
+
+	ratings := fit(all, models, initial)
+	for model, rating := range ratings {
+		results[model].Rating = rating
+		results[model].Lower = rating
+		results[model].Upper = rating
+	}
+
+	if rounds > 0 && len(all) > 0 {
+		samples := make(map[string][]float64, len(models))
+		sample := make([]comparison, len(all))
+		for round := 0; round < rounds; round++ {
+			for i := range sample {
+				sample[i] = all[r.Intn(len(all))]
+			}
+			for model, rating := range fit(sample, models, initial) {
+				samples[model] = append(samples[model], rating)
+			}
+		}
+		for model, values := range samples {
+			sort.Float64s(values)
+			results[model].Lower = quantile(values, (1-confidence)/2)
+			results[model].Upper = quantile(values, 1-(1-confidence)/2)
+			if ordered {
+				results[model].Rating = quantile(values, 0.5)
+			}
+		}
+	}
+
+	sorted := make([]Rating, 0, len(results))
+	for _, model := range models {
+		sorted = append(sorted, *results[model])
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Rating > sorted[j].Rating
+	})
+	return sorted
+}
+
+// quantile returns the q-quantile of sorted values, interpolating between the
+// nearest values.
+func quantile(values []float64, q float64) float64 {
+	position := q * float64(len(values)-1)
+	lower := int(math.Floor(position))
+	if lower >= len(values)-1 {
+		return values[len(values)-1]
+	}
+	fraction := position - float64(lower)
+	return values[lower]*(1-fraction) + values[lower+1]*fraction
+}
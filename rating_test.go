@@ -0,0 +1,163 @@
+package multi_ai_client
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// preferencesOf returns preferences where winner beat loser wins times, and
+// loser beat winner losses times.
+func preferencesOf(winner string, loser string, wins int, losses int) []Preference {
+	preferences := make([]Preference, 0, wins+losses)
+	for i := 0; i < wins; i++ {
+		preferences = append(preferences, Preference{Models: []string{winner, loser}, Winner: 0})
+	}
+	for i := 0; i < losses; i++ {
+		preferences = append(preferences, Preference{Models: []string{winner, loser}, Winner: 1})
+	}
+	return preferences
+}
+
+// ratingOf returns the rating of the model.
+func ratingOf(t *testing.T, ratings []Rating, model string) Rating {
+	t.Helper()
+	for _, r := range ratings {
+		if r.Model == model {
+			return r
+		}
+	}
+	t.Fatalf("no rating for %s in %+v", model, ratings)
+	return Rating{}
+}
+
+func TestComparisons(t *testing.T) {
+	preferences := []Preference{
+		{Models: []string{"A", "B", "C"}, Winner: 1},
+		{Models: []string{"A", "A", "B"}, Winner: 0},
+		{Models: []string{"A", "B"}, Winner: 2},
+		{Models: []string{"A", "B"}, Winner: -1},
+	}
+	want := []comparison{{"B", "A"}, {"B", "C"}, {"A", "B"}}
+	if got := comparisons(preferences); !reflect.DeepEqual(got, want) {
+		t.Errorf("comparisons %v, want %v", got, want)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	values := []float64{1, 2, 3, 4, 5}
+	tests := map[float64]float64{0: 1, 0.25: 2, 0.5: 3, 0.6: 3.4, 0.975: 4.9, 1: 5}
+	for q, want := range tests {
+		if got := quantile(values, q); math.Abs(got-want) > 1e-9 {
+			t.Errorf("quantile %v: %v, want %v", q, got, want)
+		}
+	}
+	if got := quantile([]float64{7}, 0.5); got != 7 {
+		t.Errorf("quantile of a single value: %v, want 7", got)
+	}
+}
+
+func TestBradleyTerryRatings(t *testing.T) {
+	// A model preferred 3 times as often is 400*log10(3), about 191 points,
+	// stronger. The virtual games hardly matter after many comparisons.
+	ratings := BradleyTerryRatings(preferencesOf("A", "B", 300, 100), RatingOptions{Bootstrap: -1})
+	a, b := ratingOf(t, ratings, "A"), ratingOf(t, ratings, "B")
+	if difference := a.Rating - b.Rating; math.Abs(difference-400*math.Log10(3)) > 1 {
+		t.Errorf("A is %.1f points stronger than B, want about 191", difference)
+	}
+	if math.Abs(a.Rating+b.Rating-2000) > 1e-3 {
+		t.Errorf("ratings %.1f and %.1f, want them centered on the initial rating", a.Rating, b.Rating)
+	}
+	if a.Wins != 300 || a.Losses != 100 || b.Wins != 100 || b.Losses != 300 {
+		t.Errorf("wins and losses %+v", ratings)
+	}
+	if a.Lower != a.Rating || a.Upper != a.Rating {
+		t.Errorf("interval %.1f to %.1f, want none without bootstrap", a.Lower, a.Upper)
+	}
+
+	// After a few comparisons, the virtual games pull the ratings toward the
+	// initial rating.
+	few := BradleyTerryRatings(preferencesOf("A", "B", 3, 1), RatingOptions{Bootstrap: -1, Initial: 1500})
+	difference := ratingOf(t, few, "A").Rating - ratingOf(t, few, "B").Rating
+	if difference <= 0 || difference >= 400*math.Log10(3) {
+		t.Errorf("A is %.1f points stronger than B after 4 comparisons, want between 0 and 191", difference)
+	}
+	if few[0].Model != "A" {
+		t.Errorf("ratings %+v, want the best model first", few)
+	}
+}
+
+func TestRatingsOfUndefeatedModels(t *testing.T) {
+	preferences := append(preferencesOf("A", "B", 5, 0), preferencesOf("B", "C", 5, 0)...)
+	for name, rate := range map[string]func([]Preference, RatingOptions) []Rating{
+		"Bradley-Terry": BradleyTerryRatings,
+		"Elo":           EloRatings,
+	} {
+		ratings := rate(preferences, RatingOptions{})
+		for _, r := range ratings {
+			for _, value := range []float64{r.Rating, r.Lower, r.Upper} {
+				if math.IsNaN(value) || math.IsInf(value, 0) {
+					t.Errorf("%s: rating %+v is not finite", name, r)
+				}
+			}
+		}
+		if ratings[0].Model != "A" || ratings[2].Model != "C" {
+			t.Errorf("%s: ratings %+v, want A, B, C", name, ratings)
+		}
+	}
+}
+
+func TestEloRatings(t *testing.T) {
+	// Between equal ratings, the winner gains half of K.
+	ratings := EloRatings(preferencesOf("A", "B", 1, 0), RatingOptions{Bootstrap: -1})
+	if a, b := ratingOf(t, ratings, "A"), ratingOf(t, ratings, "B"); a.Rating != 1016 || b.Rating != 984 {
+		t.Errorf("ratings %.1f and %.1f, want 1016 and 984", a.Rating, b.Rating)
+	}
+	ratings = EloRatings(preferencesOf("A", "B", 1, 0), RatingOptions{Bootstrap: -1, K: 10, Initial: 1500})
+	if a := ratingOf(t, ratings, "A"); a.Rating != 1505 {
+		t.Errorf("rating %.1f, want 1505", a.Rating)
+	}
+
+	// Without resampling, the comparisons are applied in order, so a late
+	// win counts more than an early one.
+	early := EloRatings(append(preferencesOf("A", "B", 1, 0), preferencesOf("A", "B", 0, 3)...), RatingOptions{Bootstrap: -1})
+	late := EloRatings(append(preferencesOf("A", "B", 0, 3), preferencesOf("A", "B", 1, 0)...), RatingOptions{Bootstrap: -1})
+	if ratingOf(t, early, "A").Rating >= ratingOf(t, late, "A").Rating {
+		t.Errorf("early win %.1f, late win %.1f, want the late win to count more", ratingOf(t, early, "A").Rating, ratingOf(t, late, "A").Rating)
+	}
+}
+
+func TestRatingIntervals(t *testing.T) {
+	preferences := append(preferencesOf("A", "B", 12, 4), preferencesOf("B", "C", 9, 6)...)
+	for name, rate := range map[string]func([]Preference, RatingOptions) []Rating{
+		"Bradley-Terry": BradleyTerryRatings,
+		"Elo":           EloRatings,
+	} {
+		first := rate(preferences, RatingOptions{})
+		second := rate(preferences, RatingOptions{})
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("%s: %+v and %+v, want the same intervals with the default seed", name, first, second)
+		}
+		seeded := rate(preferences, RatingOptions{Rand: rand.New(rand.NewSource(1))})
+		if !reflect.DeepEqual(first, seeded) {
+			t.Errorf("%s: %+v, want the default seed to be 1", name, seeded)
+		}
+		for _, r := range first {
+			if r.Lower > r.Rating || r.Rating > r.Upper || r.Lower == r.Upper {
+				t.Errorf("%s: interval %.1f to %.1f does not surround %.1f", name, r.Lower, r.Upper, r.Rating)
+			}
+		}
+
+		narrow := rate(preferences, RatingOptions{Confidence: 0.5})
+		for i, r := range narrow {
+			if r.Upper-r.Lower >= first[i].Upper-first[i].Lower {
+				t.Errorf("%s: the 50%% interval of %s is not narrower than the 95%% interval", name, r.Model)
+			}
+		}
+	}
+
+	if ratings := BradleyTerryRatings(nil, RatingOptions{}); len(ratings) != 0 {
+		t.Errorf("ratings %+v without preferences, want none", ratings)
+	}
+}
@@ -0,0 +1,296 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ModelChange is a struct describing how a model definition with the same name
+// changed.
+type ModelChange struct {
+	Name string
+	// Fields are the configuration keys that changed, such as "model" or
+	// "settings.temperature". API keys are reported as "api_key" without
+	// their value.
+	Fields []string
+}
+
+// ConfigDiff is a struct describing the difference between two sets of model
+// definitions, matched by name.
+type ConfigDiff struct {
+	Added   []string
+	Removed []string
+	Changed []ModelChange
+	// Reordered is set when the model definitions that were kept changed
+	// order, which changes the index of their responses.
+	Reordered bool
+}
+
+// Empty returns whether nothing changed.
+func (d ConfigDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && !d.Reordered
+}
+
+func (d ConfigDiff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	lines := make([]string, 0)
+	for _, name := range d.Added {
+		lines = append(lines, "+ "+name)
+	}
+	for _, name := range d.Removed {
+		lines = append(lines, "- "+name)
+	}
+	for _, change := range d.Changed {
+		lines = append(lines, "~ "+change.Name+": "+strings.Join(change.Fields, ", "))
+	}
+	if d.Reordered {
+		lines = append(lines, "order changed")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DiffModelDefinitions returns the difference between two sets of model
+// definitions, matched by name.
+func DiffModelDefinitions(old []ModelDefinition, new []ModelDefinition) ConfigDiff {
+	diff := ConfigDiff{}
+	oldByName := make(map[string]ModelDefinition, len(old))
+	for _, m := range old {
+		oldByName[m.Name] = m
+	}
+	newByName := make(map[string]bool, len(new))
+	kept := make([]string, 0)
+	for _, m := range new {
+		newByName[m.Name] = true
+		previous, ok := oldByName[m.Name]
+		if !ok {
+			diff.Added = append(diff.Added, m.Name)
+			continue
+		}
+		kept = append(kept, m.Name)
+		if fields := changedFields(previous, m); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, ModelChange{Name: m.Name, Fields: fields})
+		}
+	}
+	keptBefore := make([]string, 0)
+	for _, m := range old {
+		if !newByName[m.Name] {
+			diff.Removed = append(diff.Removed, m.Name)
+		} else {
+			keptBefore = append(keptBefore, m.Name)
+		}
+	}
+	diff.Reordered = !reflect.DeepEqual(kept, keptBefore)
+	return diff
+}
+
+// changedFields returns the configuration keys that differ between two model
+// definitions.
+func changedFields(old ModelDefinition, new ModelDefinition) []string {
+	a, b := NewModelConfig(old), NewModelConfig(new)
+	fields := make([]string, 0)
+	if a.Provider != b.Provider {
+		fields = append(fields, "provider")
+	}
+	if a.Endpoint != b.Endpoint {
+		fields = append(fields, "endpoint")
+	}
+	if a.Model != b.Model {
+		fields = append(fields, "model")
+	}
+	if old.APISettings.APIKey != new.APISettings.APIKey || a.APIKey != b.APIKey || a.APIKeyFile != b.APIKeyFile {
+		fields = append(fields, "api_key")
+	}
+	if a.ContextWindow != b.ContextWindow {
+		fields = append(fields, "context_window")
+	}
+	if a.ReservedOutputTokens != b.ReservedOutputTokens {
+		fields = append(fields, "reserved_output_tokens")
+	}
+	keys := make([]string, 0)
+	for key := range a.Settings {
+		keys = append(keys, key)
+	}
+	for key := range b.Settings {
+		if _, ok := a.Settings[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !reflect.DeepEqual(a.Settings[key], b.Settings[key]) {
+			fields = append(fields, "settings."+key)
+		}
+	}
+	if !reflect.DeepEqual(a.Tags, b.Tags) && (len(a.Tags) > 0 || len(b.Tags) > 0) {
+		fields = append(fields, "tags")
+	}
+	if !reflect.DeepEqual(a.Pricing, b.Pricing) {
+		fields = append(fields, "pricing")
+	}
+	return fields
+}
+
+// ApplyConfig replaces the model definitions of the client with the ones
+// described by the config, and returns what changed. If the config is
+// invalid, nothing is changed and the error is returned.
+// The config owns the full set of model definitions: definitions that are not
+// in it, including those added with AddModelDefinition, are removed. The
+// Transport and Tokenizer of a definition can not be configured, so they are
+// kept for the definitions of the config with the same name.
+// Responses that are being created keep using the model definitions they were
+// started with.
+func (c *Client) ApplyConfig(config *Config) (ConfigDiff, error) {
+	configured, err := config.ModelDefinitions()
+	if err != nil {
+		return ConfigDiff{}, err
+	}
+	if len(configured) == 0 {
+		return ConfigDiff{}, errors.New("configuration has no models")
+	}
+	for {
+		old := c.modelDefinitions.Load()
+		var current []ModelDefinition
+		if old != nil {
+			current = *old.(*[]ModelDefinition)
+		}
+		definitions := keepUnconfigured(current, configured)
+		diff := DiffModelDefinitions(current, definitions)
+		if c.modelDefinitions.CompareAndSwap(old, &definitions) {
+			return diff, nil
+		}
+	}
+}
+
+// keepUnconfigured returns a copy of the configured model definitions with
+// the fields that can not be configured taken from the current definitions
+// with the same name.
+func keepUnconfigured(current []ModelDefinition, configured []ModelDefinition) []ModelDefinition {
+	byName := make(map[string]ModelDefinition, len(current))
+	for _, m := range current {
+		byName[m.Name] = m
+	}
+	definitions := append(make([]ModelDefinition, 0, len(configured)), configured...)
+	for i, m := range definitions {
+		previous, ok := byName[m.Name]
+		if !ok {
+			continue
+		}
+		if m.Transport == nil {
+			definitions[i].Transport = previous.Transport
+		}
+		if m.Tokenizer == nil {
+			definitions[i].Tokenizer = previous.Tokenizer
+		}
+	}
+	return definitions
+}
+
+// ReloadFunc is a function called after every attempt to apply a changed
+// configuration, with what changed or why the configuration was rejected.
+type ReloadFunc func(diff ConfigDiff, err error)
+
+// WatchConfig applies a configuration file to the client, and then checks it
+// for changes every interval until the context is cancelled. Changed files are
+// applied with ApplyConfig, and onReload is called with the result; it may be
+// nil. A file that can not be read is reported once, and again only when the
+// error changes.
+// If the file can not be applied at first, the error is returned and nothing
+// is watched.
+func (c *Client) WatchConfig(ctx context.Context, path string, interval time.Duration, onReload ReloadFunc) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	config, err := ParseConfig(data, path)
+	if err != nil {
+		return err
+	}
+	if _, err := c.ApplyConfig(config); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// readError is the error of the last read, if it failed.
+		readError := ""
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current, err := os.ReadFile(path)
+			if err != nil {
+				if err.Error() != readError {
+					readError = err.Error()
+					c.reloaded(ConfigDiff{}, err, onReload)
+				}
+				continue
+			}
+			readError = ""
+			if bytes.Equal(current, data) {
+				continue
+			}
+			data = current
+			config, err := ParseConfig(data, path)
+			if err != nil {
+				c.reloaded(ConfigDiff{}, err, onReload)
+				continue
+			}
+			diff, err := c.ApplyConfig(config)
+			c.reloaded(diff, err, onReload)
+		}
+	}()
+	return nil
+}
+
+// WatchConfigUpdates applies every config received from the channel to the
+// client, until the channel is closed or the context is cancelled. onReload is
+// called with the result of every update; it may be nil.
+func (c *Client) WatchConfigUpdates(ctx context.Context, updates <-chan *Config, onReload ReloadFunc) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case config, ok := <-updates:
+				if !ok {
+					return
+				}
+				diff, err := c.ApplyConfig(config)
+				c.reloaded(diff, err, onReload)
+			}
+		}
+	}()
+}
+
+// reloaded reports the result of a reload to the logger of the client and to
+// onReload.
+func (c *Client) reloaded(diff ConfigDiff, err error, onReload ReloadFunc) {
+	if c.Logger != nil {
+		if err != nil {
+			c.Logger.Error("configuration rejected", slog.String("error", err.Error()))
+		} else {
+			c.Logger.Info("configuration applied",
+				slog.Any("added", diff.Added),
+				slog.Any("removed", diff.Removed),
+				slog.String("changes", diff.String()))
+		}
+	}
+	if onReload != nil {
+		onReload(diff, err)
+	}
+}
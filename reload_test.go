@@ -0,0 +1,230 @@
+package multi_ai_client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gptConfig configures a single model, and reloadConfig adds a second one.
+const (
+	gptConfig = `models:
+  - name: GPT
+    provider: openai
+    model: gpt-4o
+    settings:
+      temperature: 0.5
+`
+	reloadConfig = gptConfig + `  - name: Claude
+    provider: anthropic
+    model: claude-3-5-sonnet-20240620
+`
+)
+
+func TestDiffModelDefinitions(t *testing.T) {
+	gpt := NewModelDefinition("GPT", OpenAI, "key", "gpt-4o")
+	claude := NewModelDefinition("Claude", Anthropic, "key", "claude-3-5-sonnet-20240620")
+	mistral := NewModelDefinition("Mistral", Mistral, "key", "mistral-large-latest")
+
+	changed := gpt.Clone()
+	changed.APISettings.APIKey = "other key"
+	changed.ContextWindow = 128000
+	if err := changed.ModelSettings.Set("temperature", 0.2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		old, new []ModelDefinition
+		want     ConfigDiff
+	}{
+		{"same", []ModelDefinition{gpt, claude}, []ModelDefinition{gpt.Clone(), claude.Clone()}, ConfigDiff{}},
+		{"added and removed", []ModelDefinition{gpt, claude}, []ModelDefinition{gpt, mistral},
+			ConfigDiff{Added: []string{"Mistral"}, Removed: []string{"Claude"}}},
+		{"reordered", []ModelDefinition{gpt, claude, mistral}, []ModelDefinition{claude, gpt},
+			ConfigDiff{Removed: []string{"Mistral"}, Reordered: true}},
+		{"changed", []ModelDefinition{gpt, claude}, []ModelDefinition{changed, claude},
+			ConfigDiff{Changed: []ModelChange{{Name: "GPT", Fields: []string{"api_key", "context_window", "settings.temperature"}}}}},
+		{"from nothing", nil, []ModelDefinition{gpt}, ConfigDiff{Added: []string{"GPT"}}},
+	}
+	for _, test := range tests {
+		got := DiffModelDefinitions(test.old, test.new)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+		if got.Empty() != test.want.Empty() {
+			t.Errorf("%s: Empty() = %v", test.name, got.Empty())
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	client := &Client{}
+	client.SetModelDefinitions([]ModelDefinition{NewModelDefinition("GPT", OpenAI, "", "gpt-4o-mini")})
+
+	config, err := ParseConfig([]byte(reloadConfig), "models.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := client.ApplyConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ConfigDiff{Added: []string{"Claude"}, Changed: []ModelChange{{Name: "GPT", Fields: []string{"model", "settings.temperature"}}}}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("diff %+v, want %+v", diff, want)
+	}
+	if definitions := client.GetModelDefinitions(); len(definitions) != 2 || definitions[0].Name != "GPT" || definitions[1].Name != "Claude" {
+		t.Errorf("model definitions %+v", definitions)
+	}
+
+	// An invalid config changes nothing.
+	if _, err := client.ApplyConfig(&Config{}); err == nil {
+		t.Error("an empty config was applied")
+	}
+	if len(client.GetModelDefinitions()) != 2 {
+		t.Error("a rejected config changed the model definitions")
+	}
+}
+
+func TestApplyConfigKeepsUnconfigured(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("not sent")
+	})
+	gpt := NewModelDefinition("GPT", OpenAI, "", "gpt-4o-mini")
+	gpt.Transport = transport
+	gpt.Tokenizer = wordTokenizer{}
+	client := &Client{}
+	client.SetModelDefinitions([]ModelDefinition{gpt})
+	client.AddModelDefinition(NewModelDefinition("Local", OpenAI, "", "llama3"))
+
+	config, err := ParseConfig([]byte(reloadConfig), "models.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff, err := client.ApplyConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(diff.Removed, []string{"Local"}) {
+		t.Errorf("removed %q, want the definition that is not in the config", diff.Removed)
+	}
+	definitions := client.GetModelDefinitions()
+	if len(definitions) != 2 {
+		t.Fatalf("model definitions %+v", definitions)
+	}
+	if definitions[0].Transport == nil || definitions[0].Tokenizer != (wordTokenizer{}) {
+		t.Errorf("GPT lost its transport or tokenizer: %+v", definitions[0])
+	}
+	if definitions[0].GetModelName() != "gpt-4o" {
+		t.Errorf("GPT has model %s, want the configured model", definitions[0].GetModelName())
+	}
+	if definitions[1].Transport != nil || definitions[1].Tokenizer != nil {
+		t.Errorf("Claude took a transport or tokenizer: %+v", definitions[1])
+	}
+}
+
+// reloads records the calls to a ReloadFunc.
+type reloads struct {
+	mu    sync.Mutex
+	diffs []ConfigDiff
+	errs  []error
+}
+
+func (r *reloads) record(diff ConfigDiff, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.diffs = append(r.diffs, diff)
+	r.errs = append(r.errs, err)
+}
+
+func (r *reloads) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.diffs)
+}
+
+// waitFor waits until onReload was called n times in total.
+func (r *reloads) waitFor(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for r.count() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("onReload called %d times, want %d", r.count(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "models.yaml")
+	if err := os.WriteFile(path, []byte(reloadConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	client := &Client{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &reloads{}
+	const interval = 5 * time.Millisecond
+	if err := client.WatchConfig(ctx, path, interval, r.record); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.GetModelDefinitions()) != 2 {
+		t.Fatalf("model definitions %+v", client.GetModelDefinitions())
+	}
+
+	// A changed file is applied.
+	if err := os.WriteFile(path, []byte(gptConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.waitFor(t, 1)
+	if r.errs[0] != nil || !reflect.DeepEqual(r.diffs[0].Removed, []string{"Claude"}) {
+		t.Errorf("reload %+v, %v", r.diffs[0], r.errs[0])
+	}
+
+	// A missing file is reported once, however often it is checked.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	r.waitFor(t, 2)
+	time.Sleep(20 * interval)
+	if n := r.count(); n != 2 {
+		t.Errorf("onReload called %d times for a missing file, want once", n-1)
+	}
+	if !os.IsNotExist(r.errs[1]) {
+		t.Errorf("error %v, want a missing file", r.errs[1])
+	}
+
+	// An invalid file is reported once, and nothing is changed.
+	if err := os.WriteFile(path, []byte("models: 3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r.waitFor(t, 3)
+	time.Sleep(20 * interval)
+	if n := r.count(); n != 3 {
+		t.Errorf("onReload called %d times for an invalid file, want once", n-2)
+	}
+	if r.errs[2] == nil {
+		t.Error("the invalid file was not rejected")
+	}
+	if len(client.GetModelDefinitions()) != 1 {
+		t.Errorf("the invalid file changed the model definitions")
+	}
+
+	// Nothing is watched after the context is cancelled.
+	cancel()
+	time.Sleep(20 * interval)
+	if err := os.WriteFile(path, []byte(reloadConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * interval)
+	if n := r.count(); n != 3 {
+		t.Errorf("onReload called after the context was cancelled")
+	}
+}
@@ -0,0 +1,381 @@
+// Package replay provides an http.RoundTripper that records the requests made
+// by a multi_ai_client.Client and their streamed responses to fixture files,
+// and replays them later without contacting the APIs.
+//
+// Use it by setting the transport on the HTTP client of the client:
+//
+//	transport := replay.NewTransport(replay.Replay, "testdata/fixtures")
+//	client.HTTPClient = &http.Client{Transport: transport}
+//
+// Run once in Record mode with real API keys to create the fixtures, then in
+// Replay mode in tests. API keys are never written to the fixtures.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/villadelfia/multi-ai-client/internal/secret"
+)
+
+// Mode is an enum representing whether a Transport records or replays.
+type Mode int
+
+const (
+	// Replay serves responses from the fixture files, and fails requests that
+	// have no fixture.
+	Replay Mode = iota
+	// Record sends requests to the APIs and writes their responses to fixture
+	// files.
+	Record
+)
+
+// Fixture is a recorded request and its response.
+type Fixture struct {
+	Request  FixtureRequest  `json:"request"`
+	Response FixtureResponse `json:"response"`
+}
+
+// FixtureRequest is the recorded part of a request.
+type FixtureRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// FixtureResponse is the recorded part of a response. The body is stored as
+// the chunks it was received in, with the time since the previous chunk.
+type FixtureResponse struct {
+	StatusCode int               `json:"status_code"`
+	Headers    map[string]string `json:"headers"`
+	Chunks     []FixtureChunk    `json:"chunks"`
+}
+
+// FixtureChunk is a part of a response body. Chunks end on character
+// boundaries, so text is stored as is in Data. Chunks that are not valid UTF-8
+// are stored in Bytes instead, which JSON encodes as base64.
+type FixtureChunk struct {
+	DelayMS int64  `json:"delay_ms"`
+	Data    string `json:"data,omitempty"`
+	Bytes   []byte `json:"bytes,omitempty"`
+}
+
+// newFixtureChunk creates a chunk holding data.
+func newFixtureChunk(delay time.Duration, data []byte) FixtureChunk {
+	chunk := FixtureChunk{DelayMS: delay.Milliseconds()}
+	if utf8.Valid(data) {
+		chunk.Data = string(data)
+	} else {
+		chunk.Bytes = append([]byte(nil), data...)
+	}
+	return chunk
+}
+
+// data returns the bytes of the chunk.
+func (c FixtureChunk) data() []byte {
+	if c.Bytes != nil {
+		return c.Bytes
+	}
+	return []byte(c.Data)
+}
+
+// Transport is an http.RoundTripper that records or replays responses.
+// It is safe for concurrent use.
+type Transport struct {
+	Mode Mode
+
+	// Dir is the directory the fixture files are stored in.
+	Dir string
+
+	// Next is the transport used to send requests in Record mode. If it is
+	// nil, http.DefaultTransport is used.
+	Next http.RoundTripper
+
+	// PreserveTiming makes Replay mode wait between chunks as long as the
+	// original response did. By default, all chunks are served at once.
+	PreserveTiming bool
+
+	mu        sync.Mutex
+	unmatched []string
+}
+
+// NewTransport creates a new Transport in the given mode, storing fixtures in
+// dir.
+func NewTransport(mode Mode, dir string) *Transport {
+	return &Transport{Mode: mode, Dir: dir}
+}
+
+// Unmatched returns a description of every request that had no fixture in
+// Replay mode.
+func (t *Transport) Unmatched() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.unmatched...)
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := Key(req.Method, req.URL.String(), body)
+
+	if t.Mode == Record {
+		return t.record(req, key, body)
+	}
+	return t.replay(req, key)
+}
+
+// Key returns the key a request is stored under: a hash of the method, URL
+// and body, with the body normalized so the order of its keys does not matter.
+func Key(method string, url string, body []byte) string {
+	normalized := body
+	if len(body) > 0 {
+		var data interface{}
+		if err := json.Unmarshal(body, &data); err == nil {
+			normalized, _ = json.Marshal(data)
+		}
+	}
+	h := sha256.New()
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(url))
+	h.Write([]byte{0})
+	h.Write(normalized)
+	return hex.EncodeToString(h.Sum(nil))[:32]
+}
+
+// Load reads the fixture with the given key from dir.
+func Load(dir string, key string) (*Fixture, error) {
+	data, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, err
+	}
+	return &fixture, nil
+}
+
+func (t *Transport) replay(req *http.Request, key string) (*http.Response, error) {
+	fixture, err := Load(t.Dir, key)
+	if errors.Is(err, os.ErrNotExist) {
+		desc := req.Method + " " + req.URL.String() + " (" + key + ")"
+		t.mu.Lock()
+		t.unmatched = append(t.unmatched, desc)
+		t.mu.Unlock()
+		return nil, errors.New("replay: no fixture for request " + desc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("replay: could not load fixture %s: %w", key, err)
+	}
+
+	header := make(http.Header)
+	for k, v := range fixture.Response.Headers {
+		header.Set(k, v)
+	}
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", fixture.Response.StatusCode, http.StatusText(fixture.Response.StatusCode)),
+		StatusCode: fixture.Response.StatusCode,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+		Body: &replayBody{
+			chunks:         fixture.Response.Chunks,
+			preserveTiming: t.PreserveTiming,
+			done:           req.Context().Done(),
+		},
+		Request: req,
+	}, nil
+}
+
+func (t *Transport) record(req *http.Request, key string, body []byte) (*http.Response, error) {
+	next := t.Next
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	response, err := next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	fixture := &Fixture{
+		Request: FixtureRequest{
+			Method:  req.Method,
+			URL:     req.URL.String(),
+			Headers: scrub(req.Header),
+			Body:    json.RawMessage(body),
+		},
+		Response: FixtureResponse{
+			StatusCode: response.StatusCode,
+			Headers:    scrub(response.Header),
+		},
+	}
+	if !json.Valid(body) {
+		fixture.Request.Body, _ = json.Marshal(string(body))
+	}
+	response.Body = &recordingBody{
+		body:    response.Body,
+		fixture: fixture,
+		path:    filepath.Join(t.Dir, key+".json"),
+		last:    time.Now(),
+	}
+	return response, nil
+}
+
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	if req.GetBody != nil {
+		r, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	_ = req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func scrub(header http.Header) map[string]string {
+	scrubbed := make(map[string]string, len(header))
+	for k := range header {
+		if !secret.IsHeader(k) {
+			scrubbed[k] = header.Get(k)
+		}
+	}
+	return scrubbed
+}
+
+// recordingBody passes a response body through, and writes the fixture when
+// it is closed.
+type recordingBody struct {
+	body    io.ReadCloser
+	fixture *Fixture
+	path    string
+	last    time.Time
+	once    sync.Once
+	// pending is the start of a character split over two reads, which is
+	// recorded with the next chunk.
+	pending []byte
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		data := append(b.pending, p[:n]...)
+		split := len(data) - incompleteSuffix(data)
+		b.pending = append([]byte(nil), data[split:]...)
+		b.addChunk(data[:split])
+	}
+	if err != nil {
+		b.flush()
+	}
+	return n, err
+}
+
+// addChunk records a chunk of the body.
+func (b *recordingBody) addChunk(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	now := time.Now()
+	b.fixture.Response.Chunks = append(b.fixture.Response.Chunks, newFixtureChunk(now.Sub(b.last), data))
+	b.last = now
+}
+
+// flush records the bytes held back at the end of the body.
+func (b *recordingBody) flush() {
+	b.addChunk(b.pending)
+	b.pending = nil
+}
+
+// incompleteSuffix returns the amount of bytes at the end of data that start
+// a UTF-8 encoded character without finishing it.
+func incompleteSuffix(data []byte) int {
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if utf8.FullRune(data[len(data)-i:]) {
+				return 0
+			}
+			return i
+		}
+	}
+	return 0
+}
+
+func (b *recordingBody) Close() error {
+	err := b.body.Close()
+	b.once.Do(func() {
+		b.flush()
+		data, merr := json.MarshalIndent(b.fixture, "", "  ")
+		if merr != nil {
+			err = merr
+			return
+		}
+		if merr := os.MkdirAll(filepath.Dir(b.path), 0o755); merr != nil {
+			err = merr
+			return
+		}
+		if werr := os.WriteFile(b.path, data, 0o644); werr != nil {
+			err = werr
+		}
+	})
+	return err
+}
+
+// replayBody serves the chunks of a fixture as a response body.
+type replayBody struct {
+	chunks         []FixtureChunk
+	current        []byte
+	preserveTiming bool
+	done           <-chan struct{}
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	for len(b.current) == 0 {
+		if len(b.chunks) == 0 {
+			return 0, io.EOF
+		}
+		chunk := b.chunks[0]
+		b.chunks = b.chunks[1:]
+		if b.preserveTiming && chunk.DelayMS > 0 {
+			select {
+			case <-time.After(time.Duration(chunk.DelayMS) * time.Millisecond):
+			case <-b.done:
+				return 0, errors.New("replay: request cancelled")
+			}
+		}
+		b.current = chunk.data()
+	}
+	n := copy(p, b.current)
+	b.current = b.current[n:]
+	return n, nil
+}
+
+func (b *replayBody) Close() error {
+	b.chunks = nil
+	b.current = nil
+	return nil
+}
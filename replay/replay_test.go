@@ -0,0 +1,132 @@
+package replay
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// upstream returns a transport answering every request with body, read one
+// byte at a time so every character is split over several reads.
+func upstream(body []byte) http.RoundTripper {
+	return roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"text/event-stream"}, "Set-Cookie": {"session=1"}},
+			Body:       io.NopCloser(iotest.OneByteReader(bytes.NewReader(body))),
+			Request:    req,
+		}, nil
+	})
+}
+
+func newRequest(t *testing.T, body string) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "https://api.example.com/v1/chat", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer sk-secret")
+	req.Header.Set("X-Api-Key", "sk-secret")
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func roundTrip(t *testing.T, transport http.RoundTripper, req *http.Request) []byte {
+	t.Helper()
+	response, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := response.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	bodies := map[string][]byte{
+		"text":   []byte("data: {\"text\": \"héllo wörld 日本 🎉\"}\n\ndata: [DONE]\n\n"),
+		"binary": {0xff, 0xfe, 'a', 0xc3},
+	}
+	for name, body := range bodies {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			recorder := NewTransport(Record, dir)
+			recorder.Next = upstream(body)
+			if got := roundTrip(t, recorder, newRequest(t, `{"model": "m"}`)); !bytes.Equal(got, body) {
+				t.Fatalf("recorded body %q, want %q", got, body)
+			}
+
+			replayer := NewTransport(Replay, dir)
+			// The order of the keys of the body does not matter.
+			if got := roundTrip(t, replayer, newRequest(t, `{ "model":"m" }`)); !bytes.Equal(got, body) {
+				t.Errorf("replayed body %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestRecordScrubsKeys(t *testing.T) {
+	dir := t.TempDir()
+	recorder := NewTransport(Record, dir)
+	recorder.Next = upstream([]byte("data: [DONE]\n\n"))
+	roundTrip(t, recorder, newRequest(t, `{}`))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got fixtures %v, %v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("sk-secret")) {
+		t.Errorf("fixture contains the API key:\n%s", data)
+	}
+	if !bytes.Contains(data, []byte("application/json")) {
+		t.Errorf("fixture lost the other headers:\n%s", data)
+	}
+}
+
+func TestReplayUnmatched(t *testing.T) {
+	transport := NewTransport(Replay, t.TempDir())
+	if _, err := transport.RoundTrip(newRequest(t, `{"model": "m"}`)); err == nil {
+		t.Fatal("request without fixture succeeded")
+	}
+	if unmatched := transport.Unmatched(); len(unmatched) != 1 || !strings.Contains(unmatched[0], "api.example.com") {
+		t.Errorf("Unmatched() = %v", unmatched)
+	}
+}
+
+func TestIncompleteSuffix(t *testing.T) {
+	tests := map[string]int{
+		"":                 0,
+		"abc":              0,
+		"é":                0,
+		"a\xc3":            1,
+		"\xe6\x97":         2,
+		"\xf0\x9f\x8e":     3,
+		"\xf0\x9f\x8e\x89": 0,
+		"\x80":             0,
+	}
+	for data, want := range tests {
+		if got := incompleteSuffix([]byte(data)); got != want {
+			t.Errorf("incompleteSuffix(%q) = %d, want %d", data, got, want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package multi_ai_client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/icza/dyno"
+)
+
+// StreamEvent is a struct representing a single parsed event of a response
+// stream. Events that carry no delta, usage, or finish reason are skipped.
+type StreamEvent struct {
+	Delta        string
+	Usage        *Usage
+	FinishReason string
+}
+
+// EventStream is an interface representing the stream of events of a
+// response.
+type EventStream interface {
+	// Next returns the next event of the stream. It returns io.EOF when the
+	// stream ended normally, and io.ErrUnexpectedEOF when the stream of an
+	// API ended before the API reported that the response is complete.
+	Next() (StreamEvent, error)
+
+	// Close releases the resources of the stream.
+	Close() error
+}
+
+// NewEventStream creates an EventStream that returns the given events.
+// It is useful to answer a request without contacting the API.
+func NewEventStream(events ...StreamEvent) EventStream {
+	return &sliceStream{events: events}
+}
+
+type sliceStream struct {
+	events []StreamEvent
+}
+
+func (s *sliceStream) Next() (StreamEvent, error) {
+	if len(s.events) == 0 {
+		return StreamEvent{}, io.EOF
+	}
+	event := s.events[0]
+	s.events = s.events[1:]
+	return event, nil
+}
+
+func (s *sliceStream) Close() error {
+	s.events = nil
+	return nil
+}
+
+// sseStream is an EventStream parsing a server-sent event stream as returned
+// by the supported APIs.
+type sseStream struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	done    bool
+
+	// terminated is set once the stream reported that the response is
+	// complete, so it may end.
+	terminated bool
+
+	// model is the name of the model definition the stream belongs to, used
+	// in errors.
+	model string
+
+	// onEnd is called once when the stream ended normally, after the
+	// terminator of the API.
+	onEnd func()
+
+	// logger receives problems with the stream. If it is nil, nothing is
+	// logged.
+	logger   *slog.Logger
+	ctx      context.Context
+	redactor redactor
+}
+
+func newSSEStream(body io.ReadCloser) *sseStream {
+	return &sseStream{
+		body:    body,
+		scanner: bufio.NewScanner(body),
+	}
+}
+
+func (s *sseStream) Next() (StreamEvent, error) {
+	for !s.done {
+		if !s.scanner.Scan() {
+			if err := s.scanner.Err(); err != nil {
+				return StreamEvent{}, err
+			}
+			if !s.terminated {
+				// The stream was cut off before the response was complete.
+				s.done = true
+				return StreamEvent{}, io.ErrUnexpectedEOF
+			}
+			s.end()
+			break
+		}
+
+		t := strings.TrimSpace(s.scanner.Text())
+		if strings.Index(t, "data: ") != 0 {
+			continue
+		}
+		if t == "data: [DONE]" {
+			s.end()
+			break
+		}
+		t = strings.TrimPrefix(t, "data: ")
+		var data interface{}
+		if err := json.Unmarshal([]byte(t), &data); err != nil {
+			s.log(slog.LevelWarn, "unparseable stream line",
+				slog.String("line", s.redactor.redactText(t)),
+				slog.String("error", err.Error()))
+			continue
+		}
+		if err := s.streamError(data); err != nil {
+			s.log(slog.LevelWarn, "error event in stream", slog.String("message", s.redactor.redactString(err.Message)))
+			s.done = true
+			return StreamEvent{}, err
+		}
+		eventType, _ := dyno.GetString(data, "type")
+		if eventType == "message_stop" {
+			s.end()
+			break
+		}
+		if eventType != "" && !knownEventTypes[eventType] {
+			s.log(slog.LevelDebug, "unknown stream event type", slog.String("type", eventType))
+		}
+		if _, err := dyno.GetString(data, "delta", "stop_reason"); err == nil {
+			// Anthropic may close the stream without message_stop once the
+			// response stopped.
+			s.terminated = true
+		}
+		event, ok := parseEvent(data)
+		if ok {
+			return event, nil
+		}
+	}
+	return StreamEvent{}, io.EOF
+}
+
+// streamError returns the error an event reports, or nil if it is no error.
+// Anthropic sends errors as events of type "error", OpenAI and Mistral as an
+// error object without a type.
+func (s *sseStream) streamError(data interface{}) *StreamError {
+	eventType, err := dyno.GetString(data, "type")
+	typed := err == nil
+	if typed && eventType != "error" {
+		return nil
+	}
+	object, err := dyno.Get(data, "error")
+	if err != nil || object == nil {
+		if typed {
+			return &StreamError{Model: s.model}
+		}
+		return nil
+	}
+	if message, ok := object.(string); ok {
+		return &StreamError{Model: s.model, Message: message}
+	}
+	errorType, _ := dyno.GetString(object, "type")
+	message, _ := dyno.GetString(object, "message")
+	return &StreamError{Model: s.model, Type: errorType, Message: message}
+}
+
+func (s *sseStream) end() {
+	s.done = true
+	if s.onEnd != nil {
+		s.onEnd()
+		s.onEnd = nil
+	}
+}
+
+func (s *sseStream) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	if s.logger == nil {
+		return
+	}
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (s *sseStream) Close() error {
+	s.done = true
+	return s.body.Close()
+}
+
+// parseEvent parses the decoded data of a single server-sent event. It
+// returns false if the data carries nothing of interest.
+func parseEvent(data interface{}) (StreamEvent, bool) {
+	event := StreamEvent{}
+	if s, err := dyno.GetString(data, "choices", 0, "delta", "content"); err == nil {
+		event.Delta = s
+	} else if s, err := dyno.GetString(data, "delta", "text"); err == nil {
+		event.Delta = s
+	}
+	if s, err := dyno.GetString(data, "choices", 0, "finish_reason"); err == nil {
+		event.FinishReason = s
+	} else if s, err := dyno.GetString(data, "delta", "stop_reason"); err == nil {
+		event.FinishReason = s
+	}
+	event.Usage = parseUsage(data)
+
+	return event, event.Delta != "" || event.Usage != nil || event.FinishReason != ""
+}
@@ -0,0 +1,228 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/villadelfia/multi-ai-client/replay"
+)
+
+var record = flag.Bool("record", false, "record the stream fixtures from the APIs, with the keys in OPENAI_API_KEY, MISTRAL_API_KEY and ANTHROPIC_API_KEY")
+
+const (
+	fixtureDir = "testdata/fixtures"
+	// fixtureKey is the API key used to replay the fixtures. It must never be
+	// written to them.
+	fixtureKey = "sk-fixture-secret"
+)
+
+// streamDefinitions returns the model definitions the stream fixtures were
+// recorded with.
+func streamDefinitions() []ModelDefinition {
+	key := func(env string) string {
+		if *record {
+			return os.Getenv(env)
+		}
+		return fixtureKey
+	}
+	definitions := []ModelDefinition{
+		NewModelDefinition("GPT", OpenAI, key("OPENAI_API_KEY"), "gpt-4o-mini"),
+		NewModelDefinition("Mistral", Mistral, key("MISTRAL_API_KEY"), "mistral-small-latest"),
+		NewModelDefinition("Claude", Anthropic, key("ANTHROPIC_API_KEY"), "claude-3-5-haiku-20241022"),
+	}
+	for _, definition := range definitions {
+		_ = definition.ModelSettings.Set("max_tokens", 16)
+		_ = definition.ModelSettings.Set("temperature", 0.0)
+	}
+	return definitions
+}
+
+// streamChunks sends the chat to the model definitions through transport and
+// returns the last chunk of every response and the text of every response.
+func streamChunks(t *testing.T, transport http.RoundTripper, chat Chat, definitions []ModelDefinition) ([]MessageChunk, []string) {
+	t.Helper()
+	client := &Client{HTTPClient: &http.Client{Transport: transport}}
+	n, ch, err := client.CreateResponseForChat(context.Background(), &chat, definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := make([]MessageChunk, n)
+	texts := make([]strings.Builder, n)
+	for chunk := range ch {
+		if chunk.Done {
+			last[chunk.Index] = chunk
+		} else {
+			texts[chunk.Index].WriteString(chunk.Delta)
+		}
+	}
+	result := make([]string, n)
+	for i := range texts {
+		result[i] = texts[i].String()
+	}
+	return last, result
+}
+
+func TestStreamFixtures(t *testing.T) {
+	mode := replay.Replay
+	if *record {
+		mode = replay.Record
+	}
+	transport := replay.NewTransport(mode, fixtureDir)
+	chat := Chat{}
+	chat.AddUserMessage("Say hello.")
+
+	last, texts := streamChunks(t, transport, chat, streamDefinitions())
+	if *record {
+		return
+	}
+
+	tests := []struct {
+		text         string
+		usage        Usage
+		finishReason string
+	}{
+		{"Hello! How can I help you today?", Usage{InputTokens: 11, OutputTokens: 10}, "stop"},
+		{"Hello! How can I assist you today?", Usage{InputTokens: 7, OutputTokens: 10}, "stop"},
+		{"Hello! How are you doing today?", Usage{InputTokens: 11, OutputTokens: 11}, "end_turn"},
+	}
+	for i, test := range tests {
+		chunk := last[i]
+		if chunk.Err != nil {
+			t.Errorf("response %d: %v", i, chunk.Err)
+			continue
+		}
+		if texts[i] != test.text {
+			t.Errorf("response %d: text %q, want %q", i, texts[i], test.text)
+		}
+		if chunk.Usage == nil || *chunk.Usage != test.usage {
+			t.Errorf("response %d: usage %+v, want %+v", i, chunk.Usage, test.usage)
+		}
+		if chunk.FinishReason != test.finishReason {
+			t.Errorf("response %d: finish reason %q, want %q", i, chunk.FinishReason, test.finishReason)
+		}
+	}
+	if unmatched := transport.Unmatched(); len(unmatched) != 0 {
+		t.Errorf("unmatched requests: %v", unmatched)
+	}
+}
+
+func TestStreamFixtureUnmatched(t *testing.T) {
+	if *record {
+		t.Skip("recording")
+	}
+	transport := replay.NewTransport(replay.Replay, fixtureDir)
+	chat := Chat{}
+	chat.AddUserMessage("Say goodbye.")
+
+	last, _ := streamChunks(t, transport, chat, streamDefinitions())
+	for i, chunk := range last {
+		if chunk.Err == nil || !strings.Contains(chunk.Err.Error(), "no fixture") {
+			t.Errorf("response %d: error %v, want a missing fixture", i, chunk.Err)
+		}
+	}
+	if unmatched := transport.Unmatched(); len(unmatched) != len(last) {
+		t.Errorf("unmatched requests: %v", unmatched)
+	}
+}
+
+func TestStreamFixturesHaveNoKeys(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(fixtureDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no fixtures in " + fixtureDir)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, []byte(fixtureKey)) {
+			t.Errorf("%s contains the API key", file)
+		}
+		for _, header := range []string{"authorization", "x-api-key"} {
+			if bytes.Contains(bytes.ToLower(data), []byte(`"`+header+`"`)) {
+				t.Errorf("%s contains the %s header", file, header)
+			}
+		}
+	}
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+	body := "event: message_start\n" +
+		`data: {"type": "message_start", "message": {"usage": {"input_tokens": 10, "output_tokens": 1}}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type": "content_block_delta", "index": 0, "delta": {"type": "text_delta", "text": "Hel"}}` + "\n\n" +
+		"event: error\n" +
+		`data: {"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}` + "\n\n"
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+	chat := Chat{}
+	chat.AddUserMessage("Say hello.")
+	last, texts := streamChunks(t, transport, chat, []ModelDefinition{NewModelDefinition("Claude", Anthropic, fixtureKey, "claude-3-5-haiku-20241022")})
+
+	var streamError *StreamError
+	if !errors.As(last[0].Err, &streamError) {
+		t.Fatalf("error %v, want a *StreamError", last[0].Err)
+	}
+	if *streamError != (StreamError{Model: "Claude", Type: "overloaded_error", Message: "Overloaded"}) {
+		t.Errorf("error %+v", *streamError)
+	}
+	if texts[0] != "Hel" {
+		t.Errorf("text %q, want the text before the error", texts[0])
+	}
+}
+
+func TestStreamErrorObject(t *testing.T) {
+	tests := []struct {
+		apiType APIType
+		event   string
+		want    StreamError
+	}{
+		{OpenAI, `data: {"error": {"message": "The server had an error", "type": "server_error", "code": null}}`,
+			StreamError{Model: "Model", Type: "server_error", Message: "The server had an error"}},
+		{Mistral, `data: {"error": {"message": "Service unavailable"}}`,
+			StreamError{Model: "Model", Message: "Service unavailable"}},
+		{OpenAI, `data: {"error": "overloaded"}`,
+			StreamError{Model: "Model", Message: "overloaded"}},
+	}
+	for _, test := range tests {
+		body := `data: {"choices": [{"delta": {"content": "Hel"}}]}` + "\n\n" + test.event + "\n\n"
+		transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Request:    req,
+			}, nil
+		})
+		chat := Chat{}
+		chat.AddUserMessage("Say hello.")
+		last, texts := streamChunks(t, transport, chat, []ModelDefinition{NewModelDefinition("Model", test.apiType, fixtureKey, "model")})
+
+		var streamError *StreamError
+		if !errors.As(last[0].Err, &streamError) {
+			t.Errorf("%s: error %v, want a *StreamError", test.event, last[0].Err)
+			continue
+		}
+		if *streamError != test.want {
+			t.Errorf("%s: error %+v, want %+v", test.event, *streamError, test.want)
+		}
+		if texts[0] != "Hel" {
+			t.Errorf("%s: text %q, want the text before the error", test.event, texts[0])
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package multi_ai_client
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+//go:embed vocab/*.tiktoken.gz
+var vocabFiles embed.FS
+
+// Tokenizer is an interface representing something that can count the amount
+// of tokens a text takes up for a model.
+type Tokenizer interface {
+	// CountTokens returns the (estimated) amount of tokens in the given text.
+	CountTokens(text string) int
+}
+
+// BPETokenizer is a byte pair encoding tokenizer compatible with the tiktoken
+// encodings used by OpenAI. The vocabulary is loaded lazily from the embedded
+// vocab files the first time it is used.
+type BPETokenizer struct {
+	name    string
+	pattern *regexp.Regexp
+	once    sync.Once
+	ranks   map[string]int
+	err     error
+}
+
+// The tiktoken split patterns use a negative lookahead (\s+(?!\S)) that Go's
+// regexp package does not support. It is replaced by a plain \s+ here, and
+// emulated in split.
+var (
+	cl100kBase = &BPETokenizer{
+		name:    "cl100k_base",
+		pattern: regexp.MustCompile(`^(?:(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+)`),
+	}
+	o200kBase = &BPETokenizer{
+		name: "o200k_base",
+		pattern: regexp.MustCompile(`^(?:[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+			`|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?` +
+			`|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+)`),
+	}
+)
+
+// CL100kBase returns the tokenizer for the cl100k_base encoding, used by
+// GPT-4 and GPT-3.5.
+func CL100kBase() *BPETokenizer {
+	return cl100kBase
+}
+
+// O200kBase returns the tokenizer for the o200k_base encoding, used by
+// GPT-4o and the o-series models.
+func O200kBase() *BPETokenizer {
+	return o200kBase
+}
+
+// Name returns the name of the encoding.
+func (t *BPETokenizer) Name() string {
+	return t.name
+}
+
+// Load loads the vocabulary of the encoding. It is called automatically by
+// Encode and CountTokens, but may be called up front to avoid the delay on
+// first use.
+func (t *BPETokenizer) Load() error {
+	t.once.Do(func() {
+		t.ranks, t.err = loadRanks(t.name)
+	})
+	return t.err
+}
+
+// Encode returns the token ids of the given text.
+// If the vocabulary could not be loaded, nil is returned.
+func (t *BPETokenizer) Encode(text string) []int {
+	if t.Load() != nil {
+		return nil
+	}
+	tokens := make([]int, 0, len(text)/3)
+	for _, piece := range t.split(text) {
+		if rank, ok := t.ranks[piece]; ok {
+			tokens = append(tokens, rank)
+			continue
+		}
+		tokens = append(tokens, t.bytePairEncode(piece)...)
+	}
+	return tokens
+}
+
+// CountTokens returns the amount of tokens in the given text.
+// If the vocabulary could not be loaded, an approximation is returned.
+func (t *BPETokenizer) CountTokens(text string) int {
+	if t.Load() != nil {
+		return ApproximateTokenizer{}.CountTokens(text)
+	}
+	return len(t.Encode(text))
+}
+
+func (t *BPETokenizer) split(text string) []string {
+	pieces := make([]string, 0)
+	for len(text) > 0 {
+		loc := t.pattern.FindStringIndex(text)
+		end := 1
+		if loc != nil && loc[1] > 0 {
+			end = loc[1]
+		}
+
+		// Emulate \s+(?!\S): a run of whitespace that is followed by a
+		// non-whitespace character gives its last character to the next piece.
+		piece := text[:end]
+		if end < len(text) && utf8.RuneCountInString(piece) > 1 && isPlainWhitespace(piece) {
+			_, size := utf8.DecodeLastRuneInString(piece)
+			end -= size
+		}
+
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+func (t *BPETokenizer) bytePairEncode(piece string) []int {
+	parts := make([]string, 0, len(piece))
+	for i := 0; i < len(piece); i++ {
+		parts = append(parts, piece[i:i+1])
+	}
+
+	for len(parts) > 1 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i < len(parts)-1; i++ {
+			if rank, ok := t.ranks[parts[i]+parts[i+1]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		parts[best] += parts[best+1]
+		parts = append(parts[:best+1], parts[best+2:]...)
+	}
+
+	tokens := make([]int, 0, len(parts))
+	for _, part := range parts {
+		tokens = append(tokens, t.ranks[part])
+	}
+	return tokens
+}
+
+func isPlainWhitespace(s string) bool {
+	for _, r := range s {
+		if !unicode.IsSpace(r) || r == '\r' || r == '\n' {
+			return false
+		}
+	}
+	return true
+}
+
+func loadRanks(name string) (map[string]int, error) {
+	f, err := vocabFiles.Open("vocab/" + name + ".tiktoken.gz")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ranks := make(map[string]int, 200000)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		sep := bytes.IndexByte(line, ' ')
+		if sep < 0 {
+			return nil, errors.New("invalid line in vocab file " + name)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(line[:sep]))
+		if err != nil {
+			return nil, err
+		}
+		rank, err := strconv.Atoi(string(line[sep+1:]))
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(token)] = rank
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ranks, nil
+}
+
+// ApproximateTokenizer estimates the amount of tokens in a text based on its
+// length. It is used for models whose tokenizer is not publicly available.
+type ApproximateTokenizer struct {
+	// CharsPerToken is the average amount of characters per token.
+	// If it is 0, a value of 4 is used.
+	CharsPerToken float64
+}
+
+// CountTokens returns the estimated amount of tokens in the given text.
+func (t ApproximateTokenizer) CountTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+	charsPerToken := t.CharsPerToken
+	if charsPerToken <= 0 {
+		charsPerToken = 4
+	}
+	return int(math.Ceil(float64(utf8.RuneCountInString(text)) / charsPerToken))
+}
+
+// NewTokenizer returns the tokenizer best suited to the given API type and
+// model name.
+// OpenAI models use their exact BPE encoding, other providers use an
+// approximation tuned to their tokenizers.
+func NewTokenizer(apiType APIType, modelName string) Tokenizer {
+	switch apiType {
+	case OpenAI:
+		for _, prefix := range []string{"gpt-4o", "chatgpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"} {
+			if strings.HasPrefix(modelName, prefix) {
+				return O200kBase()
+			}
+		}
+		return CL100kBase()
+	case Anthropic:
+		return ApproximateTokenizer{CharsPerToken: 3.5}
+	case Mistral:
+		return ApproximateTokenizer{CharsPerToken: 3.7}
+	default:
+		return ApproximateTokenizer{}
+	}
+}
@@ -0,0 +1,66 @@
+package multi_ai_client
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestBPETokenizerGolden compares the tokens of the BPE tokenizers with the
+// tokens of the reference tiktoken implementation, in testdata/tokens.json.
+func TestBPETokenizerGolden(t *testing.T) {
+	data, err := os.ReadFile("testdata/tokens.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var goldens []struct {
+		Text   string           `json:"text"`
+		Tokens map[string][]int `json:"tokens"`
+	}
+	if err := json.Unmarshal(data, &goldens); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tokenizer := range []*BPETokenizer{CL100kBase(), O200kBase()} {
+		if err := tokenizer.Load(); err != nil {
+			t.Fatal(err)
+		}
+		for _, golden := range goldens {
+			want, ok := golden.Tokens[tokenizer.Name()]
+			if !ok {
+				t.Fatalf("no %s tokens for %q", tokenizer.Name(), golden.Text)
+			}
+			got := tokenizer.Encode(golden.Text)
+			if len(got) == 0 && len(want) == 0 {
+				continue
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: %q encodes to %v, want %v", tokenizer.Name(), golden.Text, got, want)
+			}
+			if count := tokenizer.CountTokens(golden.Text); count != len(want) {
+				t.Errorf("%s: %q counts %d tokens, want %d", tokenizer.Name(), golden.Text, count, len(want))
+			}
+		}
+	}
+}
+
+func TestNewTokenizer(t *testing.T) {
+	tests := []struct {
+		apiType   APIType
+		modelName string
+		want      Tokenizer
+	}{
+		{OpenAI, "gpt-4o-mini", O200kBase()},
+		{OpenAI, "o3-mini", O200kBase()},
+		{OpenAI, "gpt-4-turbo", CL100kBase()},
+		{OpenAI, "gpt-3.5-turbo", CL100kBase()},
+		{Anthropic, "claude-3-5-haiku-20241022", ApproximateTokenizer{CharsPerToken: 3.5}},
+		{Mistral, "mistral-small-latest", ApproximateTokenizer{CharsPerToken: 3.7}},
+	}
+	for _, test := range tests {
+		if got := NewTokenizer(test.apiType, test.modelName); got != test.want {
+			t.Errorf("NewTokenizer(%v, %q) = %v, want %v", test.apiType, test.modelName, got, test.want)
+		}
+	}
+}
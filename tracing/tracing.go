@@ -0,0 +1,155 @@
+// Package tracing provides OpenTelemetry tracing for a multi_ai_client.Client.
+//
+// Every call of CreateResponse gets a parent span, with a child span per
+// model definition carrying the attributes of the GenAI semantic conventions:
+//
+//	client.Observe(tracing.NewObserver(otel.GetTracerProvider()))
+//
+// Spans are started from the context passed to CreateResponseContext, so they
+// join the trace of the caller.
+//
+// The package is a module of its own, so only applications that use it depend
+// on OpenTelemetry.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	mac "github.com/villadelfia/multi-ai-client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName is the instrumentation scope name of the tracer.
+const ScopeName = "github.com/villadelfia/multi-ai-client/tracing"
+
+// Attribute keys of the GenAI semantic conventions.
+const (
+	AttrSystem                = attribute.Key("gen_ai.system")
+	AttrOperationName         = attribute.Key("gen_ai.operation.name")
+	AttrRequestModel          = attribute.Key("gen_ai.request.model")
+	AttrRequestTemperature    = attribute.Key("gen_ai.request.temperature")
+	AttrRequestMaxTokens      = attribute.Key("gen_ai.request.max_tokens")
+	AttrUsageInputTokens      = attribute.Key("gen_ai.usage.input_tokens")
+	AttrUsageOutputTokens     = attribute.Key("gen_ai.usage.output_tokens")
+	AttrResponseFinishReasons = attribute.Key("gen_ai.response.finish_reasons")
+)
+
+// Attribute keys specific to this library.
+const (
+	AttrModelDefinition  = attribute.Key("multi_ai_client.model_definition")
+	AttrModelCount       = attribute.Key("multi_ai_client.model_count")
+	AttrCached           = attribute.Key("multi_ai_client.cached")
+	AttrUsageEstimated   = attribute.Key("multi_ai_client.usage.estimated")
+	AttrUsageCachedInput = attribute.Key("multi_ai_client.usage.cached_input_tokens")
+	AttrCost             = attribute.Key("multi_ai_client.cost")
+	AttrHTTPStatusCode   = attribute.Key("http.response.status_code")
+)
+
+// Names of the events added to the span of a model.
+const (
+	// EventFirstToken is added when the first text of the response arrives.
+	EventFirstToken = "gen_ai.first_token"
+	// EventError is added when the request fails.
+	EventError = "gen_ai.error"
+)
+
+const (
+	responseSpanName = "multi_ai_client.CreateResponse"
+	operationName    = "chat"
+)
+
+// Observer is a mac.Observer that records spans.
+type Observer struct {
+	tracer trace.Tracer
+}
+
+// NewObserver creates a new Observer recording spans with a tracer of the
+// given provider.
+func NewObserver(provider trace.TracerProvider) *Observer {
+	return &Observer{tracer: provider.Tracer(ScopeName)}
+}
+
+func (o *Observer) ResponseStarted(ctx context.Context, chat mac.Chat, modelDefinitions []mac.ModelDefinition) context.Context {
+	ctx, _ = o.tracer.Start(ctx, responseSpanName,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(AttrModelCount.Int(len(modelDefinitions))))
+	return ctx
+}
+
+func (o *Observer) RequestStarted(ctx context.Context, exchange *mac.Exchange) context.Context {
+	m := exchange.ModelDefinition
+	attrs := []attribute.KeyValue{
+		AttrSystem.String(System(m.APISettings.APIType)),
+		AttrOperationName.String(operationName),
+		AttrRequestModel.String(m.GetModelName()),
+		AttrModelDefinition.String(m.Name),
+	}
+	if t, ok := m.GetTemperature(); ok {
+		attrs = append(attrs, AttrRequestTemperature.Float64(t))
+	}
+	if n := m.GetMaxTokens(); n > 0 {
+		attrs = append(attrs, AttrRequestMaxTokens.Int(n))
+	}
+	ctx, _ = o.tracer.Start(ctx, operationName+" "+m.GetModelName(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...))
+	return ctx
+}
+
+func (o *Observer) FirstToken(ctx context.Context, exchange *mac.Exchange) {
+	trace.SpanFromContext(ctx).AddEvent(EventFirstToken)
+}
+
+func (o *Observer) RequestFinished(ctx context.Context, exchange *mac.Exchange, chunk mac.MessageChunk) {
+	span := trace.SpanFromContext(ctx)
+	defer span.End()
+
+	span.SetAttributes(AttrCached.Bool(exchange.Cached))
+	if chunk.Err != nil {
+		attrs := []attribute.KeyValue{attribute.String("error.message", chunk.Err.Error())}
+		var apiError *mac.APIError
+		if errors.As(chunk.Err, &apiError) {
+			span.SetAttributes(AttrHTTPStatusCode.Int(apiError.StatusCode))
+			attrs = append(attrs, attribute.String("error.type", strconv.Itoa(apiError.StatusCode)))
+		}
+		span.AddEvent(EventError, trace.WithAttributes(attrs...))
+		span.RecordError(chunk.Err)
+		span.SetStatus(codes.Error, chunk.Err.Error())
+		return
+	}
+
+	if chunk.Usage != nil {
+		span.SetAttributes(
+			AttrUsageInputTokens.Int(chunk.Usage.InputTokens),
+			AttrUsageOutputTokens.Int(chunk.Usage.OutputTokens),
+			AttrUsageCachedInput.Int(chunk.Usage.CachedInputTokens),
+			AttrUsageEstimated.Bool(chunk.Usage.Estimated),
+		)
+	}
+	if chunk.FinishReason != "" {
+		span.SetAttributes(AttrResponseFinishReasons.StringSlice([]string{chunk.FinishReason}))
+	}
+	span.SetAttributes(AttrCost.Float64(chunk.Cost))
+}
+
+func (o *Observer) ResponseFinished(ctx context.Context) {
+	trace.SpanFromContext(ctx).End()
+}
+
+// System returns the value of the gen_ai.system attribute for an API type.
+func System(apiType mac.APIType) string {
+	switch apiType {
+	case mac.OpenAI:
+		return "openai"
+	case mac.Mistral:
+		return "mistral_ai"
+	case mac.Anthropic:
+		return "anthropic"
+	default:
+		return apiType.String()
+	}
+}
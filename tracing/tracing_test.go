@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	mac "github.com/villadelfia/multi-ai-client"
+	"github.com/villadelfia/multi-ai-client/fake"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// respond answers a chat with the model definitions, with a tracing observer
+// recording to an in-memory exporter, and returns the recorded spans.
+func respond(t *testing.T, ctx context.Context, definitions ...mac.ModelDefinition) tracetest.SpanStubs {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	client := &mac.Client{}
+	client.Observe(NewObserver(provider))
+
+	chat := mac.Chat{}
+	chat.AddUserMessage("Hi!")
+	_, ch, err := client.CreateResponseForChat(ctx, &chat, definitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+	}
+	return exporter.GetSpans()
+}
+
+// attributes returns the attributes of a span by key.
+func attributes(span tracetest.SpanStub) map[attribute.Key]attribute.Value {
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	return attrs
+}
+
+// hasEvent returns whether the span has an event with the given name.
+func hasEvent(span tracetest.SpanStub, name string) bool {
+	for _, event := range span.Events {
+		if event.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSpans(t *testing.T) {
+	provider := fake.NewProvider()
+	response := fake.Text("Hello there.")
+	response.Usage = &mac.Usage{InputTokens: 5, OutputTokens: 2}
+	provider.Script("small-model", response)
+	provider.Script("failing-model", fake.Status(http.StatusTooManyRequests, "slow down"))
+	small := provider.NewModelDefinition("small", "small-model")
+	if err := small.ModelSettings.Set("max_tokens", 64); err != nil {
+		t.Fatal(err)
+	}
+	failing := provider.NewModelDefinition("failing", "failing-model")
+
+	// The spans join the trace of the caller.
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), parent)
+	spans := respond(t, ctx, small, failing)
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+
+	byName := make(map[string]tracetest.SpanStub)
+	for _, span := range spans {
+		byName[span.Name] = span
+		if span.SpanContext.TraceID() != parent.TraceID() {
+			t.Errorf("span %s is not part of the trace of the caller", span.Name)
+		}
+	}
+	root, ok := byName[responseSpanName]
+	if !ok {
+		t.Fatalf("no span %s in %v", responseSpanName, byName)
+	}
+	if root.Parent.SpanID() != parent.SpanID() {
+		t.Errorf("the parent of %s is %s, want the span of the caller", root.Name, root.Parent.SpanID())
+	}
+	if got := attributes(root)[AttrModelCount].AsInt64(); got != 2 {
+		t.Errorf("model count %d, want 2", got)
+	}
+
+	succeeded, found := byName["chat small-model"]
+	if !found {
+		t.Fatalf("no span for small-model in %v", byName)
+	}
+	if succeeded.Parent.SpanID() != root.SpanContext.SpanID() {
+		t.Error("the span of a model is not a child of the span of the response")
+	}
+	if succeeded.SpanKind != trace.SpanKindClient {
+		t.Errorf("span kind %v, want client", succeeded.SpanKind)
+	}
+	attrs := attributes(succeeded)
+	want := map[attribute.Key]attribute.Value{
+		AttrSystem:            attribute.StringValue(System(small.APISettings.APIType)),
+		AttrOperationName:     attribute.StringValue("chat"),
+		AttrRequestModel:      attribute.StringValue("small-model"),
+		AttrModelDefinition:   attribute.StringValue("small"),
+		AttrRequestMaxTokens:  attribute.IntValue(64),
+		AttrUsageInputTokens:  attribute.IntValue(5),
+		AttrUsageOutputTokens: attribute.IntValue(2),
+		AttrUsageEstimated:    attribute.BoolValue(false),
+		AttrCached:            attribute.BoolValue(false),
+	}
+	for key, value := range want {
+		if attrs[key] != value {
+			t.Errorf("%s = %v, want %v", key, attrs[key].Emit(), value.Emit())
+		}
+	}
+	if reasons := attrs[AttrResponseFinishReasons].AsStringSlice(); len(reasons) != 1 || reasons[0] != "stop" {
+		t.Errorf("finish reasons %v, want [stop]", reasons)
+	}
+	if !hasEvent(succeeded, EventFirstToken) {
+		t.Errorf("no %s event", EventFirstToken)
+	}
+	if hasEvent(succeeded, EventError) || succeeded.Status.Code == codes.Error {
+		t.Error("the successful request has an error")
+	}
+
+	failed, found := byName["chat failing-model"]
+	if !found {
+		t.Fatalf("no span for failing-model in %v", byName)
+	}
+	if !hasEvent(failed, EventError) {
+		t.Errorf("no %s event", EventError)
+	}
+	if hasEvent(failed, EventFirstToken) {
+		t.Errorf("the failed request has a %s event", EventFirstToken)
+	}
+	if failed.Status.Code != codes.Error {
+		t.Errorf("status %v, want error", failed.Status)
+	}
+	if got := attributes(failed)[AttrHTTPStatusCode].AsInt64(); got != http.StatusTooManyRequests {
+		t.Errorf("status code %d, want %d", got, http.StatusTooManyRequests)
+	}
+}
+
+func TestSystem(t *testing.T) {
+	tests := map[mac.APIType]string{
+		mac.OpenAI:    "openai",
+		mac.Mistral:   "mistral_ai",
+		mac.Anthropic: "anthropic",
+	}
+	for apiType, want := range tests {
+		if got := System(apiType); got != want {
+			t.Errorf("System(%v) = %q, want %q", apiType, got, want)
+		}
+	}
+}
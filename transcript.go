@@ -0,0 +1,131 @@
+package multi_ai_client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case SystemMessage:
+		return "system"
+	case UserMessage:
+		return "user"
+	case AssistantMessage:
+		return "assistant"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseMessageType returns the MessageType with the given role name: system,
+// user or assistant.
+func ParseMessageType(s string) (MessageType, error) {
+	switch s {
+	case "system":
+		return SystemMessage, nil
+	case "user":
+		return UserMessage, nil
+	case "assistant":
+		return AssistantMessage, nil
+	default:
+		return 0, errors.New("unknown message role: " + s)
+	}
+}
+
+// TranscriptMessage is a struct representing a message in a transcript.
+type TranscriptMessage struct {
+	// Role is system, user or assistant.
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Transcript is a struct representing a chat as it is saved to a file.
+// It is written as JSON in the shape of a chat completion request:
+//
+//	{"messages": [{"role": "system", "content": "..."}, ...]}
+type Transcript struct {
+	Messages []TranscriptMessage `json:"messages"`
+}
+
+// NewTranscript returns the transcript of a chat. Messages that were compacted
+// are included in their original form, so nothing is lost.
+func NewTranscript(chat *Chat) Transcript {
+	transcript := Transcript{Messages: make([]TranscriptMessage, 0)}
+	if chat.systemMessage != nil {
+		transcript.Messages = append(transcript.Messages, TranscriptMessage{Role: "system", Content: chat.systemMessage.Text})
+	}
+	for _, messages := range [][]Message{chat.archive, chat.messages} {
+		for _, m := range messages {
+			transcript.Messages = append(transcript.Messages, TranscriptMessage{Role: m.Type.String(), Content: m.Text})
+		}
+	}
+	return transcript
+}
+
+// Chat creates a new Chat from the transcript.
+func (t Transcript) Chat() (*Chat, error) {
+	messages := make([]Message, 0, len(t.Messages))
+	for i, m := range t.Messages {
+		messageType, err := ParseMessageType(m.Role)
+		if err != nil {
+			return nil, errors.New("message " + strconv.Itoa(i+1) + ": " + err.Error())
+		}
+		messages = append(messages, *NewMessage(messageType, m.Content))
+	}
+	return NewChatFromMessages(messages)
+}
+
+// ReadTranscript reads a transcript and creates a new Chat from it. Both a
+// Transcript and a bare list of its messages are accepted.
+func ReadTranscript(r io.Reader) (*Chat, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	var transcript Transcript
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(trimmed, &transcript.Messages)
+	} else {
+		err = json.Unmarshal(data, &transcript)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return transcript.Chat()
+}
+
+// LoadTranscript reads a transcript from a file and creates a new Chat from
+// it.
+func LoadTranscript(path string) (*Chat, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	chat, err := ReadTranscript(f)
+	if err != nil {
+		return nil, errors.New(path + ": " + err.Error())
+	}
+	return chat, nil
+}
+
+// WriteTranscript writes the transcript of the chat as indented JSON.
+func (c *Chat) WriteTranscript(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(NewTranscript(c))
+}
+
+// SaveTranscript writes the transcript of the chat to a file.
+func (c *Chat) SaveTranscript(path string) error {
+	var buf bytes.Buffer
+	if err := c.WriteTranscript(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,85 @@
+package multi_ai_client
+
+import "github.com/icza/dyno"
+
+// Usage is a struct representing the amount of tokens used by a response.
+type Usage struct {
+	// InputTokens is the total amount of prompt tokens, including cached ones.
+	InputTokens int
+	// CachedInputTokens is the amount of prompt tokens read from the cache of
+	// the provider.
+	CachedInputTokens int
+	// CacheWriteInputTokens is the amount of prompt tokens written to the
+	// cache of the provider. Only Anthropic reports them.
+	CacheWriteInputTokens int
+	// OutputTokens is the amount of tokens in the response.
+	OutputTokens int
+	// Estimated is set when the API did not report usage, and the amounts
+	// were estimated with the tokenizer of the model instead.
+	Estimated bool
+}
+
+// merge updates the usage with all amounts reported in other.
+// Providers report usage spread over several events, with later events
+// holding the running totals.
+func (u *Usage) merge(other *Usage) {
+	if other.InputTokens > 0 {
+		u.InputTokens = other.InputTokens
+	}
+	if other.CachedInputTokens > 0 {
+		u.CachedInputTokens = other.CachedInputTokens
+	}
+	if other.CacheWriteInputTokens > 0 {
+		u.CacheWriteInputTokens = other.CacheWriteInputTokens
+	}
+	if other.OutputTokens > 0 {
+		u.OutputTokens = other.OutputTokens
+	}
+}
+
+// parseUsage returns the usage reported in a stream event, or nil if it does
+// not contain any.
+func parseUsage(data interface{}) *Usage {
+	// OpenAI and Mistral report usage in the last event.
+	if usage, err := dyno.GetMapS(data, "usage"); err == nil && usage != nil {
+		if _, err := dyno.GetInteger(usage, "prompt_tokens"); err == nil {
+			u := &Usage{}
+			if n, err := dyno.GetInteger(usage, "prompt_tokens"); err == nil {
+				u.InputTokens = int(n)
+			}
+			if n, err := dyno.GetInteger(usage, "completion_tokens"); err == nil {
+				u.OutputTokens = int(n)
+			}
+			if n, err := dyno.GetInteger(usage, "prompt_tokens_details", "cached_tokens"); err == nil {
+				u.CachedInputTokens = int(n)
+			}
+			return u
+		}
+	}
+
+	// Anthropic reports input usage in message_start and output usage in
+	// message_delta.
+	usage, err := dyno.GetMapS(data, "message", "usage")
+	if err != nil || usage == nil {
+		usage, err = dyno.GetMapS(data, "usage")
+		if err != nil || usage == nil {
+			return nil
+		}
+	}
+	u := &Usage{}
+	if n, err := dyno.GetInteger(usage, "input_tokens"); err == nil {
+		u.InputTokens = int(n)
+	}
+	if n, err := dyno.GetInteger(usage, "cache_read_input_tokens"); err == nil {
+		u.CachedInputTokens = int(n)
+		u.InputTokens += int(n)
+	}
+	if n, err := dyno.GetInteger(usage, "cache_creation_input_tokens"); err == nil {
+		u.CacheWriteInputTokens = int(n)
+		u.InputTokens += int(n)
+	}
+	if n, err := dyno.GetInteger(usage, "output_tokens"); err == nil {
+		u.OutputTokens = int(n)
+	}
+	return u
+}
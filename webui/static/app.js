@@ -0,0 +1,537 @@
+"use strict";
+
+// The token of the gateway, if it requires one, is passed once as ?token= and
+// kept for the session.
+const token = (() => {
+  const params = new URLSearchParams(location.search);
+  if (params.has("token")) {
+    sessionStorage.setItem("token", params.get("token"));
+    history.replaceState(null, "", location.pathname);
+  }
+  return sessionStorage.getItem("token") || "";
+})();
+
+// A turn is a prompt with the answers of all models it was sent to:
+//   {id, user, models, candidates, picked, answer, running, editing}
+// A candidate is the answer of one model:
+//   {model, text, status, error, usage, cost, cached, started, latency}
+// status is streaming, ok, error or cancelled. answer is the text of the
+// picked candidate, which the user may have edited.
+const state = {
+  models: [],
+  selected: new Set(),
+  turns: [],
+  nextId: 1,
+};
+
+const $ = (id) => document.getElementById(id);
+
+function setStatus(text) {
+  $("status").textContent = text || "";
+}
+
+function element(tag, props, ...children) {
+  const node = document.createElement(tag);
+  Object.assign(node, props || {});
+  for (const child of children) {
+    if (child !== null && child !== undefined) {
+      node.append(child);
+    }
+  }
+  return node;
+}
+
+// Models.
+
+async function loadModels() {
+  const headers = token ? { Authorization: "Bearer " + token } : {};
+  let response;
+  try {
+    response = await fetch("/v1/models", { headers });
+  } catch (err) {
+    setStatus("Could not reach the server: " + err.message);
+    return;
+  }
+  if (!response.ok) {
+    setStatus(response.status === 401
+      ? "The server requires a token. Open this page with ?token=YOUR_TOKEN."
+      : "Could not load the models: " + response.status);
+    return;
+  }
+  state.models = (await response.json()).data;
+  const saved = JSON.parse(localStorage.getItem("models") || "null");
+  const names = state.models.map((m) => m.id);
+  state.selected = new Set(saved ? saved.filter((name) => names.includes(name)) : names);
+  if (state.selected.size === 0) {
+    state.selected = new Set(names);
+  }
+  renderModels();
+}
+
+function renderModels() {
+  const container = $("models");
+  container.replaceChildren();
+  for (const model of state.models) {
+    const checkbox = element("input", { type: "checkbox", checked: state.selected.has(model.id) });
+    checkbox.addEventListener("change", () => {
+      if (checkbox.checked) {
+        state.selected.add(model.id);
+      } else {
+        state.selected.delete(model.id);
+      }
+      localStorage.setItem("models", JSON.stringify([...state.selected]));
+    });
+    container.append(element("label", {}, checkbox, model.id, " ",
+      element("span", { className: "provider", textContent: model.owned_by })));
+  }
+}
+
+function selectedModels() {
+  return state.models.map((m) => m.id).filter((name) => state.selected.has(name));
+}
+
+// Connection.
+
+let socketReady = null;
+
+function connect() {
+  if (socketReady) {
+    return socketReady;
+  }
+  socketReady = new Promise((resolve, reject) => {
+    const url = new URL("/v1/ws", location.href);
+    url.protocol = location.protocol === "https:" ? "wss:" : "ws:";
+    if (token) {
+      url.searchParams.set("token", token);
+    }
+    const socket = new WebSocket(url);
+    socket.addEventListener("open", () => resolve(socket));
+    socket.addEventListener("message", (event) => handleFrame(JSON.parse(event.data)));
+    socket.addEventListener("close", () => {
+      socketReady = null;
+      for (const turn of state.turns) {
+        if (turn.running) {
+          failTurn(turn, "connection lost");
+        }
+      }
+      reject(new Error("could not connect to the server"));
+    });
+  });
+  // Without a handler, a failed connection is reported as unhandled.
+  socketReady.catch(() => {});
+  return socketReady;
+}
+
+async function send(frame) {
+  const socket = await connect();
+  socket.send(JSON.stringify(frame));
+}
+
+function handleFrame(frame) {
+  const turn = state.turns.find((t) => t.id === frame.id);
+  if (!turn) {
+    if (frame.type === "error") {
+      setStatus(frame.error);
+    }
+    return;
+  }
+  switch (frame.type) {
+    case "delta":
+      turn.candidates[frame.index].text += frame.delta;
+      break;
+    case "done": {
+      const candidate = turn.candidates[frame.index];
+      Object.assign(candidate, {
+        status: frame.status,
+        error: frame.error,
+        usage: frame.usage,
+        cost: frame.cost,
+        cached: frame.cached,
+        latency: performance.now() - candidate.started,
+      });
+      break;
+    }
+    case "finished": {
+      turn.running = false;
+      const ok = turn.candidates.filter((c) => c.status === "ok");
+      if (turn.candidates.length === 1 && ok.length === 1) {
+        pick(turn, 0);
+      }
+      updateControls();
+      break;
+    }
+    case "error":
+      // Errors of finished turns are about their preferences.
+      if (!turn.running) {
+        setStatus(frame.error);
+        return;
+      }
+      failTurn(turn, frame.error);
+      return;
+  }
+  renderTurn(turn);
+}
+
+function failTurn(turn, message) {
+  turn.running = false;
+  for (const candidate of turn.candidates) {
+    if (candidate.status === "streaming") {
+      candidate.status = "error";
+      candidate.error = message;
+    }
+  }
+  renderTurn(turn);
+  updateControls();
+}
+
+// Conversation.
+
+function messagesBefore(index) {
+  const messages = [];
+  const system = $("system").value.trim();
+  if (system) {
+    messages.push({ role: "system", content: system });
+  }
+  for (const turn of state.turns.slice(0, index)) {
+    messages.push({ role: "user", content: turn.user });
+    if (turn.answer !== null) {
+      messages.push({ role: "assistant", content: turn.answer });
+    }
+  }
+  return messages;
+}
+
+async function run(index) {
+  const turn = state.turns[index];
+  turn.id = String(state.nextId++);
+  turn.models = selectedModels();
+  turn.candidates = turn.models.map((model) => ({
+    model, text: "", status: "streaming", started: performance.now(),
+  }));
+  turn.picked = null;
+  turn.answer = null;
+  turn.running = true;
+  turn.editing = null;
+  renderTurns();
+  const messages = messagesBefore(index);
+  messages.push({ role: "user", content: turn.user });
+  try {
+    await send({ type: "chat", id: turn.id, models: turn.models, messages });
+  } catch (err) {
+    failTurn(turn, err.message);
+  }
+}
+
+function stop() {
+  for (const turn of state.turns) {
+    if (turn.running) {
+      send({ type: "cancel", id: turn.id }).catch(() => {});
+    }
+  }
+}
+
+// truncate removes the turns after index, stopping their responses.
+function truncate(index) {
+  for (const turn of state.turns.slice(index + 1)) {
+    if (turn.running) {
+      send({ type: "cancel", id: turn.id }).catch(() => {});
+    }
+  }
+  state.turns = state.turns.slice(0, index + 1);
+}
+
+function pick(turn, index) {
+  const position = state.turns.indexOf(turn);
+  if (turn.picked === index) {
+    return;
+  }
+  if (position < state.turns.length - 1) {
+    if (!confirm("The later turns were answered with another answer and will be removed. Continue?")) {
+      return;
+    }
+    truncate(position);
+  }
+  turn.picked = index;
+  turn.answer = turn.candidates[index].text;
+  renderTurns();
+  recordPreference(turn, position, index);
+}
+
+// recordPreference tells the server which answer was picked, so the models can
+// be rated. Answers that failed or were imported are not compared.
+function recordPreference(turn, position, index) {
+  const candidates = turn.candidates.filter((c) => c.status === "ok");
+  if (candidates.length < 2 || !turn.models) {
+    return;
+  }
+  const messages = messagesBefore(position);
+  messages.push({ role: "user", content: turn.user });
+  send({
+    type: "preference",
+    id: turn.id,
+    messages,
+    models: candidates.map((c) => c.model),
+    candidates: candidates.map((c) => c.text),
+    winner: candidates.indexOf(turn.candidates[index]),
+  }).catch(() => {});
+}
+
+function regenerate(turn) {
+  const position = state.turns.indexOf(turn);
+  if (position < state.turns.length - 1 &&
+      !confirm("The later turns will be removed. Continue?")) {
+    return;
+  }
+  if (turn.running) {
+    send({ type: "cancel", id: turn.id }).catch(() => {});
+  }
+  truncate(position);
+  run(position);
+}
+
+function submitPrompt() {
+  const text = $("prompt").value.trim();
+  if (!text) {
+    return;
+  }
+  if (selectedModels().length === 0) {
+    setStatus("Select at least one model.");
+    return;
+  }
+  const last = state.turns[state.turns.length - 1];
+  if (last && (last.running || last.answer === null)) {
+    setStatus(last.running ? "Wait for the answers, or stop them." : "Pick an answer to continue the chat with.");
+    return;
+  }
+  setStatus("");
+  $("prompt").value = "";
+  state.turns.push({ user: text, candidates: [], picked: null, answer: null, running: false, editing: null });
+  run(state.turns.length - 1);
+}
+
+// Rendering.
+
+function describe(candidate) {
+  switch (candidate.status) {
+    case "streaming":
+      return "…";
+    case "cancelled":
+      return "cancelled";
+    case "error":
+      return "error: " + candidate.error;
+  }
+  const parts = [];
+  if (candidate.usage) {
+    parts.push(`${candidate.usage.input_tokens} in / ${candidate.usage.output_tokens} out tokens`);
+  }
+  parts.push("$" + (candidate.cost || 0).toFixed(4));
+  parts.push((candidate.latency / 1000).toFixed(1) + "s");
+  if (candidate.cached) {
+    parts.push("cached");
+  }
+  return parts.join(" · ");
+}
+
+function button(label, onClick, props) {
+  const node = element("button", Object.assign({ type: "button", textContent: label }, props || {}));
+  node.addEventListener("click", onClick);
+  return node;
+}
+
+// editor returns a text area with save and cancel buttons.
+function editor(value, saveLabel, onSave, onCancel) {
+  const area = element("textarea", { value, rows: Math.min(12, value.split("\n").length + 1) });
+  const save = button(saveLabel, () => onSave(area.value.trim()), { className: "primary" });
+  return element("div", {}, area, element("div", { className: "tools" }, button("Cancel", onCancel), save));
+}
+
+function renderTurn(turn) {
+  const node = document.querySelector(`[data-turn="${turn.key}"]`);
+  if (node) {
+    node.replaceWith(turnNode(turn));
+  }
+}
+
+function turnNode(turn) {
+  const position = state.turns.indexOf(turn);
+  const node = element("section", { className: "turn" });
+  node.dataset.turn = turn.key;
+
+  if (turn.editing === "user") {
+    node.append(element("div", { className: "user" }, editor(turn.user, "Resend", (value) => {
+      if (!value) {
+        return;
+      }
+      if (position < state.turns.length - 1 &&
+          !confirm("The later turns will be removed. Continue?")) {
+        return;
+      }
+      turn.user = value;
+      truncate(position);
+      run(position);
+    }, () => {
+      turn.editing = null;
+      renderTurn(turn);
+    })));
+  } else {
+    node.append(element("div", { className: "user" },
+      element("div", { className: "text", textContent: turn.user }),
+      element("div", { className: "tools" },
+        button("Edit", () => {
+          turn.editing = "user";
+          renderTurn(turn);
+        }, { disabled: turn.running }),
+        button("Regenerate", () => regenerate(turn), { disabled: turn.running }))));
+  }
+
+  const columns = element("div", { className: "columns" });
+  turn.candidates.forEach((candidate, index) => {
+    const picked = turn.picked === index;
+    const column = element("article", {
+      className: "candidate" + (picked ? " picked" : "") + (turn.picked !== null && !picked ? " dimmed" : ""),
+    });
+    column.append(element("h2", { textContent: candidate.model }));
+    if (picked && turn.editing === "answer") {
+      column.append(editor(turn.answer, "Save", (value) => {
+        turn.answer = value;
+        turn.editing = null;
+        renderTurn(turn);
+      }, () => {
+        turn.editing = null;
+        renderTurn(turn);
+      }));
+    } else {
+      column.append(element("div", { className: "text", textContent: picked ? turn.answer : candidate.text }));
+    }
+    column.append(element("div", {
+      className: "meta" + (candidate.status === "error" ? " error" : ""),
+      textContent: describe(candidate),
+    }));
+    const tools = element("div", { className: "tools" });
+    if (candidate.status === "ok" && !picked) {
+      tools.append(button("Pick", () => pick(turn, index), { disabled: turn.running }));
+    }
+    if (picked && turn.editing !== "answer") {
+      tools.append(button("Edit", () => {
+        turn.editing = "answer";
+        renderTurn(turn);
+      }));
+    }
+    column.append(tools);
+    columns.append(column);
+  });
+  node.append(columns);
+  return node;
+}
+
+let nextKey = 1;
+
+function renderTurns() {
+  const container = $("turns");
+  container.replaceChildren();
+  for (const turn of state.turns) {
+    if (!turn.key) {
+      turn.key = nextKey++;
+    }
+    container.append(turnNode(turn));
+  }
+  updateControls();
+  window.scrollTo(0, document.body.scrollHeight);
+}
+
+function updateControls() {
+  const running = state.turns.some((t) => t.running);
+  $("send").hidden = running;
+  $("stop").hidden = !running;
+}
+
+// Transcripts.
+
+function exportTranscript() {
+  const messages = messagesBefore(state.turns.length);
+  const last = state.turns[state.turns.length - 1];
+  if (last && last.answer === null) {
+    messages.push({ role: "user", content: last.user });
+  }
+  // The answers that were not picked are kept next to the transcript.
+  const turns = state.turns.map((turn) => ({
+    prompt: turn.user,
+    picked: turn.picked === null ? null : turn.candidates[turn.picked].model,
+    candidates: turn.candidates.map((c) => ({ model: c.model, text: c.text, status: c.status, error: c.error, usage: c.usage, cost: c.cost })),
+  }));
+  const blob = new Blob([JSON.stringify({ messages, turns }, null, 2) + "\n"], { type: "application/json" });
+  const link = element("a", { href: URL.createObjectURL(blob), download: "transcript.json" });
+  link.click();
+  URL.revokeObjectURL(link.href);
+}
+
+async function importTranscript(file) {
+  let data;
+  try {
+    data = JSON.parse(await file.text());
+  } catch (err) {
+    setStatus("Not a transcript: " + err.message);
+    return;
+  }
+  const messages = Array.isArray(data) ? data : data.messages;
+  if (!Array.isArray(messages)) {
+    setStatus("Not a transcript: it has no messages.");
+    return;
+  }
+  stop();
+  const turns = [];
+  let system = "";
+  for (const message of messages) {
+    if (message.role === "system") {
+      system = message.content;
+    } else if (message.role === "user") {
+      turns.push({ user: message.content, candidates: [], picked: null, answer: null, running: false, editing: null });
+    } else if (message.role === "assistant" && turns.length > 0) {
+      const turn = turns[turns.length - 1];
+      turn.candidates = [{ model: "transcript", text: message.content, status: "ok", latency: 0 }];
+      turn.picked = 0;
+      turn.answer = message.content;
+    }
+  }
+  $("system").value = system;
+  $("system-box").open = system !== "";
+  state.turns = turns;
+  renderTurns();
+  setStatus(`Imported ${messages.length} messages.`);
+  const last = turns[turns.length - 1];
+  if (last && last.answer === null) {
+    run(turns.length - 1);
+  }
+}
+
+// Events.
+
+$("prompt-form").addEventListener("submit", (event) => {
+  event.preventDefault();
+  submitPrompt();
+});
+$("prompt").addEventListener("keydown", (event) => {
+  if (event.key === "Enter" && (event.ctrlKey || event.metaKey)) {
+    event.preventDefault();
+    submitPrompt();
+  }
+});
+$("stop").addEventListener("click", stop);
+$("export").addEventListener("click", exportTranscript);
+$("import").addEventListener("change", (event) => {
+  if (event.target.files.length > 0) {
+    importTranscript(event.target.files[0]);
+  }
+  event.target.value = "";
+});
+$("new-chat").addEventListener("click", () => {
+  if (state.turns.length > 0 && !confirm("Start a new chat? Export it first to keep it.")) {
+    return;
+  }
+  stop();
+  state.turns = [];
+  renderTurns();
+  setStatus("");
+});
+
+loadModels();
@@ -0,0 +1,37 @@
+// Package webui provides a web app to compare the answers of several models
+// side by side. It is embedded in the binary, and talks to the WebSocket and
+// model list of a gateway.Server served from the same host:
+//
+//	mux := http.NewServeMux()
+//	mux.Handle("/v1/", gateway.NewServer(client))
+//	mux.Handle("/", webui.Handler())
+//
+// Every prompt is answered by all selected models in columns. Picking the
+// best answer continues the chat with it. Earlier prompts and answers can be
+// edited, and the chat can be exported as a transcript that LoadTranscript
+// reads.
+package webui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler returns a handler serving the web app.
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	fileServer := http.FileServer(http.FS(files))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; connect-src 'self' ws: wss:")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-cache")
+		fileServer.ServeHTTP(w, r)
+	})
+}